@@ -1,54 +1,153 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"minibank/internal/catalog"
+	"minibank/internal/checker"
+	"minibank/internal/execution"
+	"minibank/internal/indexing"
 	"minibank/internal/planner"
 	"minibank/internal/repl"
 	"minibank/internal/storage"
 	"minibank/internal/web_server"
 	"os"
-	"path/filepath"
 )
 
 func main() {
-	mode := flag.String("mode", "repl", "Mode to run: 'repl' or 'server'")
+	mode := flag.String("mode", "repl", "Mode to run: 'repl', 'server', 'check', 'reindex' or 'upgrade'")
 	dataDir := flag.String("data", ".", "Data directory")
 	port := flag.String("port", ":8080", "Server port")
+	dryRun := flag.Bool("dry-run", false, "With -mode upgrade, report what would change without writing")
+	readOnly := flag.Bool("read-only", false, "Open the data directory read-only, without locking it; a statement may see part of one the writer has in progress")
+	sortMemory := flag.Int("sort-memory", execution.DefaultSortMemory>>10, "KiB an ORDER BY may use before it spills to temporary files")
 	flag.Parse()
 
-	cat := catalog.NewCatalog()
-	catalogPath := filepath.Join(*dataDir, "catalog.json")
-	if err := cat.LoadFromFile(catalogPath); err != nil {
-		if !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "Warning: failed to load catalog: %v (starting fresh)\n", err)
-		}
+	// Upgrade runs before the engine is opened: the engine refuses files in
+	// an older format.
+	if *mode == "upgrade" {
+		os.Exit(runUpgrade(*dataDir, *dryRun))
 	}
 
-	store := storage.NewEngine(*dataDir)
+	var store *storage.Engine
+	var err error
+	if *readOnly {
+		store, err = storage.NewReadOnlyEngine(*dataDir)
+	} else {
+		store, err = storage.NewEngine(*dataDir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		if errors.Is(err, storage.ErrDataDirLocked) {
+			fmt.Fprintln(os.Stderr, "Use -read-only to inspect it while the other process is running.")
+		}
+		os.Exit(1)
+	}
 	defer store.Close()
 
+	cat, err := store.OpenCatalog()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load catalog: %v\n", err)
+		store.Close()
+		os.Exit(1)
+	}
+
 	switch *mode {
 	case "repl":
 		r := repl.NewREPL(cat, store, *dataDir)
-		if err := r.Planner.RebuildIndices(); err != nil {
-			fmt.Printf("Failed to rebuild indices: %v\n", err)
+		r.Planner.SortMemory = *sortMemory << 10
+		// Without its indexes, writes would leave them out of date, so the
+		// REPL refuses to start, like the server.
+		if err := r.Planner.OpenIndices(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open indices: %v\n", err)
+			store.Close()
+			os.Exit(1)
 		}
 		r.Run()
 	case "server":
 		pl := planner.NewPlanner(cat, store)
-		if err := pl.RebuildIndices(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to rebuild indices: %v\n", err)
+		pl.SortMemory = *sortMemory << 10
+		if err := pl.OpenIndices(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open indices: %v\n", err)
+			store.Close()
 			os.Exit(1)
 		}
 		srv := web_server.NewServer(pl, cat, *dataDir)
 		if err := srv.Start(*port); err != nil {
 			fmt.Fprintf(os.Stderr, "Server failed: %v\n", err)
+			store.Close()
+			os.Exit(1)
+		}
+	case "check":
+		pl := planner.NewPlanner(cat, store)
+		var indices map[string]indexing.Index
+		if err := pl.OpenIndices(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to open indices, skipping index checks: %v\n", err)
+		} else {
+			indices = pl.Indices
+		}
+		report, err := checker.Run(cat, store, indices)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Check failed: %v\n", err)
+			store.Close()
+			os.Exit(1)
+		}
+		report.Print(os.Stdout)
+		if !report.OK() {
+			store.Close()
+			os.Exit(1)
+		}
+	case "reindex":
+		if err := runReindex(planner.NewPlanner(cat, store)); err != nil {
+			fmt.Fprintf(os.Stderr, "Reindex failed: %v\n", err)
+			store.Close()
 			os.Exit(1)
 		}
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown mode: %s\n", *mode)
+		store.Close()
 		os.Exit(1)
 	}
 }
+
+// runReindex rebuilds every index from its table in one transaction. It is
+// the repair for index problems reported by -mode check.
+func runReindex(pl *planner.Planner) error {
+	if err := pl.OpenIndices(); err != nil {
+		return err
+	}
+	pl.Storage.Begin()
+	if err := pl.RebuildIndices(); err != nil {
+		if abortErr := pl.Storage.Abort(); abortErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, abortErr)
+		}
+		return err
+	}
+	return pl.Storage.Commit()
+}
+
+func runUpgrade(dataDir string, dryRun bool) int {
+	results, err := storage.UpgradeDataDir(dataDir, dryRun)
+	pending := 0
+	for _, u := range results {
+		switch {
+		case !u.NeedsUpgrade():
+			fmt.Printf("%s: already at format version %d\n", u.Table, u.Version)
+		case dryRun:
+			fmt.Printf("%s: format version %d, would copy %d tuples from %d pages into format version %d\n",
+				u.Table, u.Version, u.Tuples, u.Pages, storage.FormatVersion)
+			pending++
+		default:
+			fmt.Printf("%s: upgraded from format version %d to %d (%d tuples, %d pages -> %d pages)\n",
+				u.Table, u.Version, storage.FormatVersion, u.Tuples, u.Pages, u.NewPages)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Upgrade failed: %v\n", err)
+		return 1
+	}
+	if dryRun {
+		fmt.Printf("Dry run: %d of %d tables need upgrading, nothing was written.\n", pending, len(results))
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"minibank/internal/storage"
+	"os"
+	"path/filepath"
+)
+
+// checkBufferPoolEviction runs a pool of a few frames over a file with many
+// more pages, writing each page once and reading them all back, and checks
+// that the clock evicts pages, wrote the dirty ones back on the way out, and
+// that BufferPoolStats counts the hits, misses and evictions.
+func checkBufferPoolEviction() error {
+	dir, err := tempDataDir("pool")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	const capacity, pages = 4, 16
+	pager, err := storage.NewPager(filepath.Join(dir, "pool.data"), storage.FileKindHeap)
+	if err != nil {
+		return err
+	}
+	defer pager.Close()
+	pool := storage.NewBufferPool(capacity)
+
+	for i := 0; i < pages; i++ {
+		id := pager.AllocatePage()
+		page, err := pool.FetchPage(pager, id)
+		if err != nil {
+			return err
+		}
+		page.SetType(storage.PageTypeHeap)
+		page.Data[storage.PageMetaSize] = byte(i + 1)
+		pool.UnpinPage(pager, id, true)
+	}
+	st := pool.Stats()
+	if st.Misses != pages || st.Hits != 0 {
+		return fmt.Errorf("writing %d new pages counted %d misses and %d hits", pages, st.Misses, st.Hits)
+	}
+	if st.Evictions != pages-capacity {
+		return fmt.Errorf("writing %d pages through %d frames evicted %d", pages, capacity, st.Evictions)
+	}
+
+	// The last page is still resident; the first was evicted, so it must come
+	// back from the file with what was written to it.
+	for _, id := range []storage.PageID{pages - 1, 0} {
+		page, err := pool.FetchPage(pager, id)
+		if err != nil {
+			return err
+		}
+		got := page.Data[storage.PageMetaSize]
+		pool.UnpinPage(pager, id, false)
+		if got != byte(id+1) {
+			return fmt.Errorf("page %d reads %d after eviction, want %d", id, got, id+1)
+		}
+	}
+	st = pool.Stats()
+	if st.Hits != 1 || st.Misses != pages+1 {
+		return fmt.Errorf("re-reading a resident and an evicted page counted %d hits and %d misses", st.Hits, st.Misses)
+	}
+	if st.Resident > st.Capacity || st.Capacity != capacity {
+		return fmt.Errorf("pool holds %d pages in %d frames, want at most %d", st.Resident, st.Capacity, capacity)
+	}
+	return nil
+}
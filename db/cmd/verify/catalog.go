@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// legacyCatalog is a catalog.json as the versions before the system tables
+// wrote it: indexes name one "column", and "indexes" may be null.
+const legacyCatalog = `{
+  "tables": {
+    "members": {
+      "name": "members",
+      "columns": [
+        {"name": "id", "type": "INT", "is_primary": true, "is_unique": false},
+        {"name": "email", "type": "STRING", "is_primary": false, "is_unique": true},
+        {"name": "name", "type": "STRING", "is_primary": false, "is_unique": false}
+      ],
+      "indexes": [
+        {"name": "idx_members_name", "column": "name", "type": "HASH", "is_unique": false}
+      ]
+    },
+    "deposits": {
+      "name": "deposits",
+      "columns": [
+        {"name": "id", "type": "INT", "is_primary": true, "is_unique": false},
+        {"name": "amt", "type": "DECIMAL", "is_primary": false, "is_unique": false}
+      ],
+      "indexes": null
+    }
+  }
+}`
+
+// checkCatalogMigration opens a data directory that still has a legacy
+// catalog.json and checks that its tables, columns and indexes are imported
+// into the system tables, that the file is renamed, and that the catalog
+// reads back the same from the system tables after a restart.
+func checkCatalogMigration() error {
+	dir, err := tempDataDir("migrate")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, storage.LegacyCatalogFile)
+	if err := os.WriteFile(path, []byte(legacyCatalog), 0644); err != nil {
+		return err
+	}
+	r, err := openREPL(dir, storage.NewEngine)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		r.Storage.Close()
+		return fmt.Errorf("%s is still there after the migration", storage.LegacyCatalogFile)
+	}
+	if _, err := os.Stat(path + ".migrated"); err != nil {
+		r.Storage.Close()
+		return err
+	}
+	migrated := describeCatalog(r.Catalog)
+	for _, want := range []string{
+		"deposits(id INT primary, amt DECIMAL)",
+		"members(id INT primary, email STRING unique, name STRING)",
+		"idx_members_name HASH (name)",
+	} {
+		if !strings.Contains(migrated, want) {
+			r.Storage.Close()
+			return fmt.Errorf("migrated catalog %q lacks %q", migrated, want)
+		}
+	}
+	for _, q := range []string{
+		"INSERT INTO members VALUES (1, 'ann@example.com', 'Ann')",
+		"INSERT INTO deposits VALUES (1, 12.50)",
+	} {
+		if err := r.Execute(q); err != nil {
+			r.Storage.Close()
+			return err
+		}
+	}
+	if err := r.Storage.Close(); err != nil {
+		return err
+	}
+
+	if r, err = openREPL(dir, storage.NewEngine); err != nil {
+		return err
+	}
+	defer r.Storage.Close()
+	if got := describeCatalog(r.Catalog); got != migrated {
+		return fmt.Errorf("catalog reads %q after a restart, %q after the migration", got, migrated)
+	}
+	return sameRows(r, "SELECT * FROM members WHERE name = 'Ann'")
+}
+
+// describeCatalog lists the user tables of cat with their columns and
+// indexes, in name order, one table per line.
+func describeCatalog(cat *catalog.Catalog) string {
+	var lines []string
+	for _, t := range cat.UserTables() {
+		var cols, idxs []string
+		for _, c := range t.Columns {
+			col := c.Name + " " + string(c.Type)
+			if c.IsPrimary {
+				col += " primary"
+			}
+			if c.IsUnique {
+				col += " unique"
+			}
+			cols = append(cols, col)
+		}
+		for _, d := range t.Indexes {
+			idxs = append(idxs, fmt.Sprintf("%s %s (%s)", d.Name, d.Type, strings.Join(d.Columns, ", ")))
+		}
+		sort.Strings(idxs)
+		lines = append(lines, fmt.Sprintf("%s(%s) [%s]", t.Name, strings.Join(cols, ", "), strings.Join(idxs, "; ")))
+	}
+	return strings.Join(lines, "\n")
+}
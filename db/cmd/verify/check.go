@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"minibank/internal/checker"
+	"minibank/internal/storage"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkDamagedPageIndex damages a heap page on disk and checks that the
+// checker reports the index entries pointing into it under the page's
+// problem, instead of as entries for missing tuples to be fixed by a
+// reindex that would drop them.
+func checkDamagedPageIndex() error {
+	dir, err := tempDataDir("check")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := openREPL(dir, storage.NewEngine)
+	if err != nil {
+		return err
+	}
+	for _, q := range []string{
+		"CREATE TABLE ledger (id INT PRIMARY KEY, memo STRING)",
+		"INSERT INTO ledger VALUES (1, 'rent')",
+		"INSERT INTO ledger VALUES (2, 'salary')",
+	} {
+		if err := r.Execute(q); err != nil {
+			r.Storage.Close()
+			return fmt.Errorf("%s: %w", q, err)
+		}
+	}
+	if err := r.Storage.Close(); err != nil {
+		return err
+	}
+
+	// The tuples sit at the end of the table's only page, so flipping the
+	// last byte of the file breaks its checksum.
+	path := filepath.Join(dir, "ledger.data")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+
+	if r, err = openREPL(dir, storage.NewEngine); err != nil {
+		return err
+	}
+	defer r.Storage.Close()
+	report, err := checker.Run(r.Catalog, r.Storage, r.Planner.Indices)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, p := range report.Problems {
+		if p.File != "ledger.data" {
+			continue
+		}
+		if strings.Contains(p.Repair, "reindex") {
+			return fmt.Errorf("checker advises a reindex for %s", p)
+		}
+		if p.Unread && strings.Contains(p.Msg, "2 entries of index") {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no page problem notes the index entries into it: %v", report.Problems)
+	}
+	return nil
+}
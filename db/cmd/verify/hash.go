@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"minibank/internal/execution"
+	"minibank/internal/indexing"
+	"minibank/internal/parser"
+	"minibank/internal/storage"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkHashIndexReopen fills a table with a hash index, reopens the data
+// directory and checks that the index is read from its file rather than
+// rebuilt: opening reads only a few pages and leaves the file as it was.
+// Lookups must still go through the hash index and return the same rows.
+func checkHashIndexReopen() error {
+	dir, err := tempDataDir("hash")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := openREPL(dir, storage.NewEngine)
+	if err != nil {
+		return err
+	}
+	pad := strings.Repeat("h", 100)
+	queries := []string{
+		"CREATE TABLE holders (id INT PRIMARY KEY, name STRING, pad STRING)",
+		"CREATE INDEX idx_holders_name ON holders (name) USING HASH",
+	}
+	for i := 1; i <= 1000; i++ {
+		queries = append(queries, fmt.Sprintf("INSERT INTO holders VALUES (%d, 'holder-%d', '%s')", i, i%250, pad))
+	}
+	for _, q := range queries {
+		if err := r.Execute(q); err != nil {
+			r.Storage.Close()
+			return fmt.Errorf("%s: %w", q, err)
+		}
+	}
+	const lookup = "SELECT id FROM holders WHERE name = 'holder-42'"
+	want, err := queryRows(r, lookup)
+	if err != nil {
+		r.Storage.Close()
+		return err
+	}
+	if err := r.Storage.Close(); err != nil {
+		return err
+	}
+	idxPath := filepath.Join(dir, storage.IndexFileName("holders", "idx_holders_name"))
+	before, err := os.ReadFile(idxPath)
+	if err != nil {
+		return err
+	}
+
+	if r, err = openREPL(dir, storage.NewEngine); err != nil {
+		return err
+	}
+	st := r.Storage.BufferPoolStats()
+	if fetched := st.Hits + st.Misses; fetched > 30 {
+		r.Storage.Close()
+		return fmt.Errorf("reopening fetched %d pages, as if the indexes were rebuilt", fetched)
+	}
+	idx, ok := r.Planner.Indices["holders.idx_holders_name"]
+	if _, hash := idx.(*indexing.LinearHash); !ok || !hash {
+		r.Storage.Close()
+		return fmt.Errorf("idx_holders_name reopened as %T", idx)
+	}
+	ast, err := parser.NewParser(parser.NewLexer(lookup)).Parse()
+	if err != nil {
+		r.Storage.Close()
+		return err
+	}
+	plan, err := r.Planner.CreatePlan(ast)
+	if err != nil {
+		r.Storage.Close()
+		return err
+	}
+	if !usesIndex(plan, idx) {
+		r.Storage.Close()
+		return fmt.Errorf("%s does not look the name up in idx_holders_name", lookup)
+	}
+	got, err := queryRows(r, lookup)
+	if err != nil {
+		r.Storage.Close()
+		return err
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		r.Storage.Close()
+		return fmt.Errorf("%s returned %v after reopening, %v before", lookup, got, want)
+	}
+	if err := r.Storage.Close(); err != nil {
+		return err
+	}
+	after, err := os.ReadFile(idxPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(before, after) {
+		return fmt.Errorf("reopening rewrote %s", filepath.Base(idxPath))
+	}
+	return nil
+}
+
+// usesIndex reports whether plan, under its filters and projections, is an
+// IndexScan of idx.
+func usesIndex(plan execution.Iterator, idx indexing.Index) bool {
+	for {
+		switch op := plan.(type) {
+		case *execution.Project:
+			plan = op.Child
+		case *execution.Filter:
+			plan = op.Child
+		case *execution.IndexScan:
+			return op.Index == idx
+		default:
+			return false
+		}
+	}
+}
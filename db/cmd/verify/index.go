@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"minibank/internal/parser"
+	"minibank/internal/repl"
+	"minibank/internal/storage"
+	"strings"
+)
+
+// checkDropIndexRollback drops a unique index in a transaction that rolls
+// back, as a failed commit does, reloads the catalog as the REPL then does,
+// and checks that the index still enforces uniqueness and is maintained by
+// later inserts.
+func checkDropIndexRollback(r *repl.REPL) error {
+	for _, q := range []string{
+		"CREATE TABLE drop_rb (id INT PRIMARY KEY, code INT)",
+		"INSERT INTO drop_rb VALUES (1, 10)",
+		"CREATE UNIQUE INDEX idx_drop_rb_code ON drop_rb (code)",
+	} {
+		if err := r.Execute(q); err != nil {
+			return fmt.Errorf("%s: %w", q, err)
+		}
+	}
+
+	ast, err := parser.NewParser(parser.NewLexer("DROP INDEX idx_drop_rb_code")).Parse()
+	if err != nil {
+		return err
+	}
+	r.Storage.Begin()
+	if err := r.Planner.DropIndex(ast.(*parser.DropIndexStmt)); err != nil {
+		r.Storage.Abort()
+		return err
+	}
+	if err := r.Storage.Abort(); err != nil {
+		return err
+	}
+	if err := r.Planner.ReloadCatalog(); err != nil {
+		return err
+	}
+
+	if err := r.Execute("INSERT INTO drop_rb VALUES (2, 10)"); err == nil {
+		return fmt.Errorf("duplicate code accepted after the DROP INDEX rolled back")
+	}
+	if err := r.Execute("INSERT INTO drop_rb VALUES (3, 30)"); err != nil {
+		return err
+	}
+	return sameRows(r, "SELECT * FROM drop_rb WHERE code = 30")
+}
+
+// checkCreateIndexRollback creates a hash index that fails on a key too long
+// to index and checks that the rollback deletes the new index file, so the
+// name can then be used for a B+tree index.
+func checkCreateIndexRollback(r *repl.REPL) error {
+	for _, q := range []string{
+		"CREATE TABLE notes (id INT PRIMARY KEY, body STRING)",
+		fmt.Sprintf("INSERT INTO notes VALUES (1, '%s')", strings.Repeat("n", 9000)),
+	} {
+		if err := r.Execute(q); err != nil {
+			return fmt.Errorf("%s: %w", q, err)
+		}
+	}
+	if err := r.Execute("CREATE INDEX nb ON notes (body)"); err == nil {
+		return fmt.Errorf("indexed a 9000-byte key")
+	}
+	if exists, err := r.Storage.FileExists(storage.IndexFileName("notes", "nb")); err != nil || exists {
+		return fmt.Errorf("failed CREATE INDEX left its file behind (err %v)", err)
+	}
+	if err := r.Execute("CREATE INDEX nb ON notes (id) USING BTREE"); err != nil {
+		return err
+	}
+	return sameRows(r, "SELECT id FROM notes WHERE id = 1")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"minibank/internal/repl"
+	"strings"
+)
+
+// checkKeysetReads fills a table whose primary key index spans dozens of
+// leaves and checks that keyset pages read only a few pages of the index and
+// heap, rather than the whole range after the key, and return the same rows
+// as a SeqScan.
+func checkKeysetReads(r *repl.REPL) error {
+	const rows = 1000
+	// Long keys leave room for only a few entries per leaf.
+	memo := strings.Repeat("x", 180)
+	if err := r.Execute("CREATE TABLE ledger_pages (ref STRING PRIMARY KEY, amount INT)"); err != nil {
+		return err
+	}
+	for i := 1; i <= rows; i++ {
+		if err := r.Execute(fmt.Sprintf("INSERT INTO ledger_pages VALUES ('%05d-%s', %d)", i, memo, i%7)); err != nil {
+			return err
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM ledger_pages WHERE ref > '00010' ORDER BY ref LIMIT 10",
+		"SELECT ref FROM ledger_pages WHERE ref > '00500' ORDER BY ref LIMIT 10",
+		"SELECT * FROM ledger_pages ORDER BY ref LIMIT 10 OFFSET 5",
+	} {
+		before := r.Storage.BufferPoolStats()
+		got, err := queryRows(r, sql)
+		if err != nil {
+			return err
+		}
+		after := r.Storage.BufferPoolStats()
+		if len(got) != 10 {
+			return fmt.Errorf("%s: got %d rows, want 10", sql, len(got))
+		}
+		// A page of 10 rows needs a root-to-leaf descent, a few leaves and a
+		// heap page per row at most; the whole range is over 50 leaves.
+		if fetched := after.Hits + after.Misses - before.Hits - before.Misses; fetched > 40 {
+			return fmt.Errorf("%s: fetched %d pages for 10 rows", sql, fetched)
+		}
+		if err := sameRows(r, sql); err != nil {
+			return err
+		}
+	}
+	for _, sql := range []string{
+		"SELECT ref FROM ledger_pages WHERE ref >= '00100' AND ref < '00900'",
+		"SELECT * FROM ledger_pages WHERE ref > '00990'",
+	} {
+		if err := sameRows(r, sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
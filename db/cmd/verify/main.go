@@ -3,29 +3,51 @@ package main
 import (
 	"fmt"
 	"minibank/internal/catalog"
+	"minibank/internal/indexing"
+	"minibank/internal/parser"
 	"minibank/internal/repl"
 	"minibank/internal/storage"
 	"os"
+	"sort"
+	"strings"
 )
 
 func main() {
-	os.Remove("catalog.json")
-	files, _ := os.ReadDir(".")
-	for _, f := range files {
-		if len(f.Name()) > 5 && f.Name()[len(f.Name())-5:] == ".data" {
-			os.Remove(f.Name())
-		}
+	if len(os.Args) == 3 && os.Args[1] == crashArg {
+		crashWriter(os.Args[2])
+	}
+
+	dir, err := tempDataDir("verify")
+	if err != nil {
+		fmt.Printf("Failed to create data directory: %v\n", err)
+		os.Exit(1)
 	}
+	code := runTests(dir)
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
 
+// runTests runs every test against a database in dir and returns the exit
+// code.
+func runTests(dir string) int {
+	store, err := storage.NewEngine(dir)
+	if err != nil {
+		fmt.Printf("Failed to open storage: %v\n", err)
+		return 1
+	}
+	defer store.Close()
 	cat := catalog.NewCatalog()
-	store := storage.NewEngine(".")
-	r := repl.NewREPL(cat, store, ".")
+	r := repl.NewREPL(cat, store, dir)
 
 	// Test Cases
 	tests := []struct {
 		name    string
 		queries []string
 		wantErr bool
+		// SELECTs that must return the same rows with and without indexes.
+		compare []string
+		// SELECTs and the rows they must return, formatted as by queryRows.
+		expect []expectRows
 	}{
 		{
 			name: "1. Decimal Literal Support",
@@ -58,9 +80,15 @@ func main() {
 				"CREATE TABLE w (id INT PRIMARY KEY, user_id INT UNIQUE, balance DECIMAL)",
 				"INSERT INTO u VALUES (1, 'a@b.com')",
 				"INSERT INTO w VALUES (1, 1, 10.50)",
+				"INSERT INTO u VALUES (9, 'z@y.com')",
+				"INSERT INTO w VALUES (4, 9, 3.25)",
 				"SELECT u.email, w.balance FROM u JOIN w ON u.id = w.user_id",
 			},
 			wantErr: false,
+			expect: []expectRows{
+				{"SELECT w.id, u.id, w.balance FROM u JOIN w ON u.id = w.user_id", []string{"1,1,10.50", "4,9,3.25"}},
+				{"SELECT ID, Email FROM u WHERE EMAIL = 'z@y.com'", []string{"9,z@y.com"}},
+			},
 		},
 		{
 			name: "5. Decimal/Int Mixed",
@@ -76,6 +104,608 @@ func main() {
 			},
 			wantErr: true,
 		},
+		{
+			name: "7. NULL Values",
+			queries: []string{
+				"INSERT INTO t (id) VALUES (3)",
+				"INSERT INTO t VALUES (4, NULL)",
+				"SELECT * FROM t WHERE amt IS NULL",
+				"SELECT * FROM t WHERE amt IS NOT NULL AND id > 1",
+				"SELECT * FROM t WHERE amt = NULL OR id = 3",
+			},
+			wantErr: false,
+		},
+		{
+			name: "8. Error Case: NULL Primary Key",
+			queries: []string{
+				"INSERT INTO t (amt) VALUES (1.00)",
+			},
+			wantErr: true,
+		},
+		{
+			name: "9. B+tree Range Scan",
+			queries: []string{
+				"CREATE INDEX idx_t_amt ON t(amt) USING BTREE",
+				"SELECT * FROM t WHERE amt >= 10 AND amt < 20.50",
+				"SELECT * FROM t WHERE amt BETWEEN 10.50 AND 20.50",
+				"SELECT * FROM t WHERE 20 < amt",
+			},
+			wantErr: false,
+		},
+		{
+			name: "10. Error Case: Unknown Index Method",
+			queries: []string{
+				"CREATE INDEX idx_t_bad ON t(amt) USING GIST",
+			},
+			wantErr: true,
+		},
+		{
+			name: "11. Index Maintenance on UPDATE and DELETE",
+			queries: []string{
+				"CREATE TABLE acct (id INT PRIMARY KEY, owner STRING, bal INT)",
+				"CREATE INDEX idx_acct_owner ON acct(owner)",
+				"CREATE INDEX idx_acct_bal ON acct(bal) USING BTREE",
+				"INSERT INTO acct VALUES (1, 'ann', 100)",
+				"INSERT INTO acct VALUES (2, 'bob', 200)",
+				"INSERT INTO acct VALUES (3, 'cy', 300)",
+				"INSERT INTO acct VALUES (4, 'ann', 400)",
+				"UPDATE acct SET owner = 'dee' WHERE id = 1",
+				"UPDATE acct SET bal = 250 WHERE owner = 'bob'",
+				"UPDATE acct SET bal = 50 WHERE bal >= 300",
+				"DELETE FROM acct WHERE owner = 'cy'",
+				"DELETE FROM acct WHERE bal BETWEEN 240 AND 260",
+			},
+			compare: []string{
+				"SELECT * FROM acct WHERE owner = 'ann'",
+				"SELECT * FROM acct WHERE owner = 'dee'",
+				"SELECT * FROM acct WHERE owner = 'bob'",
+				"SELECT * FROM acct WHERE owner = 'cy'",
+				"SELECT * FROM acct WHERE bal = 50",
+				"SELECT * FROM acct WHERE bal >= 0",
+				"SELECT * FROM acct WHERE bal BETWEEN 200 AND 400",
+			},
+		},
+		{
+			name: "12. Error Case: UPDATE with Wrong Type",
+			queries: []string{
+				"UPDATE acct SET bal = 'lots' WHERE id = 1",
+				"UPDATE acct SET bal = bal + 1 WHERE id = 1",
+			},
+			wantErr: true,
+			compare: []string{
+				"SELECT * FROM acct WHERE bal = 50",
+			},
+		},
+		{
+			name: "13. Unique Indexes",
+			queries: []string{
+				"INSERT INTO u VALUES (2, 'c@d.com')",
+				"CREATE UNIQUE INDEX idx_acct_owner_u ON acct(owner)",
+				"UPDATE acct SET owner = 'eve' WHERE id = 4",
+				"INSERT INTO acct VALUES (5, NULL, 10)",
+				"INSERT INTO acct VALUES (6, NULL, 10)",
+			},
+			compare: []string{
+				"SELECT * FROM u WHERE id = 2",
+				"SELECT * FROM u WHERE email = 'c@d.com'",
+				"SELECT * FROM acct WHERE owner = 'eve'",
+				"SELECT * FROM acct WHERE id >= 1",
+			},
+		},
+		{
+			name: "14. Error Case: Duplicate Keys",
+			queries: []string{
+				"INSERT INTO u VALUES (1, 'x@y.com')",
+				"INSERT INTO u VALUES (3, 'a@b.com')",
+				"UPDATE u SET id = 1 WHERE id = 2",
+				"UPDATE u SET email = 'a@b.com' WHERE id = 2",
+				"UPDATE acct SET owner = 'dee' WHERE id = 4",
+				"UPDATE acct SET id = NULL WHERE id = 1",
+			},
+			wantErr: true,
+			compare: []string{
+				"SELECT * FROM u WHERE id = 2",
+				"SELECT * FROM acct WHERE owner = 'dee'",
+			},
+		},
+		{
+			name: "15. Error Case: Unique Index on Duplicated Column",
+			queries: []string{
+				"CREATE UNIQUE INDEX idx_acct_bal_u ON acct(bal)",
+			},
+			wantErr: true,
+		},
+		{
+			name: "16. Composite Indexes",
+			queries: []string{
+				"CREATE TABLE tx (wallet_id INT, seq INT, type STRING, amt DECIMAL, PRIMARY KEY (wallet_id, seq))",
+				"CREATE INDEX idx_tx_wallet_type ON tx (wallet_id, type)",
+				"INSERT INTO tx VALUES (1, 1, 'credit', 10.00)",
+				"INSERT INTO tx VALUES (1, 2, 'debit', 5.00)",
+				"INSERT INTO tx VALUES (1, 3, NULL, 1.00)",
+				"INSERT INTO tx VALUES (2, 1, 'debit', 7.50)",
+				"INSERT INTO tx VALUES (2, 2, 'credit', 2.25)",
+				"INSERT INTO tx VALUES (2, 3, 'debit', 3.00)",
+				"UPDATE tx SET type = 'credit' WHERE wallet_id = 1 AND seq = 2",
+				"DELETE FROM tx WHERE wallet_id = 2 AND type = 'debit' AND seq = 3",
+			},
+			compare: []string{
+				"SELECT * FROM tx WHERE wallet_id = 1 AND type = 'credit'",
+				"SELECT * FROM tx WHERE type = 'debit' AND wallet_id = 2",
+				"SELECT * FROM tx WHERE wallet_id = 1 AND seq = 2",
+				"SELECT * FROM tx WHERE wallet_id = 1",
+				"SELECT * FROM tx WHERE wallet_id = 1 AND seq > 1",
+				"SELECT * FROM tx WHERE wallet_id = 2 AND seq <= 2",
+				"SELECT * FROM tx WHERE wallet_id BETWEEN 1 AND 2",
+				"SELECT * FROM tx WHERE type = 'credit'",
+			},
+		},
+		{
+			name: "17. Error Case: Composite Keys",
+			queries: []string{
+				"INSERT INTO tx VALUES (1, 1, 'debit', 1.00)",
+				"INSERT INTO tx (seq, type) VALUES (9, 'debit')",
+				"UPDATE tx SET seq = 1 WHERE wallet_id = 1 AND seq = 2",
+				"CREATE TABLE bad (a INT PRIMARY KEY, b INT, PRIMARY KEY (b))",
+				"CREATE TABLE bad (a INT, PRIMARY KEY (c))",
+				"CREATE INDEX idx_bad ON tx (wallet_id, wallet_id)",
+				"CREATE INDEX idx_bad ON tx (wallet_id, nope)",
+			},
+			wantErr: true,
+		},
+		{
+			name: "18. Covering Indexes",
+			queries: []string{
+				"CREATE TABLE wallets (id INT PRIMARY KEY, user_id INT, currency STRING, balance DECIMAL)",
+				"CREATE INDEX idx_wallets_user ON wallets (user_id) INCLUDE (id)",
+				"CREATE INDEX idx_wallets_cur ON wallets (currency, user_id) INCLUDE (balance) USING BTREE",
+				"INSERT INTO wallets VALUES (1, 5, 'KES', 100.00)",
+				"INSERT INTO wallets VALUES (2, 5, 'USD', 20.50)",
+				"INSERT INTO wallets VALUES (3, 7, 'KES', 0.00)",
+				"INSERT INTO wallets VALUES (4, NULL, 'KES', 3.00)",
+				"UPDATE wallets SET balance = 150.00 WHERE id = 1",
+				"UPDATE wallets SET user_id = 7 WHERE id = 2",
+				"DELETE FROM wallets WHERE id = 3",
+			},
+			compare: []string{
+				"SELECT id FROM wallets WHERE user_id = 5",
+				"SELECT id, user_id FROM wallets WHERE user_id = 7",
+				"SELECT balance FROM wallets WHERE currency = 'KES'",
+				"SELECT user_id, balance FROM wallets WHERE currency = 'KES' AND user_id >= 5",
+				"SELECT * FROM wallets WHERE currency = 'USD'",
+				"SELECT id FROM wallets WHERE user_id = 7 AND id > 1",
+			},
+		},
+		{
+			name: "19. Error Case: Covering Indexes",
+			queries: []string{
+				"CREATE INDEX idx_bad ON wallets (user_id) INCLUDE (user_id)",
+				"CREATE INDEX idx_bad ON wallets (user_id) INCLUDE (nope)",
+				"CREATE INDEX idx_bad ON wallets (user_id) INCLUDE (id, id)",
+			},
+			wantErr: true,
+		},
+		{
+			name: "20. DROP INDEX, REINDEX and SHOW INDEXES",
+			queries: []string{
+				"CREATE INDEX idx_wallets_tmp ON wallets (balance) USING BTREE",
+				"SHOW INDEXES FROM wallets",
+				"REINDEX INDEX idx_wallets_tmp",
+				"REINDEX TABLE wallets",
+				"DROP INDEX idx_wallets_tmp",
+				"CREATE INDEX idx_wallets_tmp ON wallets (balance)",
+				"DROP INDEX wallets.idx_wallets_tmp",
+				"CREATE INDEX idx_dup ON wallets (currency)",
+				"CREATE INDEX idx_dup ON tx (type)",
+				"DELETE FROM wallets WHERE id = 4",
+				"REINDEX INDEX tx.idx_dup",
+				"SHOW INDEXES",
+			},
+			compare: []string{
+				"SELECT * FROM wallets WHERE id = 1",
+				"SELECT id FROM wallets WHERE user_id = 7",
+				"SELECT * FROM wallets WHERE currency = 'KES'",
+				"SELECT * FROM tx WHERE type = 'credit'",
+			},
+		},
+		{
+			name: "21. Error Case: Index Management",
+			queries: []string{
+				"DROP INDEX nope",
+				"DROP INDEX idx_dup",
+				"DROP INDEX wallets_pkey",
+				"DROP INDEX tx.idx_wallets_user",
+				"REINDEX INDEX nope",
+				"REINDEX TABLE nope",
+				"SHOW INDEXES FROM nope",
+				"CREATE INDEX idx_wallets_user ON wallets (id)",
+			},
+			wantErr: true,
+		},
+		{
+			name: "22. Partial and Expression Indexes",
+			queries: []string{
+				"CREATE TABLE members (id INT PRIMARY KEY, email STRING, name STRING)",
+				"INSERT INTO members VALUES (1, 'Alice@Example.com', 'alice')",
+				"INSERT INTO members VALUES (2, 'bob@example.com', 'bob')",
+				"INSERT INTO members VALUES (3, NULL, 'carol')",
+				"CREATE UNIQUE INDEX idx_members_email ON members (LOWER(email))",
+				"CREATE INDEX idx_members_len ON members (LENGTH(name)) USING BTREE",
+				"UPDATE members SET email = 'Bob@Example.com' WHERE id = 2",
+				"CREATE TABLE payouts (id INT PRIMARY KEY, wallet_id INT, type STRING, amount DECIMAL)",
+				"INSERT INTO payouts VALUES (1, 1, 'deposit', 100.00)",
+				"INSERT INTO payouts VALUES (2, 1, 'withdrawal', 40.00)",
+				"INSERT INTO payouts VALUES (3, 2, 'withdrawal', 15.50)",
+				"INSERT INTO payouts VALUES (4, NULL, 'withdrawal', 1.00)",
+				"CREATE INDEX idx_payouts_withdrawal ON payouts (wallet_id) WHERE type = 'withdrawal' USING BTREE",
+				"CREATE INDEX idx_payouts_big ON payouts (wallet_id) INCLUDE (id) WHERE amount > 50",
+				"CREATE UNIQUE INDEX idx_payouts_one ON payouts (wallet_id) WHERE type = 'deposit'",
+				"INSERT INTO payouts VALUES (5, 1, 'withdrawal', 60.00)",
+				"UPDATE payouts SET type = 'deposit' WHERE id = 3",
+				"UPDATE payouts SET type = 'withdrawal' WHERE id = 1",
+				"DELETE FROM payouts WHERE id = 2",
+				"REINDEX TABLE payouts",
+			},
+			compare: []string{
+				"SELECT * FROM members WHERE LOWER(email) = 'bob@example.com'",
+				"SELECT name FROM members WHERE lower(members.email) = 'alice@example.com'",
+				"SELECT * FROM members WHERE LENGTH(name) > 3",
+				"SELECT * FROM payouts WHERE type = 'withdrawal'",
+				"SELECT * FROM payouts WHERE type = 'withdrawal' AND wallet_id = 1",
+				"SELECT * FROM payouts WHERE wallet_id = 1",
+				"SELECT id FROM payouts WHERE amount > 90 AND wallet_id = 1",
+				"SELECT id FROM payouts WHERE amount >= 60.00",
+				"SELECT * FROM payouts WHERE amount > 10 AND wallet_id = 1",
+			},
+		},
+		{
+			name: "23. Error Case: Partial and Expression Indexes",
+			queries: []string{
+				"INSERT INTO members VALUES (4, 'ALICE@example.com', 'dup')",
+				"INSERT INTO payouts VALUES (6, 2, 'deposit', 5.00)",
+				"CREATE INDEX idx_bad ON payouts (LOWER(wallet_id))",
+				"CREATE INDEX idx_bad ON payouts (FOO(type))",
+				"CREATE INDEX idx_bad ON payouts (id) WHERE nope = 1",
+				"CREATE INDEX idx_bad ON payouts (id) WHERE 1",
+				"CREATE INDEX idx_bad ON payouts (LOWER(type), lower(type))",
+				"CREATE UNIQUE INDEX idx_bad ON payouts (type) WHERE type = 'withdrawal'",
+			},
+			wantErr: true,
+		},
+		{
+			name: "24. Trigram Indexes",
+			queries: []string{
+				"CREATE TABLE people (id INT PRIMARY KEY, name STRING, email STRING)",
+				"INSERT INTO people VALUES (1, 'Alice Smith', 'alice@example.com')",
+				"INSERT INTO people VALUES (2, 'Bob Jones', 'bob@example.com')",
+				"INSERT INTO people VALUES (3, 'John Smith', 'jsmith@corp.io')",
+				"INSERT INTO people VALUES (4, NULL, 'nobody@example.com')",
+				"INSERT INTO people VALUES (5, 'Zoë Brien-Smith', NULL)",
+				"CREATE INDEX idx_people_name ON people (name) USING TRIGRAM",
+				"CREATE INDEX idx_people_email ON people (LOWER(email)) USING TRIGRAM",
+				"UPDATE people SET name = 'Alicia Smythe' WHERE id = 1",
+				"INSERT INTO people VALUES (6, 'Jon Smyth', 'JON@Example.com')",
+				"DELETE FROM people WHERE id = 2",
+				"REINDEX INDEX idx_people_email",
+			},
+			compare: []string{
+				"SELECT * FROM people WHERE name LIKE '%mit%'",
+				"SELECT * FROM people WHERE name LIKE 'J%'",
+				"SELECT * FROM people WHERE name LIKE 'Jo_n%'",
+				"SELECT * FROM people WHERE name LIKE '%th'",
+				"SELECT * FROM people WHERE name LIKE '%smith%'",
+				"SELECT id FROM people WHERE MATCH(name, 'jon smth')",
+				"SELECT id FROM people WHERE MATCH(name, 'alicia') AND id > 0",
+				"SELECT * FROM people WHERE LOWER(email) LIKE '%example.com'",
+				"SELECT * FROM people WHERE MATCH(LOWER(email), 'EXAMPLE')",
+			},
+		},
+		{
+			name: "25. Error Case: Trigram Indexes",
+			queries: []string{
+				"CREATE UNIQUE INDEX idx_bad ON people (name) USING TRIGRAM",
+				"CREATE INDEX idx_bad ON people (id) USING TRIGRAM",
+				"CREATE INDEX idx_bad ON people (name, email) USING TRIGRAM",
+				"CREATE INDEX idx_bad ON people (name) INCLUDE (id) USING TRIGRAM",
+				"CREATE INDEX idx_bad ON people (name) USING GIN",
+				"SELECT * FROM people WHERE MATCH(id, 'x')",
+				"SELECT * FROM people WHERE MATCH(name)",
+			},
+			wantErr: true,
+		},
+		{
+			name: "26. Canonical Index Keys",
+			queries: []string{
+				"CREATE TABLE ledger_lines (id INT PRIMARY KEY, memo STRING, amount DECIMAL)",
+				"INSERT INTO ledger_lines VALUES (1, 'rent', 10.50)",
+				"INSERT INTO ledger_lines VALUES (2, 'fee', 2.5)",
+				"INSERT INTO ledger_lines VALUES (3, 'refund', '-7.25')",
+				"INSERT INTO ledger_lines VALUES (4, 'zero', 0.00)",
+				"INSERT INTO ledger_lines VALUES (5, 'big', 100)",
+				"INSERT INTO ledger_lines VALUES (6, NULL, 99.999)",
+				"INSERT INTO ledger_lines VALUES (7, 'rent', '1.050e1')",
+				"CREATE INDEX idx_lines_amount ON ledger_lines (amount)",
+				"CREATE INDEX idx_lines_sorted ON ledger_lines (amount) USING BTREE",
+				"CREATE INDEX idx_lines_memo ON ledger_lines (memo, amount) USING BTREE",
+			},
+			compare: []string{
+				"SELECT * FROM ledger_lines WHERE amount = 10.5",
+				"SELECT * FROM ledger_lines WHERE amount = '10.500'",
+				"SELECT * FROM ledger_lines WHERE amount = 0",
+				"SELECT * FROM ledger_lines WHERE amount > 2.50 AND amount <= 100.0",
+				"SELECT * FROM ledger_lines WHERE amount < 0",
+				"SELECT amount FROM ledger_lines WHERE memo = 'rent' AND amount >= 10.5",
+			},
+		},
+		{
+			name: "27. Error Case: Canonical Index Keys",
+			queries: []string{
+				"CREATE UNIQUE INDEX idx_lines_unique ON ledger_lines (amount)",
+				"INSERT INTO ledger_lines VALUES (8, 'typo', 'ten')",
+				"INSERT INTO ledger_lines VALUES (9, 'third', '1/3')",
+			},
+			wantErr: true,
+		},
+		{
+			name: "28. Unique DECIMAL Keys",
+			queries: []string{
+				"CREATE TABLE prices (id INT PRIMARY KEY, price DECIMAL UNIQUE)",
+				"INSERT INTO prices VALUES (1, 10.50)",
+				"INSERT INTO prices VALUES (2, 2.5)",
+				"UPDATE prices SET price = 10.500 WHERE id = 1",
+			},
+		},
+		{
+			name: "29. Error Case: Unique DECIMAL Keys",
+			queries: []string{
+				"INSERT INTO prices VALUES (3, 10.5)",
+				"INSERT INTO prices VALUES (4, '1.05e1')",
+				"UPDATE prices SET price = 10.5000 WHERE id = 2",
+			},
+			wantErr: true,
+		},
+		{
+			name: "30. NOT NULL, DEFAULT and CHECK Constraints",
+			queries: []string{
+				"CREATE TABLE purses (id INT PRIMARY KEY, owner STRING NOT NULL, balance DECIMAL NOT NULL DEFAULT 0 CHECK (balance >= 0), currency STRING DEFAULT 'KES', CONSTRAINT purses_owner_named CHECK (owner != ''))",
+				"INSERT INTO purses VALUES (1, 'amina', 10.50, 'USD')",
+				"INSERT INTO purses (id, owner) VALUES (2, 'brian')",
+				"INSERT INTO purses VALUES (3, 'chen', 0, NULL)",
+				"UPDATE purses SET balance = 5 WHERE id = 2",
+				"UPDATE purses SET balance = 0 WHERE id = 1",
+			},
+			compare: []string{
+				"SELECT * FROM purses WHERE currency = 'KES'",
+				"SELECT id, balance FROM purses WHERE balance = 5",
+			},
+		},
+		{
+			name: "31. Error Case: NOT NULL, DEFAULT and CHECK Constraints",
+			queries: []string{
+				"INSERT INTO purses VALUES (4, NULL, 1, 'KES')",
+				"INSERT INTO purses (id) VALUES (5)",
+				"INSERT INTO purses VALUES (6, 'dara', '-5', 'KES')",
+				"INSERT INTO purses VALUES (7, '', 1, 'KES')",
+				"INSERT INTO purses VALUES (8, 'eve', NULL, 'KES')",
+				"UPDATE purses SET balance = '-95' WHERE id = 2",
+				"UPDATE purses SET owner = NULL WHERE id = 3",
+				"CREATE TABLE bad_defaults (id INT PRIMARY KEY, n INT DEFAULT 'many')",
+				"CREATE TABLE bad_checks (id INT PRIMARY KEY, CHECK (missing > 0))",
+				"CREATE TABLE bad_conditions (id INT PRIMARY KEY, CHECK (id))",
+				"CREATE TABLE twice (id INT PRIMARY KEY, CONSTRAINT c CHECK (id > 0), CONSTRAINT c CHECK (id < 9))",
+			},
+			wantErr: true,
+		},
+		{
+			name: "32. Foreign Keys",
+			queries: append([]string{
+				"CREATE TABLE clients (id INT PRIMARY KEY, email STRING UNIQUE)",
+				"CREATE TABLE client_wallets (id INT PRIMARY KEY, client_id INT REFERENCES clients ON DELETE CASCADE ON UPDATE CASCADE, balance DECIMAL)",
+				"CREATE TABLE client_payments (id INT PRIMARY KEY, wallet_id INT, memo STRING, CONSTRAINT payments_wallet FOREIGN KEY (wallet_id) REFERENCES client_wallets (id) ON DELETE SET NULL)",
+				"CREATE INDEX idx_client_payments_wallet ON client_payments (wallet_id) USING BTREE",
+				"CREATE TABLE client_notes (id INT PRIMARY KEY, email STRING REFERENCES clients (email))",
+				"INSERT INTO clients VALUES (1, 'a@x.com')",
+				"INSERT INTO clients VALUES (2, 'b@x.com')",
+				"INSERT INTO clients VALUES (3, 'c@x.com')",
+				"INSERT INTO client_wallets VALUES (10, 1, 5.00)",
+				"INSERT INTO client_wallets VALUES (11, 2, 6.00)",
+				"INSERT INTO client_wallets VALUES (12, NULL, 7.00)",
+				"INSERT INTO client_payments VALUES (100, 10, 'rent')",
+				"INSERT INTO client_payments VALUES (101, 11, 'fee')",
+				"INSERT INTO client_payments VALUES (102, NULL, 'cash')",
+				"INSERT INTO client_notes VALUES (1, 'c@x.com')",
+				"UPDATE clients SET id = 4 WHERE id = 1",
+				"DELETE FROM clients WHERE id = 2",
+				"CREATE TABLE branches (bank INT, code INT, PRIMARY KEY (bank, code))",
+				"CREATE TABLE tellers (id INT PRIMARY KEY, bank INT, code INT, FOREIGN KEY (code, bank) REFERENCES branches (code, bank) ON DELETE SET NULL)",
+				"INSERT INTO branches VALUES (1, 1)",
+				"INSERT INTO branches VALUES (1, 2)",
+				"INSERT INTO tellers VALUES (1, 1, 1)",
+				"INSERT INTO tellers VALUES (2, 1, 2)",
+				"INSERT INTO tellers VALUES (3, NULL, 7)",
+				"DELETE FROM branches WHERE code = 1",
+				"CREATE TABLE staff (id INT PRIMARY KEY, boss_id INT REFERENCES staff ON DELETE CASCADE)",
+				"INSERT INTO staff VALUES (1, 1)",
+				"INSERT INTO staff VALUES (2, 1)",
+				"INSERT INTO staff VALUES (3, 2)",
+				"INSERT INTO staff VALUES (4, NULL)",
+				"DELETE FROM staff WHERE id = 1",
+			}, bossChain(40, "DELETE FROM emp WHERE name < 'n3'")...),
+			compare: []string{
+				"SELECT * FROM client_wallets WHERE client_id = 4",
+				"SELECT * FROM client_wallets WHERE id >= 10",
+				"SELECT * FROM client_payments WHERE wallet_id IS NULL",
+				"SELECT * FROM tellers WHERE id >= 1",
+				"SELECT * FROM staff WHERE id >= 1",
+				"SELECT * FROM emp WHERE id >= 1",
+			},
+			// The SET NULL of deleting 1 rewrites 2 under a new RID, and so
+			// on down the chain; each must still be deleted.
+			expect: []expectRows{
+				{"SELECT COUNT(*) FROM emp WHERE name < 'n3'", []string{"0"}},
+				{"SELECT COUNT(*) FROM emp", []string{"18"}},
+				{"SELECT id FROM emp WHERE boss IS NULL", []string{"3", "30"}},
+			},
+		},
+		{
+			name: "33. Error Case: Foreign Keys",
+			queries: []string{
+				"INSERT INTO client_wallets VALUES (13, 99, 1.00)",
+				"UPDATE client_wallets SET client_id = 98 WHERE id = 10",
+				"INSERT INTO client_notes VALUES (2, 'nobody@x.com')",
+				"DELETE FROM clients WHERE id = 3",
+				"UPDATE clients SET email = 'z@x.com' WHERE id = 3",
+				"UPDATE client_wallets SET id = 20 WHERE id = 10",
+				"INSERT INTO tellers VALUES (4, 1, 9)",
+				"CREATE TABLE bad_fks (id INT PRIMARY KEY, x INT REFERENCES nowhere)",
+				"CREATE TABLE bad_fks (id INT PRIMARY KEY, x DECIMAL REFERENCES clients)",
+				"CREATE TABLE bad_fks (id INT PRIMARY KEY, x INT REFERENCES client_wallets (client_id))",
+				"CREATE TABLE bad_fks (id INT PRIMARY KEY, x INT REFERENCES branches)",
+				"CREATE TABLE bad_fks (id INT PRIMARY KEY, x INT NOT NULL REFERENCES clients ON DELETE SET NULL)",
+				"CREATE TABLE bad_fks (id INT PRIMARY KEY, x INT, CONSTRAINT c CHECK (x > 0), CONSTRAINT c FOREIGN KEY (x) REFERENCES clients)",
+				"CREATE TABLE bad_fks (id INT PRIMARY KEY, x INT REFERENCES clients ON DELETE NOTHING)",
+			},
+			wantErr: true,
+			compare: []string{
+				"SELECT * FROM clients WHERE id = 3",
+				"SELECT * FROM client_wallets WHERE id = 10",
+				"SELECT * FROM client_payments WHERE wallet_id = 10",
+			},
+		},
+		{
+			name: "34. ORDER BY",
+			queries: []string{
+				"CREATE TABLE ledger (id INT PRIMARY KEY, account STRING, amount DECIMAL)",
+				"CREATE INDEX idx_ledger_account ON ledger (account)",
+				"INSERT INTO ledger VALUES (1, 'Bob', 10.5)",
+				"INSERT INTO ledger VALUES (2, 'alice', 9.99)",
+				"INSERT INTO ledger VALUES (3, 'Carol', '1.05e1')",
+				"INSERT INTO ledger VALUES (4, 'bob', '-7.25')",
+				"INSERT INTO ledger (id, account) VALUES (5, 'alice')",
+				"SELECT * FROM ledger ORDER BY amount",
+				"SELECT id, account FROM ledger ORDER BY account DESC, id",
+			},
+			compare: []string{
+				"SELECT * FROM ledger ORDER BY amount",
+				"SELECT * FROM ledger ORDER BY amount DESC, id ASC",
+				"SELECT id FROM ledger ORDER BY account",
+				"SELECT id, amount FROM ledger WHERE account = 'alice' ORDER BY amount DESC",
+				"SELECT * FROM ledger ORDER BY LOWER(account), id DESC",
+				"SELECT ledger.id, wallets.id FROM ledger JOIN wallets ON ledger.id = wallets.user_id ORDER BY ledger.amount DESC, wallets.id",
+			},
+		},
+		{
+			name: "35. Error Case: ORDER BY",
+			queries: []string{
+				"SELECT * FROM ledger ORDER BY nothing",
+				"SELECT * FROM ledger ORDER BY",
+				"SELECT * FROM ledger ORDER id",
+				"SELECT * FROM ledger ORDER BY UPPER(nothing)",
+			},
+			wantErr: true,
+		},
+		{
+			name: "36. LIMIT, OFFSET and Keyset Pagination",
+			queries: []string{
+				"CREATE TABLE entries (id INT PRIMARY KEY, account INT, amount DECIMAL)",
+				"CREATE INDEX idx_entries_amount ON entries (amount) USING BTREE",
+				"CREATE INDEX idx_entries_account ON entries (account, id) USING BTREE",
+				"INSERT INTO entries VALUES (1, 10, 5.00)",
+				"INSERT INTO entries VALUES (2, 20, 12.5)",
+				"INSERT INTO entries VALUES (3, 10, '-3')",
+				"INSERT INTO entries VALUES (4, 20, 7.25)",
+				"INSERT INTO entries VALUES (5, 10, 100)",
+				"INSERT INTO entries (id, account) VALUES (6, 30)",
+				"INSERT INTO entries VALUES (7, 10, 0.5)",
+				"SELECT * FROM entries WHERE id > 2 ORDER BY id LIMIT 2",
+				"SELECT * FROM entries ORDER BY amount DESC LIMIT 3 OFFSET 1",
+			},
+			compare: []string{
+				"SELECT * FROM entries WHERE id > 2 ORDER BY id LIMIT 2",
+				"SELECT * FROM entries WHERE id > 4 ORDER BY id LIMIT 2",
+				"SELECT * FROM entries ORDER BY id LIMIT 3 OFFSET 5",
+				"SELECT id, amount FROM entries ORDER BY amount LIMIT 3",
+				"SELECT id, amount FROM entries WHERE amount > 1 ORDER BY amount LIMIT 2 OFFSET 1",
+				"SELECT * FROM entries ORDER BY amount DESC, id LIMIT 3 OFFSET 1",
+				"SELECT id FROM entries WHERE account = 10 AND id > 1 ORDER BY id LIMIT 2",
+				"SELECT id FROM entries WHERE account = 10 ORDER BY account, id",
+				"SELECT id FROM entries WHERE amount < 50 ORDER BY id LIMIT 2",
+				"SELECT * FROM entries ORDER BY id LIMIT 0",
+				"SELECT * FROM entries ORDER BY id OFFSET 6",
+				"SELECT * FROM entries ORDER BY id LIMIT 10 OFFSET 2",
+			},
+		},
+		{
+			name: "37. Error Case: LIMIT and OFFSET",
+			queries: []string{
+				"SELECT * FROM entries LIMIT",
+				"SELECT * FROM entries LIMIT 1.5",
+				"SELECT * FROM entries LIMIT 'ten'",
+				"SELECT * FROM entries OFFSET id",
+				"SELECT * FROM entries ORDER BY nothing LIMIT 1",
+			},
+			wantErr: true,
+		},
+		{
+			name: "38. GROUP BY, Aggregates and HAVING",
+			queries: []string{
+				"CREATE TABLE postings (id INT PRIMARY KEY, account INT, kind STRING, amount DECIMAL)",
+				"CREATE INDEX idx_postings_kind ON postings (kind) USING BTREE",
+				"INSERT INTO postings VALUES (1, 10, 'deposit', 10.50)",
+				"INSERT INTO postings VALUES (2, 20, 'deposit', '1.05e1')",
+				"INSERT INTO postings VALUES (3, 10, 'withdrawal', 3.333)",
+				"INSERT INTO postings (id, account, kind) VALUES (4, 20, 'withdrawal')",
+				"INSERT INTO postings (id, account, amount) VALUES (5, 30, 1)",
+				"INSERT INTO postings VALUES (6, 10, 'fee', 0.1)",
+				"INSERT INTO postings VALUES (7, 10, 'fee', 0.2)",
+				"SELECT kind, COUNT(*), COUNT(amount), SUM(amount), AVG(amount), MIN(amount), MAX(amount) FROM postings GROUP BY kind",
+				"SELECT COUNT(DISTINCT amount), SUM(DISTINCT amount) FROM postings",
+				"SELECT account, SUM(amount) FROM postings GROUP BY account HAVING COUNT(*) > 1 ORDER BY SUM(amount) DESC LIMIT 1",
+				"SELECT COUNT(*), SUM(id), AVG(amount) FROM postings WHERE id > 100",
+			},
+			compare: []string{
+				"SELECT kind, COUNT(*), SUM(amount), AVG(amount) FROM postings WHERE kind > 'a' GROUP BY kind",
+				"SELECT kind, COUNT(*) FROM postings WHERE kind = 'fee' GROUP BY kind",
+				"SELECT COUNT(*), SUM(amount), MIN(kind), MAX(kind) FROM postings WHERE kind >= 'deposit'",
+				"SELECT account, SUM(amount) FROM postings WHERE kind > 'a' GROUP BY account HAVING SUM(amount) > 1 ORDER BY account",
+				"SELECT LOWER(kind), COUNT(DISTINCT account) FROM postings WHERE kind < 'z' GROUP BY LOWER(kind)",
+				"SELECT account, COUNT(*) FROM postings WHERE kind > 'a' GROUP BY account ORDER BY COUNT(*) DESC, account LIMIT 2",
+				"SELECT postings.kind, COUNT(*) FROM postings GROUP BY kind HAVING kind IS NOT NULL",
+			},
+			expect: []expectRows{
+				{
+					"SELECT kind, COUNT(*), COUNT(amount), SUM(amount), AVG(amount), MIN(amount), MAX(amount) FROM postings GROUP BY kind",
+					[]string{
+						"<nil>,1,1,1,1,1,1",
+						"deposit,2,2,21.00,10.50,10.50,10.50",
+						"fee,2,2,0.3,0.15,0.1,0.2",
+						"withdrawal,2,1,3.333,3.333,3.333,3.333",
+					},
+				},
+				{"SELECT COUNT(DISTINCT amount), SUM(DISTINCT amount) FROM postings", []string{"5,15.133"}},
+				{"SELECT account, SUM(amount) FROM postings GROUP BY account HAVING COUNT(*) > 1 ORDER BY SUM(amount) DESC LIMIT 1", []string{"10,14.133"}},
+				{"SELECT COUNT(*), SUM(id), AVG(amount) FROM postings WHERE id > 100", []string{"0,<nil>,<nil>"}},
+				{"SELECT AVG(amount), SUM(amount), AVG(account) FROM postings WHERE id >= 5", []string{"0.43333333333333333,1.3,16.6666666666666667"}},
+				{"SELECT COUNT(kind), COUNT(DISTINCT kind), MIN(kind), MAX(kind) FROM postings", []string{"6,3,deposit,withdrawal"}},
+				{"SELECT MIN(amount), MAX(amount), MIN(id), MAX(id) FROM postings", []string{"0.1,10.50,1,7"}},
+				{"SELECT Kind, count(*) FROM postings WHERE KIND = 'fee' GROUP BY kind", []string{"fee,2"}},
+			},
+		},
+		{
+			name: "39. Error Case: GROUP BY and Aggregates",
+			queries: []string{
+				"SELECT kind, id FROM postings GROUP BY kind",
+				"SELECT kind FROM postings GROUP BY kind ORDER BY amount",
+				"SELECT SUM(kind) FROM postings",
+				"SELECT id FROM postings WHERE COUNT(*) > 1",
+				"SELECT SUM(*) FROM postings",
+				"SELECT SUM(MAX(amount)) FROM postings",
+				"SELECT * FROM postings GROUP BY kind",
+				"SELECT kind FROM postings GROUP BY kind HAVING SUM(amount)",
+				"SELECT COUNT(*) FROM postings GROUP BY COUNT(*)",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, t := range tests {
@@ -88,14 +718,241 @@ func main() {
 					fmt.Printf("  Expected Error: %v\n", err)
 				} else {
 					fmt.Printf("  UNEXPECTED ERROR: %v\n", err)
-					os.Exit(1)
+					return 1
 				}
 			} else if t.wantErr {
 				fmt.Printf("  Expected Error but got Success\n")
-				os.Exit(1)
+				return 1
+			}
+		}
+		for _, q := range t.compare {
+			fmt.Printf("  Compare: %s\n", q)
+			if err := sameRows(r, q); err != nil {
+				fmt.Printf("  MISMATCH: %v\n", err)
+				return 1
+			}
+		}
+		for _, e := range t.expect {
+			fmt.Printf("  Expect: %s\n", e.sql)
+			got, err := queryRows(r, e.sql)
+			if err != nil {
+				fmt.Printf("  UNEXPECTED ERROR: %v\n", err)
+				return 1
+			}
+			if strings.Join(got, "\n") != strings.Join(e.rows, "\n") {
+				fmt.Printf("  MISMATCH: got %q, want %q\n", got, e.rows)
+				return 1
 			}
 		}
 		fmt.Println("  PASS")
 	}
+
+	fmt.Println("Running Test: Keyset Pages Read Only Their Rows")
+	if err := checkKeysetReads(r); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: ORDER BY Spilling to Temporary Files")
+	if err := checkSortSpill(r); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Rolled Back DROP INDEX Keeps the Index")
+	if err := checkDropIndexRollback(r); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Failed CREATE INDEX Leaves No File")
+	if err := checkCreateIndexRollback(r); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Bare VACUUM Leaves the System Tables")
+	if err := checkVacuumTables(r); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: VACUUM Moves Each Row at Most Once")
+	if err := checkVacuumMoves(r); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Check Keeps Index Entries Into a Damaged Page")
+	if err := checkDamagedPageIndex(); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Legacy catalog.json Is Migrated")
+	if err := checkCatalogMigration(); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Hash Index Reopens Without a Rebuild")
+	if err := checkHashIndexReopen(); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Read-Only Engine Sees Committed Rows")
+	if err := checkReadOnlyReader(); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Tuples Larger Than a Page")
+	if err := checkOverflowTuples(); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Buffer Pool Evicts and Writes Back Pages")
+	if err := checkBufferPoolEviction(); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Insert With a Stale Free Space Map")
+	if err := checkStaleFreeSpaceMap(); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Upgrade Dry Run Writes Nothing")
+	if err := checkUpgradeDryRun(); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
+
+	fmt.Println("Running Test: Crash Recovery After an Abort")
+	if err := checkRecovery(); err != nil {
+		fmt.Printf("  FAIL: %v\n", err)
+		return 1
+	}
+	fmt.Println("  PASS")
 	fmt.Println("ALL TESTS PASSED")
+	return 0
+}
+
+// tempDataDir creates an empty directory for a test's data files under the
+// system temporary directory. The caller removes it.
+func tempDataDir(name string) (string, error) {
+	return os.MkdirTemp("", "minibank-"+name+"-")
+}
+
+// bossChain creates a table emp whose n rows each name the previous row as
+// their boss through a foreign key ON DELETE SET NULL, then runs del.
+func bossChain(n int, del string) []string {
+	queries := []string{"CREATE TABLE emp (id INT, boss INT REFERENCES emp ON DELETE SET NULL, name STRING, PRIMARY KEY (id))"}
+	for i := 1; i <= n; i++ {
+		boss := "NULL"
+		if i > 1 {
+			boss = fmt.Sprint(i - 1)
+		}
+		queries = append(queries, fmt.Sprintf("INSERT INTO emp VALUES (%d, %s, 'n%d')", i, boss, i))
+	}
+	return append(queries, del)
+}
+
+// expectRows is a SELECT and the rows it must return.
+type expectRows struct {
+	sql  string
+	rows []string
+}
+
+// sameRows runs a SELECT with the planner's indexes and again with none, so
+// that it reads the table with a SeqScan, and checks both return the same
+// rows.
+func sameRows(r *repl.REPL, sql string) error {
+	indexed, err := queryRows(r, sql)
+	if err != nil {
+		return err
+	}
+	indices := r.Planner.Indices
+	r.Planner.Indices = map[string]indexing.Index{}
+	scanned, err := queryRows(r, sql)
+	r.Planner.Indices = indices
+	if err != nil {
+		return err
+	}
+
+	if strings.Join(indexed, "\n") != strings.Join(scanned, "\n") {
+		return fmt.Errorf("index returned %v, seq scan returned %v", indexed, scanned)
+	}
+	return nil
+}
+
+// queryRows returns the rows of a SELECT, formatted and, unless it has an
+// ORDER BY, sorted.
+func queryRows(r *repl.REPL, sql string) ([]string, error) {
+	ast, err := parser.NewParser(parser.NewLexer(sql)).Parse()
+	if err != nil {
+		return nil, err
+	}
+	it, err := r.Planner.CreatePlan(ast)
+	if err != nil {
+		return nil, err
+	}
+	if err := it.Open(); err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var rows []string
+	for {
+		tuple, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if tuple == nil {
+			break
+		}
+		rows = append(rows, formatRow(tuple))
+	}
+	if sel, ok := ast.(*parser.SelectStmt); !ok || len(sel.OrderBy) == 0 {
+		sort.Strings(rows)
+	}
+	return rows, nil
+}
+
+// formatRow joins the values of a row with commas.
+func formatRow(t *storage.Tuple) string {
+	var vals []string
+	for _, cell := range t.Cells {
+		vals = append(vals, fmt.Sprint(cell.Value))
+	}
+	return strings.Join(vals, ",")
+}
+
+// statementRows runs queryRows in a transaction of its own, as the REPL runs
+// each statement.
+func statementRows(r *repl.REPL, sql string) ([]string, error) {
+	r.Storage.Begin()
+	rows, err := queryRows(r, sql)
+	if err != nil {
+		r.Storage.Abort()
+		return nil, err
+	}
+	return rows, r.Storage.Commit()
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"minibank/internal/checker"
+	"minibank/internal/storage"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkOverflowTuples stores rows several pages long, reads them back, updates
+// and deletes them, and checks that the overflow file stops growing once
+// freed chains are reused, and that -mode check finds nothing wrong.
+func checkOverflowTuples() error {
+	dir, err := tempDataDir("overflow")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := openREPL(dir, storage.NewEngine)
+	if err != nil {
+		return err
+	}
+	defer r.Storage.Close()
+
+	body := func(c string) string { return strings.Repeat(c, 3*storage.PageSize) }
+	ovfSize := func() (int64, error) {
+		fi, err := os.Stat(filepath.Join(dir, "memos.ovf"))
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+	expect := func(want ...string) error {
+		got, err := queryRows(r, "SELECT id, body FROM memos")
+		if err != nil {
+			return err
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			return fmt.Errorf("memos holds %d rows that do not match the %d written", len(got), len(want))
+		}
+		return nil
+	}
+
+	for _, q := range []string{
+		"CREATE TABLE memos (id INT PRIMARY KEY, body STRING)",
+		fmt.Sprintf("INSERT INTO memos VALUES (1, '%s')", body("a")),
+		fmt.Sprintf("UPDATE memos SET body = '%s' WHERE id = 1", body("b")),
+	} {
+		if err := r.Execute(q); err != nil {
+			return err
+		}
+	}
+	if err := expect("1," + body("b")); err != nil {
+		return err
+	}
+	size, err := ovfSize()
+	if err != nil {
+		return err
+	}
+	if size < 3*storage.PageSize {
+		return fmt.Errorf("overflow file has %d bytes, too few for the rows written", size)
+	}
+
+	for _, q := range []string{
+		fmt.Sprintf("UPDATE memos SET body = '%s' WHERE id = 1", body("c")),
+		"DELETE FROM memos WHERE id = 1",
+		fmt.Sprintf("INSERT INTO memos VALUES (2, '%s')", body("d")),
+	} {
+		if err := r.Execute(q); err != nil {
+			return err
+		}
+	}
+	if err := expect("2," + body("d")); err != nil {
+		return err
+	}
+	after, err := ovfSize()
+	if err != nil {
+		return err
+	}
+	if after != size {
+		return fmt.Errorf("overflow file grew from %d to %d bytes instead of reusing freed pages", size, after)
+	}
+
+	report, err := checker.Run(r.Catalog, r.Storage, r.Planner.Indices)
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("check found %v", report.Problems)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"minibank/internal/repl"
+	"minibank/internal/storage"
+	"os"
+	"strings"
+)
+
+// checkReadOnlyReader opens a writer and a read-only engine on one directory
+// and checks that, after every round of writes, the reader's statements
+// return the rows the writer committed, rather than the pages it cached
+// before.
+func checkReadOnlyReader() error {
+	dir, err := tempDataDir("readonly")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	writer, err := openREPL(dir, storage.NewEngine)
+	if err != nil {
+		return err
+	}
+	defer writer.Storage.Close()
+	if err := writer.Execute("CREATE TABLE accounts (id INT PRIMARY KEY, owner STRING, balance INT)"); err != nil {
+		return err
+	}
+	reader, err := openREPL(dir, storage.NewReadOnlyEngine)
+	if err != nil {
+		return err
+	}
+	defer reader.Storage.Close()
+
+	queries := []string{
+		"SELECT id, balance FROM accounts",
+		"SELECT COUNT(*), SUM(balance) FROM accounts",
+		"SELECT owner FROM accounts WHERE id = 42",
+		"SELECT id FROM accounts WHERE id > 150 ORDER BY id LIMIT 5",
+	}
+	// Long owners spread each round's rows over new pages.
+	owner := strings.Repeat("o", 200)
+	for round := 0; round < 3; round++ {
+		for _, sql := range queries {
+			if err := sameAsWriter(writer, reader, sql); err != nil {
+				return err
+			}
+		}
+		for i := 1; i <= 100; i++ {
+			id := round*100 + i
+			sql := fmt.Sprintf("INSERT INTO accounts VALUES (%d, '%s-%d', %d)", id, owner, id, id%10)
+			if err := writer.Execute(sql); err != nil {
+				return err
+			}
+		}
+		for _, sql := range []string{
+			fmt.Sprintf("UPDATE accounts SET balance = %d WHERE id < 50", 100+round),
+			fmt.Sprintf("DELETE FROM accounts WHERE id = %d", round*100+7),
+		} {
+			if err := writer.Execute(sql); err != nil {
+				return err
+			}
+		}
+	}
+	for _, sql := range queries {
+		if err := sameAsWriter(writer, reader, sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openREPL opens dir with open and returns a REPL on it with its catalog and
+// indexes loaded.
+func openREPL(dir string, open func(string) (*storage.Engine, error)) (*repl.REPL, error) {
+	store, err := open(dir)
+	if err != nil {
+		return nil, err
+	}
+	cat, err := store.OpenCatalog()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	r := repl.NewREPL(cat, store, dir)
+	if err := r.Planner.OpenIndices(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// sameAsWriter runs a SELECT as a statement on both engines and checks that
+// the reader returns the writer's rows.
+func sameAsWriter(writer, reader *repl.REPL, sql string) error {
+	want, err := statementRows(writer, sql)
+	if err != nil {
+		return err
+	}
+	got, err := statementRows(reader, sql)
+	if err != nil {
+		return fmt.Errorf("read-only %s: %w", sql, err)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		return fmt.Errorf("read-only %s returned %v, writer returned %v", sql, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"minibank/internal/parser"
+	"minibank/internal/repl"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// checkSortSpill sorts a table far larger than a few KiB of sort memory, so
+// that ORDER BY spills dozens of runs and merges them in more than one pass,
+// and checks the order of the rows against a sort done here, with NULLs
+// first for ASC and last for DESC. A top-N sort whose rows do not fit falls
+// back to the same path. Every query must leave its temporary files while
+// open and none once closed.
+func checkSortSpill(r *repl.REPL) error {
+	const rows = 1200
+	if err := r.Execute("CREATE TABLE sort_rows (id INT PRIMARY KEY, account INT, amount DECIMAL)"); err != nil {
+		return err
+	}
+	for i := 1; i <= rows; i++ {
+		account, amount := strconv.Itoa(i*7%13), fmt.Sprintf("'%d.%02d'", i*37%101-50, i%100)
+		if i%11 == 0 {
+			account = "NULL"
+		}
+		if i%17 == 0 {
+			amount = "NULL"
+		}
+		sql := fmt.Sprintf("INSERT INTO sort_rows VALUES (%d, %s, %s)", i, account, amount)
+		if err := r.Execute(sql); err != nil {
+			return err
+		}
+	}
+	all, err := queryRows(r, "SELECT id, account, amount FROM sort_rows")
+	if err != nil {
+		return err
+	}
+
+	dir, err := tempDataDir("sort")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp, hadTmp := os.LookupEnv("TMPDIR")
+	os.Setenv("TMPDIR", dir)
+	defer func() {
+		if hadTmp {
+			os.Setenv("TMPDIR", tmp)
+		} else {
+			os.Unsetenv("TMPDIR")
+		}
+	}()
+	memory := r.Planner.SortMemory
+	r.Planner.SortMemory = 4 << 10
+	defer func() { r.Planner.SortMemory = memory }()
+
+	for _, c := range []struct {
+		order string
+		limit int // 0 for none
+		spill bool
+	}{
+		{"account DESC, amount, id", 0, true},
+		{"amount DESC, account, id DESC", 0, true},
+		{"amount, id", 300, true},
+		{"account, amount DESC, id", 5, false},
+	} {
+		sql := "SELECT id, account, amount FROM sort_rows ORDER BY " + c.order
+		want := sortedLike(all, c.order)
+		if c.limit > 0 {
+			sql += fmt.Sprintf(" LIMIT %d", c.limit)
+			want = want[:c.limit]
+		}
+		got, runs, err := sortRuns(r, sql, dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sql, err)
+		}
+		if c.spill && runs == 0 {
+			return fmt.Errorf("%s: wrote no runs with %d bytes of sort memory", sql, r.Planner.SortMemory)
+		}
+		if !c.spill && runs != 0 {
+			return fmt.Errorf("%s: top-N sort wrote %d runs", sql, runs)
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			return fmt.Errorf("%s: rows out of order", sql)
+		}
+		left, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		if len(left) > 0 {
+			return fmt.Errorf("%s: left %d temporary files", sql, len(left))
+		}
+	}
+	return nil
+}
+
+// sortRuns runs a SELECT and returns its rows, formatted as by queryRows,
+// and the number of files in dir once the query is open, before closing it.
+func sortRuns(r *repl.REPL, sql, dir string) ([]string, int, error) {
+	ast, err := parser.NewParser(parser.NewLexer(sql)).Parse()
+	if err != nil {
+		return nil, 0, err
+	}
+	it, err := r.Planner.CreatePlan(ast)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := it.Open(); err != nil {
+		return nil, 0, err
+	}
+	defer it.Close()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var rows []string
+	for {
+		tuple, err := it.Next()
+		if err != nil {
+			return nil, 0, err
+		}
+		if tuple == nil {
+			break
+		}
+		rows = append(rows, formatRow(tuple))
+	}
+	return rows, len(files), nil
+}
+
+// sortedLike sorts rows of id, account and amount, formatted as by
+// queryRows, by an ORDER BY list of those columns.
+func sortedLike(rows []string, order string) []string {
+	columns := map[string]int{"id": 0, "account": 1, "amount": 2}
+	type key struct {
+		col  int
+		desc bool
+	}
+	var keys []key
+	for _, item := range strings.Split(order, ",") {
+		f := strings.Fields(item)
+		keys = append(keys, key{columns[f[0]], len(f) > 1 && f[1] == "DESC"})
+	}
+	value := func(row string, col int) *big.Rat {
+		v := strings.Split(row, ",")[col]
+		if v == "<nil>" {
+			return nil
+		}
+		n, _ := new(big.Rat).SetString(v)
+		return n
+	}
+
+	sorted := append([]string(nil), rows...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		for _, k := range keys {
+			a, b := value(sorted[i], k.col), value(sorted[j], k.col)
+			c := 0
+			switch {
+			case a == nil && b == nil:
+			case a == nil:
+				c = -1
+			case b == nil:
+				c = 1
+			default:
+				c = a.Cmp(b)
+			}
+			if k.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return sorted
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"minibank/internal/storage"
+	"os"
+	"path/filepath"
+)
+
+// checkUpgradeDryRun writes a format version 0 table and checks that an
+// upgrade dry run reports it without writing anything to the directory, not
+// even the LOCK file.
+func checkUpgradeDryRun() error {
+	dir, err := tempDataDir("upgrade")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// One legacy heap page: [slot count][free space pointer][offset, length]
+	// and the tuple at the end of the page.
+	tuple := []byte("legacy row")
+	page := make([]byte, storage.PageSize)
+	fsp := storage.PageSize - len(tuple)
+	binary.BigEndian.PutUint16(page[0:2], 1)
+	binary.BigEndian.PutUint16(page[2:4], uint16(fsp))
+	binary.BigEndian.PutUint16(page[4:6], uint16(fsp))
+	binary.BigEndian.PutUint16(page[6:8], uint16(len(tuple)))
+	copy(page[fsp:], tuple)
+	if err := os.WriteFile(filepath.Join(dir, "old.data"), page, 0644); err != nil {
+		return err
+	}
+
+	before, err := snapshotDir(dir)
+	if err != nil {
+		return err
+	}
+	results, err := storage.UpgradeDataDir(dir, true)
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 || !results[0].NeedsUpgrade() || results[0].Tuples != 1 {
+		return fmt.Errorf("dry run reported %+v, want one table with 1 tuple to upgrade", results)
+	}
+	after, err := snapshotDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(after) != len(before) {
+		return fmt.Errorf("dry run left %d files, had %d", len(after), len(before))
+	}
+	for name, data := range before {
+		if !bytes.Equal(after[name], data) {
+			return fmt.Errorf("dry run changed %s", name)
+		}
+	}
+	return nil
+}
+
+// snapshotDir returns the contents of every file in dir by name.
+func snapshotDir(dir string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files[e.Name()] = data
+	}
+	return files, nil
+}
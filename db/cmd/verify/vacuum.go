@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/repl"
+	"strings"
+)
+
+// checkVacuumTables runs a bare VACUUM and checks that it compacts every user
+// table but leaves the system tables alone, then vacuums a system table by
+// name and checks that the catalog still describes the same tables.
+func checkVacuumTables(r *repl.REPL) error {
+	rows, err := statementRows(r, "VACUUM")
+	if err != nil {
+		return err
+	}
+	vacuumed := make(map[string]bool)
+	for _, row := range rows {
+		name, _, _ := strings.Cut(row, ",")
+		if catalog.IsSystemTable(name) {
+			return fmt.Errorf("bare VACUUM compacted system table %s", name)
+		}
+		vacuumed[name] = true
+	}
+	for _, t := range r.Catalog.UserTables() {
+		if !vacuumed[t.Name] {
+			return fmt.Errorf("bare VACUUM skipped table %s", t.Name)
+		}
+	}
+
+	const count = "SELECT kind, COUNT(*) FROM postings GROUP BY kind"
+	before, err := queryRows(r, count)
+	if err != nil {
+		return err
+	}
+	rows, err = statementRows(r, "VACUUM "+catalog.SystemTablePrefix+"columns")
+	if err != nil {
+		return err
+	}
+	if len(rows) != 1 {
+		return fmt.Errorf("VACUUM of one table returned %d rows", len(rows))
+	}
+	fresh := catalog.NewCatalog()
+	if err := r.Storage.LoadCatalog(fresh); err != nil {
+		return err
+	}
+	if got, want := len(fresh.UserTables()), len(r.Catalog.UserTables()); got != want {
+		return fmt.Errorf("catalog lists %d tables after VACUUM, want %d", got, want)
+	}
+	for _, t := range r.Catalog.UserTables() {
+		loaded, ok := fresh.GetTable(t.Name)
+		if !ok || len(loaded.Columns) != len(t.Columns) {
+			return fmt.Errorf("table %s lost its columns after VACUUM", t.Name)
+		}
+	}
+	after, err := queryRows(r, count)
+	if err != nil {
+		return err
+	}
+	if strings.Join(before, "\n") != strings.Join(after, "\n") {
+		return fmt.Errorf("%s returned %v after VACUUM, %v before", count, after, before)
+	}
+	return nil
+}
+
+// checkVacuumMoves spreads a table over several pages, deletes most of it and
+// checks that VACUUM shrinks the file while moving each surviving row at most
+// once, and that the primary key index still finds every row afterwards.
+func checkVacuumMoves(r *repl.REPL) error {
+	pad := strings.Repeat("x", 40)
+	queries := []string{"CREATE TABLE vac_rows (id INT PRIMARY KEY, keep INT, pad STRING)"}
+	for i := 0; i < 350; i++ {
+		keep := 0
+		if i%7 == 0 {
+			keep = 1
+		}
+		queries = append(queries, fmt.Sprintf("INSERT INTO vac_rows VALUES (%d, %d, '%s')", i, keep, pad))
+	}
+	queries = append(queries, "DELETE FROM vac_rows WHERE keep = 0")
+	for _, q := range queries {
+		if err := r.Execute(q); err != nil {
+			return fmt.Errorf("%s: %w", q, err)
+		}
+	}
+
+	rows, err := statementRows(r, "VACUUM vac_rows")
+	if err != nil {
+		return err
+	}
+	if len(rows) != 1 {
+		return fmt.Errorf("VACUUM of one table returned %d rows", len(rows))
+	}
+	var name string
+	var before, after, moved, reclaimed int
+	if _, err := fmt.Sscanf(strings.ReplaceAll(rows[0], ",", " "), "%s %d %d %d %d", &name, &before, &after, &moved, &reclaimed); err != nil {
+		return fmt.Errorf("VACUUM returned %q: %w", rows[0], err)
+	}
+	if after >= before {
+		return fmt.Errorf("VACUUM left %d of %d pages", after, before)
+	}
+	if moved > 50 {
+		return fmt.Errorf("VACUUM moved %d tuples for 50 live rows", moved)
+	}
+
+	got, err := queryRows(r, "SELECT COUNT(*) FROM vac_rows")
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 || got[0] != "50" {
+		return fmt.Errorf("table has %v rows after VACUUM, want 50", got)
+	}
+	for _, id := range []int{0, 175, 343} {
+		if err := sameRows(r, fmt.Sprintf("SELECT * FROM vac_rows WHERE id = %d", id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -22,20 +24,134 @@ type Column struct {
 	Type      ColumnType `json:"type"`
 	IsPrimary bool       `json:"is_primary"`
 	IsUnique  bool       `json:"is_unique"`
+	NotNull   bool       `json:"not_null,omitempty"`
+	Default   string     `json:"default,omitempty"` // DEFAULT expression in SQL; empty for NULL
 	TableName string     `json:"-"`
 }
 
+// CheckDef is a CHECK constraint of a table: a row may not make Expr false.
+type CheckDef struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"` // in SQL
+}
+
+// ForeignKeyDef is a FOREIGN KEY constraint of a table: unless one of them is
+// NULL, the values of Columns must be those of RefColumns in a row of
+// RefTable. RefColumns are the primary key or a UNIQUE column of RefTable.
+type ForeignKeyDef struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	RefTable   string   `json:"ref_table"`
+	RefColumns []string `json:"ref_columns"`
+	OnDelete   string   `json:"on_delete"` // what deleting a referenced row does: one of the Action constants
+	OnUpdate   string   `json:"on_update"` // what changing the key of a referenced row does
+}
+
+// Referential actions of a foreign key.
+const (
+	ActionRestrict = "RESTRICT" // fail the statement
+	ActionCascade  = "CASCADE"  // delete the referencing rows, or change their key too
+	ActionSetNull  = "SET NULL" // set the referencing columns to NULL
+)
+
+// Index types. Each is stored in its own file; only BTREE indexes support
+// range scans, and TRIGRAM indexes only serve LIKE and MATCH on one STRING
+// column or expression.
+const (
+	IndexTypeHash    = "HASH"
+	IndexTypeBTree   = "BTREE"
+	IndexTypeTrigram = "TRIGRAM"
+)
+
 type IndexDef struct {
-	Name     string `json:"name"`
-	Column   string `json:"column"`
-	Type     string `json:"type"`
-	IsUnique bool   `json:"is_unique"`
+	Name      string   `json:"name"`
+	Columns   []string `json:"columns"`           // column names, or expressions such as LOWER(email) in SQL
+	Include   []string `json:"include,omitempty"` // stored with the key, not part of it
+	Where     string   `json:"where,omitempty"`   // predicate of a partial index, in SQL
+	Type      string   `json:"type"`
+	IsUnique  bool     `json:"is_unique"`
+	KeyFormat int      `json:"key_format,omitempty"` // indexing.KeyFormat the file was built with; 0 before keys were canonical
+}
+
+// UnmarshalJSON also reads the single "column" of indexes stored before an
+// index could have several columns.
+func (d *IndexDef) UnmarshalJSON(data []byte) error {
+	type plain IndexDef
+	var v struct {
+		plain
+		Column string `json:"column"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*d = IndexDef(v.plain)
+	if len(d.Columns) == 0 && v.Column != "" {
+		d.Columns = []string{v.Column}
+	}
+	return nil
 }
 
 type Table struct {
 	Name    string     `json:"name"`
-	Columns []Column   `json:"columns"`
-	Indexes []IndexDef `json:"indexes"`
+	Columns []Column   `json:"columns,omitempty"`
+	Indexes []IndexDef `json:"indexes,omitempty"`
+	// PrimaryKey lists the primary key's columns in key order. Tables stored
+	// before it existed only mark their primary key column IsPrimary.
+	PrimaryKey  []string        `json:"primary_key,omitempty"`
+	Checks      []CheckDef      `json:"checks,omitempty"`
+	ForeignKeys []ForeignKeyDef `json:"foreign_keys,omitempty"`
+}
+
+// PrimaryKeyColumns returns the columns of the table's primary key, if any.
+func (t *Table) PrimaryKeyColumns() []string {
+	if len(t.PrimaryKey) > 0 {
+		return t.PrimaryKey
+	}
+	var cols []string
+	for _, col := range t.Columns {
+		if col.IsPrimary {
+			cols = append(cols, col.Name)
+		}
+	}
+	return cols
+}
+
+// System tables hold the catalog itself. Their definitions are built in and
+// are never stored; users can read them but not change them.
+const (
+	SystemTablePrefix = "mb_"
+
+	SysTables  = "mb_tables"
+	SysColumns = "mb_columns"
+	SysIndexes = "mb_indexes"
+)
+
+// systemTables returns the definitions of the system tables. Each row keeps
+// the full definition as JSON in its def column, so new catalog fields need
+// no change to the system tables.
+func systemTables() []*Table {
+	return []*Table{
+		{Name: SysTables, Columns: []Column{
+			{Name: "name", Type: TypeString, IsPrimary: true},
+			{Name: "def", Type: TypeString},
+		}},
+		{Name: SysColumns, Columns: []Column{
+			{Name: "table_name", Type: TypeString},
+			{Name: "position", Type: TypeInt},
+			{Name: "name", Type: TypeString},
+			{Name: "type", Type: TypeString},
+			{Name: "def", Type: TypeString},
+		}},
+		{Name: SysIndexes, Columns: []Column{
+			{Name: "table_name", Type: TypeString},
+			{Name: "name", Type: TypeString},
+			{Name: "def", Type: TypeString},
+		}},
+	}
+}
+
+func IsSystemTable(name string) bool {
+	return strings.HasPrefix(name, SystemTablePrefix)
 }
 
 type Catalog struct {
@@ -44,23 +160,60 @@ type Catalog struct {
 }
 
 func NewCatalog() *Catalog {
-	return &Catalog{
-		Tables: make(map[string]*Table),
+	c := &Catalog{}
+	c.Load(nil)
+	return c
+}
+
+// Load replaces the user tables with tables. The system tables are always
+// present.
+func (c *Catalog) Load(tables []*Table) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Tables = make(map[string]*Table)
+	for _, t := range systemTables() {
+		c.Tables[t.Name] = t
+	}
+	for _, t := range tables {
+		c.Tables[t.Name] = t
 	}
 }
 
-func (c *Catalog) CreateTable(name string, columns []Column) error {
+// UserTables returns the tables that are not system tables, sorted by name.
+func (c *Catalog) UserTables() []*Table {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var tables []*Table
+	for name, t := range c.Tables {
+		if !IsSystemTable(name) {
+			tables = append(tables, t)
+		}
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables
+}
+
+// CreateTable adds a table. Every column in primaryKey must also be marked
+// IsPrimary.
+func (c *Catalog) CreateTable(name string, columns []Column, primaryKey []string, checks []CheckDef) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if IsSystemTable(name) {
+		return fmt.Errorf("table names starting with %s are reserved for system tables", SystemTablePrefix)
+	}
 	if _, exists := c.Tables[name]; exists {
 		return fmt.Errorf("table %s already exists", name)
 	}
 
 	c.Tables[name] = &Table{
-		Name:    name,
-		Columns: columns,
-		Indexes: []IndexDef{},
+		Name:       name,
+		Columns:    columns,
+		Indexes:    []IndexDef{},
+		PrimaryKey: primaryKey,
+		Checks:     checks,
 	}
 	return nil
 }
@@ -73,15 +226,35 @@ func (c *Catalog) AddIndex(tableName string, idx IndexDef) error {
 	if !ok {
 		return fmt.Errorf("table %s not found", tableName)
 	}
+	if IsSystemTable(tableName) {
+		return fmt.Errorf("cannot index system table %s", tableName)
+	}
 	for _, existing := range t.Indexes {
 		if existing.Name == idx.Name {
-			return nil // Already exists, idempotent
+			return fmt.Errorf("index %s already exists on table %s", idx.Name, tableName)
 		}
 	}
 	t.Indexes = append(t.Indexes, idx)
 	return nil
 }
 
+func (c *Catalog) RemoveIndex(tableName, indexName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.Tables[tableName]
+	if !ok {
+		return fmt.Errorf("table %s not found", tableName)
+	}
+	for i, existing := range t.Indexes {
+		if existing.Name == indexName {
+			t.Indexes = append(t.Indexes[:i:i], t.Indexes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("index %s not found on table %s", indexName, tableName)
+}
+
 func (c *Catalog) GetTable(name string) (*Table, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -89,17 +262,8 @@ func (c *Catalog) GetTable(name string) (*Table, bool) {
 	return t, ok
 }
 
-func (c *Catalog) SaveToFile(path string) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	data, err := json.MarshalIndent(c, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(path, data, 0644)
-}
-
+// LoadFromFile reads a catalog.json written by versions that kept the catalog
+// in a file. It is only used to migrate such a file into the system tables.
 func (c *Catalog) LoadFromFile(path string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
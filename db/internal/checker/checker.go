@@ -0,0 +1,280 @@
+package checker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"minibank/internal/catalog"
+	"minibank/internal/execution"
+	"minibank/internal/indexing"
+	"minibank/internal/storage"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Report struct {
+	Tables   int
+	Pages    int
+	Tuples   int
+	Problems []storage.Problem
+}
+
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Run verifies every heap file in the catalog and cross-checks the given
+// indexes, keyed by "<table>.<index>", against the tuples that were found.
+func Run(cat *catalog.Catalog, store *storage.Engine, indices map[string]indexing.Index) (*Report, error) {
+	report := &Report{}
+
+	var names []string
+	for name := range cat.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		table, _ := cat.GetTable(name)
+		hf, err := store.GetHeapFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		type entry struct {
+			rid   storage.RID
+			tuple *storage.Tuple
+		}
+		var tuples []entry
+		pages, problems, err := hf.Verify(table.Columns, func(rid storage.RID, t *storage.Tuple) {
+			tuples = append(tuples, entry{rid, t})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("verify %s: %w", name, err)
+		}
+		report.Tables++
+		report.Pages += pages
+		report.Tuples += len(tuples)
+		// Index entries into pages or slots that could not be read are noted
+		// on those problems, not reported as pointing to missing tuples:
+		// rebuilding the index would drop the entries of rows that may still
+		// be there.
+		unread := make(map[storage.RID]int)
+		for i, p := range problems {
+			rid := storage.RID{PageID: p.PageID, SlotID: p.SlotID}
+			if _, seen := unread[rid]; p.Unread && !seen {
+				unread[rid] = len(report.Problems) + i
+			}
+		}
+		report.Problems = append(report.Problems, problems...)
+
+		for _, def := range table.Indexes {
+			idx, ok := indices[name+"."+def.Name]
+			if !ok {
+				continue
+			}
+			ti, err := execution.NewTableIndex(table, def, idx)
+			if err != nil {
+				continue
+			}
+			values := make(map[storage.RID]indexing.Key, len(tuples))
+			for _, e := range tuples {
+				if ok, err := ti.Holds(e.tuple.Cells); err != nil || !ok {
+					continue
+				}
+				if values[e.rid], err = ti.Entry(e.tuple.Cells); err != nil {
+					delete(values, e.rid)
+				}
+			}
+			check := checkIndex
+			if _, ok := idx.(*indexing.TrigramIndex); ok {
+				check = checkTrigramIndex
+			}
+			if err := check(report, name, def, ti, values, unread); err != nil {
+				report.Problems = append(report.Problems, unreadableIndex(name, def.Name, err))
+			}
+		}
+	}
+	return report, nil
+}
+
+// checkIndex cross-checks an index against the values (key, then included
+// columns) of every live tuple that the index should hold, and a unique
+// index for keys that several tuples share. Values are compared in their
+// index encoding, so 10.5 and 10.50 are the same DECIMAL. Entries for tuples
+// in unread are counted against the problem that kept them from being read.
+// It returns an error when the index itself cannot be read.
+func checkIndex(report *Report, table string, def catalog.IndexDef, ti execution.TableIndex, live map[storage.RID]indexing.Key, unread map[storage.RID]int) error {
+	key, idx := def.Name, ti.Index
+	types := ti.EntryTypes()
+	duplicated := make(map[string]bool)
+	for rid, values := range live {
+		val := values[:len(def.Columns)]
+		enc, err := indexing.EncodeKey(types, val)
+		if err != nil {
+			return err
+		}
+		rids, err := idx.Get(val)
+		if err != nil {
+			return err
+		}
+		if !containsRID(rids, rid) {
+			report.Problems = append(report.Problems, indexProblem(table, key, rid,
+				fmt.Sprintf("index %s has no entry for key %v", key, val)))
+		}
+		if !def.IsUnique || val.HasNull() || duplicated[string(enc)] {
+			continue
+		}
+		shared := 0
+		for _, r := range rids {
+			if _, ok := live[r]; ok {
+				shared++
+			}
+		}
+		if shared > 1 {
+			duplicated[string(enc)] = true
+			report.Problems = append(report.Problems, storage.Problem{
+				File:   table + ".data",
+				PageID: rid.PageID,
+				SlotID: rid.SlotID,
+				Msg:    fmt.Sprintf("unique index %s: %d tuples have key %v", key, shared, val),
+				Repair: fmt.Sprintf("change or delete all but one of the rows of %s with (%s) = %v", table, strings.Join(def.Columns, ", "), val),
+			})
+		}
+	}
+	lost := make(map[int]int)
+	err := idx.Scan(func(val indexing.Key, rid storage.RID) error {
+		heapVal, ok := live[rid]
+		if i, isUnread := unreadProblem(unread, rid); !ok && isUnread {
+			lost[i]++
+		} else if !ok {
+			missing := "a missing tuple"
+			if def.Where != "" {
+				missing = "a missing tuple or one that does not match " + def.Where
+			}
+			report.Problems = append(report.Problems, indexProblem(table, key, rid,
+				fmt.Sprintf("index %s entry for key %v points to %s", key, val, missing)))
+		} else if !sameValues(types, heapVal, val) {
+			report.Problems = append(report.Problems, indexProblem(table, key, rid,
+				fmt.Sprintf("index %s entry for key %v points to a tuple with key %v", key, val, heapVal)))
+		}
+		return nil
+	})
+	noteUnreadEntries(report, key, lost)
+	return err
+}
+
+// checkTrigramIndex cross-checks a trigram index against the trigrams of the
+// value of every live tuple.
+func checkTrigramIndex(report *Report, table string, def catalog.IndexDef, ti execution.TableIndex, live map[storage.RID]indexing.Key, unread map[storage.RID]int) error {
+	type posting struct {
+		trigram string
+		rid     storage.RID
+	}
+	want := make(map[posting]bool)
+	for rid, values := range live {
+		if s, ok := values[0].(string); ok {
+			for _, t := range indexing.Trigrams(s) {
+				want[posting{t, rid}] = true
+			}
+		}
+	}
+	lost := make(map[int]int)
+	err := ti.Index.Scan(func(val indexing.Key, rid storage.RID) error {
+		t, _ := val[0].(string)
+		if i, isUnread := unreadProblem(unread, rid); !want[posting{t, rid}] && isUnread {
+			lost[i]++
+		} else if !want[posting{t, rid}] {
+			report.Problems = append(report.Problems, indexProblem(table, def.Name, rid,
+				fmt.Sprintf("index %s entry for trigram %q points to a missing tuple or one without it", def.Name, t)))
+		}
+		delete(want, posting{t, rid})
+		return nil
+	})
+	noteUnreadEntries(report, def.Name, lost)
+	if err != nil {
+		return err
+	}
+	for p := range want {
+		report.Problems = append(report.Problems, indexProblem(table, def.Name, p.rid,
+			fmt.Sprintf("index %s has no entry for trigram %q", def.Name, p.trigram)))
+	}
+	return nil
+}
+
+// unreadProblem returns the problem that kept rid's tuple from being read,
+// whether it was reported for the slot or for the whole page.
+func unreadProblem(unread map[storage.RID]int, rid storage.RID) (int, bool) {
+	if i, ok := unread[rid]; ok {
+		return i, true
+	}
+	i, ok := unread[storage.RID{PageID: rid.PageID, SlotID: -1}]
+	return i, ok
+}
+
+// noteUnreadEntries adds to each unread page or slot problem how many entries
+// of the index point into it. lost counts the entries by problem.
+func noteUnreadEntries(report *Report, key string, lost map[int]int) {
+	for i, n := range lost {
+		report.Problems[i].Msg += fmt.Sprintf("; %d entries of index %s point here, do not reindex before it is repaired", n, key)
+	}
+}
+
+// sameValues reports whether two entries hold the same values as the index
+// compares them.
+func sameValues(types []catalog.ColumnType, a, b indexing.Key) bool {
+	ka, errA := indexing.EncodeKey(types, a)
+	kb, errB := indexing.EncodeKey(types, b)
+	return errA == nil && errB == nil && bytes.Equal(ka, kb)
+}
+
+func unreadableIndex(table, key string, err error) storage.Problem {
+	p := storage.Problem{
+		File:   table + ".data",
+		SlotID: -1,
+		Msg:    fmt.Sprintf("index %s cannot be read: %v", key, err),
+		Repair: fmt.Sprintf("rebuild index %s with `minibank -mode reindex`", key),
+	}
+	var corrupt *storage.CorruptPageError
+	if errors.As(err, &corrupt) {
+		p.File = filepath.Base(corrupt.Path)
+		p.PageID = corrupt.PageID
+		p.Msg = fmt.Sprintf("index %s: %s", key, corrupt.Reason)
+	}
+	return p
+}
+
+func containsRID(rids []storage.RID, rid storage.RID) bool {
+	for _, r := range rids {
+		if r == rid {
+			return true
+		}
+	}
+	return false
+}
+
+func indexProblem(table, key string, rid storage.RID, msg string) storage.Problem {
+	return storage.Problem{
+		File:   table + ".data",
+		PageID: rid.PageID,
+		SlotID: rid.SlotID,
+		Msg:    msg,
+		Repair: fmt.Sprintf("rebuild index %s with `minibank -mode reindex`", key),
+	}
+}
+
+// Print writes the repair report.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Checked %d tables, %d pages, %d tuples\n", r.Tables, r.Pages, r.Tuples)
+	if r.OK() {
+		fmt.Fprintln(w, "No problems found.")
+		return
+	}
+	fmt.Fprintf(w, "%d problems found:\n", len(r.Problems))
+	for i, p := range r.Problems {
+		fmt.Fprintf(w, "%3d. %s\n", i+1, p)
+		fmt.Fprintf(w, "     repair: %s\n", p.Repair)
+	}
+}
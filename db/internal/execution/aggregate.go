@@ -0,0 +1,415 @@
+package execution
+
+import (
+	"fmt"
+	"math/big"
+	"minibank/internal/catalog"
+	"minibank/internal/errors"
+	"minibank/internal/indexing"
+	"minibank/internal/parser"
+	"minibank/internal/storage"
+	"strings"
+)
+
+// avgDigits is the number of digits an AVG shows beyond those of its values
+// when its exact value needs more; the last one is rounded.
+const avgDigits = 16
+
+// Aggregate groups the tuples of Child by the values of GroupBy and computes
+// Aggs over each group. Groups are kept in a hash table keyed by their
+// values encoded like an index key (indexing.EncodeKey), so DECIMAL 10.5 and
+// 10.50 fall in one group, and so do NULLs. It reads its whole input on
+// Open. Its rows hold the GROUP BY values and then the aggregates, in the
+// order the groups first appear; without GROUP BY there is one row, even for
+// no input.
+//
+// A GROUP BY column keeps its name. Other GROUP BY expressions and the
+// aggregates are columns named after their SQL text (parser.FormatExpr),
+// which is how expressions over its rows find them.
+//
+// SUM and AVG add up values as big.Rat, so DECIMAL results are exact: a SUM
+// shows as many digits after the point as the value that shows the most,
+// and an AVG as many, or up to avgDigits more if its exact value needs them.
+type Aggregate struct {
+	Child   Iterator
+	GroupBy []parser.Expression
+	Aggs    []*parser.AggregateExpr
+	schema  []catalog.Column
+
+	groupTypes []catalog.ColumnType
+	argTypes   []catalog.ColumnType // "" for COUNT(*)
+
+	// Runtime
+	groups []*group
+	curr   int
+}
+
+type group struct {
+	values []interface{}
+	accs   []*accumulator
+}
+
+// NewAggregate returns an Aggregate of child. It fails if an expression is
+// not a column of child or a function of one, or if an aggregate does not
+// take the type of its argument.
+func NewAggregate(child Iterator, groupBy []parser.Expression, aggs []*parser.AggregateExpr) (*Aggregate, error) {
+	a := &Aggregate{Child: child, GroupBy: groupBy, Aggs: aggs}
+	in := child.Schema()
+	for _, expr := range groupBy {
+		if len(Aggregates(expr)) > 0 {
+			return nil, fmt.Errorf("aggregate functions are not allowed in GROUP BY")
+		}
+		typ, err := exprType(expr, in)
+		if err != nil {
+			return nil, fmt.Errorf("GROUP BY %s: %w", parser.FormatExpr(expr), err)
+		}
+		a.groupTypes = append(a.groupTypes, typ)
+		if id, ok := expr.(*parser.IdentifierExpr); ok {
+			a.schema = append(a.schema, in[findColumn(in, id.Name)])
+		} else {
+			a.schema = append(a.schema, catalog.Column{Name: parser.FormatExpr(expr), Type: typ})
+		}
+	}
+	for _, agg := range aggs {
+		var argType catalog.ColumnType
+		if agg.Arg != nil {
+			if len(Aggregates(agg.Arg)) > 0 {
+				return nil, fmt.Errorf("%s: aggregate function calls cannot be nested", parser.FormatExpr(agg))
+			}
+			typ, err := exprType(agg.Arg, in)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", parser.FormatExpr(agg), err)
+			}
+			argType = typ
+		}
+		typ, err := resultType(agg, argType)
+		if err != nil {
+			return nil, err
+		}
+		a.argTypes = append(a.argTypes, argType)
+		a.schema = append(a.schema, catalog.Column{Name: parser.FormatExpr(agg), Type: typ})
+	}
+	return a, nil
+}
+
+func exprType(expr parser.Expression, schema []catalog.Column) (catalog.ColumnType, error) {
+	if err := checkExpr(expr, schema); err != nil {
+		return "", err
+	}
+	return ExprType(expr, schema)
+}
+
+// resultType returns the type of the values of agg: INT for COUNT, that of
+// the argument for SUM over INT and for MIN and MAX, and DECIMAL otherwise.
+func resultType(agg *parser.AggregateExpr, arg catalog.ColumnType) (catalog.ColumnType, error) {
+	switch agg.Name {
+	case "COUNT":
+		return catalog.TypeInt, nil
+	case "MIN", "MAX":
+		return arg, nil
+	}
+	switch arg {
+	case catalog.TypeInt:
+		if agg.Name == "SUM" {
+			return catalog.TypeInt, nil
+		}
+		return catalog.TypeDecimal, nil
+	case catalog.TypeDecimal:
+		return catalog.TypeDecimal, nil
+	}
+	return "", errors.New(errors.ErrTypeMismatch,
+		fmt.Sprintf("%s expects INT or DECIMAL, got %s", agg.Name, arg),
+		"Use COUNT, MIN or MAX on other columns.")
+}
+
+// Aggregates returns the aggregate calls in expr, outermost first.
+func Aggregates(expr parser.Expression) []*parser.AggregateExpr {
+	switch e := expr.(type) {
+	case *parser.AggregateExpr:
+		return append([]*parser.AggregateExpr{e}, Aggregates(e.Arg)...)
+	case *parser.BinaryExpr:
+		return append(Aggregates(e.Left), Aggregates(e.Right)...)
+	case *parser.IsNullExpr:
+		return Aggregates(e.Expr)
+	case *parser.FuncCallExpr:
+		var aggs []*parser.AggregateExpr
+		for _, arg := range e.Args {
+			aggs = append(aggs, Aggregates(arg)...)
+		}
+		return aggs
+	}
+	return nil
+}
+
+// Check reports an error unless expr can be evaluated on the rows of a: it
+// may only read the GROUP BY expressions, and columns elsewhere only inside
+// an aggregate.
+func (a *Aggregate) Check(expr parser.Expression) error {
+	if a.grouped(expr) {
+		return nil
+	}
+	switch e := expr.(type) {
+	case *parser.BinaryExpr:
+		if err := a.Check(e.Left); err != nil {
+			return err
+		}
+		return a.Check(e.Right)
+	case *parser.IsNullExpr:
+		return a.Check(e.Expr)
+	case *parser.FuncCallExpr:
+		for _, arg := range e.Args {
+			if err := a.Check(arg); err != nil {
+				return err
+			}
+		}
+	case *parser.AggregateExpr:
+		if findExpr(a.schema, e) == -1 {
+			return fmt.Errorf("aggregate %s is not computed", parser.FormatExpr(e))
+		}
+	case *parser.IdentifierExpr:
+		return errors.New(errors.ErrSyntax,
+			fmt.Sprintf("column %s must appear in the GROUP BY clause or be used in an aggregate function", e.Name),
+			fmt.Sprintf("Add %s to GROUP BY, or use e.g. MAX(%s).", e.Name, e.Name))
+	}
+	return nil
+}
+
+// Having returns a Filter of the rows of a that match expr, a HAVING
+// clause that Check accepts.
+func (a *Aggregate) Having(expr parser.Expression) (*Filter, error) {
+	if !isCondition(expr, a.schema) {
+		return nil, errors.New(errors.ErrTypeMismatch,
+			fmt.Sprintf("HAVING %s is not a condition", parser.FormatExpr(expr)),
+			"Compare the aggregate with a value, e.g. HAVING SUM(amount) > 100.")
+	}
+	return NewFilter(a, expr), nil
+}
+
+// grouped reports whether expr is one of the GROUP BY expressions. Columns
+// match however they are written, as name or table.name.
+func (a *Aggregate) grouped(expr parser.Expression) bool {
+	in := a.Child.Schema()
+	for _, g := range a.GroupBy {
+		id, ok := expr.(*parser.IdentifierExpr)
+		gid, gok := g.(*parser.IdentifierExpr)
+		switch {
+		case ok && gok:
+			if i := findColumn(in, id.Name); i != -1 && i == findColumn(in, gid.Name) {
+				return true
+			}
+		case !ok && !gok:
+			if parser.FormatExpr(expr) == parser.FormatExpr(g) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (a *Aggregate) Open() error {
+	a.groups, a.curr = nil, 0
+	if err := a.Child.Open(); err != nil {
+		return err
+	}
+	in := a.Child.Schema()
+	byKey := make(map[string]*group)
+	for {
+		t, err := a.Child.Next()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			break
+		}
+		var key []byte
+		values := make(indexing.Key, len(a.GroupBy))
+		if len(values) > 0 {
+			for i, expr := range a.GroupBy {
+				if values[i], err = evalExpr(t, expr, in); err != nil {
+					return err
+				}
+			}
+			if key, err = indexing.EncodeKey(a.groupTypes, values); err != nil {
+				return fmt.Errorf("GROUP BY: %w", err)
+			}
+		}
+		g, ok := byKey[string(key)]
+		if !ok {
+			g = a.newGroup(values)
+			byKey[string(key)] = g
+		}
+		for i, agg := range a.Aggs {
+			var v interface{}
+			if agg.Arg != nil {
+				if v, err = evalExpr(t, agg.Arg, in); err != nil {
+					return err
+				}
+			}
+			if err := g.accs[i].add(v); err != nil {
+				return fmt.Errorf("%s: %w", parser.FormatExpr(agg), err)
+			}
+		}
+	}
+	if len(a.groups) == 0 && len(a.GroupBy) == 0 {
+		a.newGroup(nil)
+	}
+	return nil
+}
+
+func (a *Aggregate) newGroup(values []interface{}) *group {
+	g := &group{values: values}
+	for i, agg := range a.Aggs {
+		g.accs = append(g.accs, &accumulator{agg: agg, typ: a.argTypes[i]})
+	}
+	a.groups = append(a.groups, g)
+	return g
+}
+
+func (a *Aggregate) Next() (*storage.Tuple, error) {
+	if a.curr >= len(a.groups) {
+		return nil, nil
+	}
+	g := a.groups[a.curr]
+	a.curr++
+	cells := make([]storage.Cell, len(a.schema))
+	for i, v := range g.values {
+		cells[i] = storage.Cell{Type: a.schema[i].Type, Value: v}
+	}
+	for i, acc := range g.accs {
+		v, err := acc.result()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", parser.FormatExpr(a.Aggs[i]), err)
+		}
+		col := len(g.values) + i
+		cells[col] = storage.Cell{Type: a.schema[col].Type, Value: v}
+	}
+	return &storage.Tuple{Cells: cells}, nil
+}
+
+func (a *Aggregate) Close() error {
+	a.groups = nil
+	return a.Child.Close()
+}
+
+func (a *Aggregate) Schema() []catalog.Column {
+	return a.schema
+}
+
+// accumulator computes an aggregate over the values of one group. NULLs
+// are skipped, except by COUNT(*), which has no argument.
+type accumulator struct {
+	agg *parser.AggregateExpr
+	typ catalog.ColumnType // of the argument
+
+	count   int64
+	sum     *big.Rat
+	scale   int    // of the DECIMAL value showing the most digits
+	best    []byte // the key of the MIN or MAX so far
+	bestVal interface{}
+	seen    map[string]bool // for DISTINCT
+}
+
+func (acc *accumulator) add(v interface{}) error {
+	if acc.agg.Arg == nil {
+		acc.count++
+		return nil
+	}
+	if v == nil {
+		return nil
+	}
+	var key []byte
+	if acc.agg.Distinct || acc.agg.Name == "MIN" || acc.agg.Name == "MAX" {
+		var err error
+		if key, err = indexing.EncodeKey([]catalog.ColumnType{acc.typ}, indexing.Key{v}); err != nil {
+			return err
+		}
+	}
+	if acc.agg.Distinct {
+		if acc.seen == nil {
+			acc.seen = make(map[string]bool)
+		}
+		if acc.seen[string(key)] {
+			return nil
+		}
+		acc.seen[string(key)] = true
+	}
+	acc.count++
+
+	switch acc.agg.Name {
+	case "SUM", "AVG":
+		r, err := toRat(v)
+		if err != nil {
+			return err
+		}
+		if acc.sum == nil {
+			acc.sum = new(big.Rat)
+		}
+		acc.sum.Add(acc.sum, r)
+		if s, ok := v.(string); ok {
+			acc.scale = max(acc.scale, decimalScale(s, r))
+		}
+	case "MIN", "MAX":
+		c := strings.Compare(string(key), string(acc.best))
+		if acc.best == nil || (acc.agg.Name == "MIN" && c < 0) || (acc.agg.Name == "MAX" && c > 0) {
+			acc.best, acc.bestVal = key, v
+		}
+	}
+	return nil
+}
+
+func (acc *accumulator) result() (interface{}, error) {
+	if acc.agg.Name == "COUNT" {
+		return acc.count, nil
+	}
+	if acc.count == 0 {
+		return nil, nil
+	}
+	switch acc.agg.Name {
+	case "SUM":
+		if acc.typ == catalog.TypeInt {
+			if !acc.sum.Num().IsInt64() {
+				return nil, fmt.Errorf("sum %s is out of range for INT", acc.sum.Num())
+			}
+			return acc.sum.Num().Int64(), nil
+		}
+		return acc.sum.FloatString(acc.scale), nil
+	case "AVG":
+		avg := new(big.Rat).Quo(acc.sum, new(big.Rat).SetInt64(acc.count))
+		scale := acc.scale
+		if s, ok := exactScale(avg, scale+avgDigits); ok {
+			scale = max(scale, s)
+		} else {
+			scale += avgDigits
+		}
+		return avg.FloatString(scale), nil
+	}
+	return acc.bestVal, nil
+}
+
+// decimalScale returns the number of digits after the point that the
+// DECIMAL text s of the value r shows: as many as it is written with, so
+// that 10.50 shows two, or as many as r needs if s has an exponent.
+func decimalScale(s string, r *big.Rat) int {
+	if strings.ContainsAny(s, "eE") {
+		scale, _ := exactScale(r, indexing.MaxKeySize)
+		return scale
+	}
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+// exactScale returns the number of digits after the point that r needs, if
+// it is at most limit.
+func exactScale(r *big.Rat, limit int) (int, bool) {
+	x := new(big.Rat).Set(r)
+	ten := big.NewRat(10, 1)
+	for scale := 0; scale <= limit; scale++ {
+		if x.IsInt() {
+			return scale, true
+		}
+		x.Mul(x, ten)
+	}
+	return 0, false
+}
@@ -0,0 +1,133 @@
+package execution
+
+import (
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/errors"
+	"minibank/internal/parser"
+	"minibank/internal/storage"
+	"strings"
+)
+
+// Check is a CHECK constraint of the table an operator writes.
+type Check struct {
+	Name string
+	Expr parser.Expression
+}
+
+// NewChecks parses the CHECK constraints of table. It fails if one uses a
+// column the table lacks or does not evaluate to a BOOL.
+func NewChecks(table *catalog.Table) ([]Check, error) {
+	schema := make([]catalog.Column, len(table.Columns))
+	for i, col := range table.Columns {
+		col.TableName = table.Name
+		schema[i] = col
+	}
+	checks := make([]Check, len(table.Checks))
+	for i, def := range table.Checks {
+		expr, err := parser.ParseExpr(def.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("check constraint %s: bad expression %s: %w", def.Name, def.Expr, err)
+		}
+		if err := checkExpr(expr, schema); err != nil {
+			return nil, fmt.Errorf("check constraint %s on table %s: %w", def.Name, table.Name, err)
+		}
+		if !isCondition(expr, schema) {
+			return nil, fmt.Errorf("check constraint %s: %s is not a condition", def.Name, def.Expr)
+		}
+		checks[i] = Check{Name: def.Name, Expr: expr}
+	}
+	return checks, nil
+}
+
+// DefaultValue returns the value an INSERT gives col when it lists no value
+// for it: its DEFAULT, cast to the column's type, or NULL. A DEFAULT may not
+// use columns.
+func DefaultValue(col catalog.Column) (interface{}, error) {
+	if col.Default == "" {
+		return nil, nil
+	}
+	expr, err := parser.ParseExpr(col.Default)
+	if err != nil {
+		return nil, fmt.Errorf("DEFAULT of column %s: bad expression %s: %w", col.Name, col.Default, err)
+	}
+	v, err := evalExpr(&storage.Tuple{}, expr, nil)
+	if err != nil {
+		return nil, fmt.Errorf("DEFAULT of column %s: %w", col.Name, err)
+	}
+	v, err = CastValue(v, col.Type)
+	if err != nil {
+		return nil, fmt.Errorf("DEFAULT of column %s: %w", col.Name, err)
+	}
+	return v, nil
+}
+
+// checkRow rejects a row with a NULL in a primary key or NOT NULL column, or
+// that makes a CHECK constraint false. As in SQL, a CHECK that evaluates to
+// NULL holds.
+func checkRow(schema []catalog.Column, checks []Check, cells []storage.Cell) error {
+	for i, col := range schema {
+		if cells[i].Value != nil {
+			continue
+		}
+		if col.IsPrimary {
+			return errors.New(errors.ErrConstraintViolation,
+				fmt.Sprintf("null value in primary key column '%s'", col.Name),
+				"Primary key columns must have a value.")
+		}
+		if col.NotNull {
+			return errors.New(errors.ErrConstraintViolation,
+				fmt.Sprintf("not-null constraint violation: column '%s' (value NULL, constraint %s_%s_not_null)", col.Name, col.TableName, col.Name),
+				"Give the column a value, or a DEFAULT.")
+		}
+	}
+
+	tuple := &storage.Tuple{Cells: cells}
+	for _, check := range checks {
+		v, err := evalExpr(tuple, check.Expr, schema)
+		if err != nil {
+			return fmt.Errorf("check constraint %s: %w", check.Name, err)
+		}
+		if ok, known := v.(bool); !known || ok {
+			continue
+		}
+		return errors.New(errors.ErrConstraintViolation,
+			fmt.Sprintf("check constraint violation: %s (%s, constraint %s)", parser.FormatExpr(check.Expr), rowValues(check.Expr, schema, cells), check.Name),
+			"Change the values so that the condition holds.")
+	}
+	return nil
+}
+
+// rowValues lists the values a row has in the columns expr uses, e.g.
+// "balance = -5".
+func rowValues(expr parser.Expression, schema []catalog.Column, cells []storage.Cell) string {
+	var parts []string
+	seen := make(map[int]bool)
+	var walk func(parser.Expression)
+	walk = func(expr parser.Expression) {
+		switch e := expr.(type) {
+		case *parser.BinaryExpr:
+			walk(e.Left)
+			walk(e.Right)
+		case *parser.IsNullExpr:
+			walk(e.Expr)
+		case *parser.FuncCallExpr:
+			for _, arg := range e.Args {
+				walk(arg)
+			}
+		case *parser.IdentifierExpr:
+			if i := findColumn(schema, e.Name); i != -1 && !seen[i] {
+				seen[i] = true
+				v := "NULL"
+				if val := cells[i].Value; val != nil && schema[i].Type == catalog.TypeString {
+					v = fmt.Sprintf("'%v'", val)
+				} else if val != nil {
+					v = fmt.Sprint(val)
+				}
+				parts = append(parts, fmt.Sprintf("%s = %s", schema[i].Name, v))
+			}
+		}
+	}
+	walk(expr)
+	return strings.Join(parts, ", ")
+}
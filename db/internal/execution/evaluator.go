@@ -10,11 +10,17 @@ import (
 	"strings"
 )
 
+// Evaluate reports whether a predicate holds for t. Expressions use SQL
+// three-valued logic, with nil standing for NULL (unknown); a predicate that
+// evaluates to unknown does not hold.
 func Evaluate(t *storage.Tuple, expr parser.Expression, schema []catalog.Column) (bool, error) {
 	val, err := evalExpr(t, expr, schema)
 	if err != nil {
 		return false, err
 	}
+	if val == nil {
+		return false, nil
+	}
 	b, ok := val.(bool)
 	if !ok {
 		return false, errors.New(errors.ErrTypeMismatch,
@@ -35,27 +41,130 @@ func evalExpr(t *storage.Tuple, expr parser.Expression, schema []catalog.Column)
 		if err != nil {
 			return nil, err
 		}
+		if e.Op == parser.OpAnd || e.Op == parser.OpOr {
+			return logical(left, right, e.Op)
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
 		return compare(left, right, e.Op)
+	case *parser.IsNullExpr:
+		val, err := evalExpr(t, e.Expr, schema)
+		if err != nil {
+			return nil, err
+		}
+		return (val == nil) != e.Not, nil
+	case *parser.FuncCallExpr:
+		if i := findExpr(schema, e); i != -1 {
+			return t.Cells[i].Value, nil
+		}
+		fn, err := lookupFunction(e)
+		if err != nil {
+			return nil, err
+		}
+		args := make([]interface{}, len(e.Args))
+		for i, arg := range e.Args {
+			if args[i], err = evalExpr(t, arg, schema); err != nil {
+				return nil, err
+			}
+		}
+		return fn.call(e.Name, args)
+	case *parser.AggregateExpr:
+		i := findExpr(schema, e)
+		if i == -1 {
+			return nil, aggregateError(e)
+		}
+		return t.Cells[i].Value, nil
 	case *parser.LiteralExpr:
 		return e.Value, nil
 	case *parser.IdentifierExpr:
-		for i, col := range schema {
-			if strings.Contains(e.Name, ".") {
-				fqn := col.TableName + "." + col.Name
-				if strings.EqualFold(fqn, e.Name) {
-					return t.Cells[i].Value, nil
-				}
-			} else {
-				if strings.EqualFold(col.Name, e.Name) {
-					return t.Cells[i].Value, nil
-				}
-			}
+		i := findColumn(schema, e.Name)
+		if i == -1 {
+			return nil, fmt.Errorf("column %s not found", e.Name)
 		}
-		return nil, fmt.Errorf("column %s not found", e.Name)
+		return t.Cells[i].Value, nil
 	}
 	return nil, fmt.Errorf("unknown expression type")
 }
 
+// findColumn returns the position of the column named name, which may be
+// qualified with its table name, or -1.
+func findColumn(schema []catalog.Column, name string) int {
+	for i, col := range schema {
+		if strings.Contains(name, ".") {
+			if strings.EqualFold(col.TableName+"."+col.Name, name) {
+				return i
+			}
+		} else if strings.EqualFold(col.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// findExpr returns the position of the column that holds the values of expr,
+// which an Aggregate names after the expression's SQL text, or -1. Only
+// such columns have a parenthesis in their name.
+func findExpr(schema []catalog.Column, expr parser.Expression) int {
+	text := ""
+	for i, col := range schema {
+		if col.TableName != "" || !strings.Contains(col.Name, "(") {
+			continue
+		}
+		if text == "" {
+			text = parser.FormatExpr(expr)
+		}
+		if col.Name == text {
+			return i
+		}
+	}
+	return -1
+}
+
+// ColumnOf returns the position of the column that holds the values of expr:
+// the column a name refers to, matched as findColumn does, or the column an
+// Aggregate computed for another expression. It returns -1 if there is none.
+func ColumnOf(schema []catalog.Column, expr parser.Expression) int {
+	if id, ok := expr.(*parser.IdentifierExpr); ok {
+		return findColumn(schema, id.Name)
+	}
+	return findExpr(schema, expr)
+}
+
+func aggregateError(agg *parser.AggregateExpr) error {
+	return errors.New(errors.ErrSyntax,
+		fmt.Sprintf("aggregate %s is not allowed here", parser.FormatExpr(agg)),
+		"Aggregates may be used in the select list, HAVING and ORDER BY.")
+}
+
+// logical applies AND or OR. A NULL operand only decides the result when the
+// other operand does not: FALSE AND NULL is FALSE, TRUE OR NULL is TRUE, and
+// the remaining combinations with NULL are NULL.
+func logical(left, right interface{}, op parser.Operator) (interface{}, error) {
+	for _, v := range []interface{}{left, right} {
+		if _, ok := v.(bool); v != nil && !ok {
+			return nil, errors.New(errors.ErrTypeMismatch,
+				fmt.Sprintf("operands of %s must be BOOL, got %T", op, v),
+				"Combine comparisons, e.g. a = 1 AND b > 2.")
+		}
+	}
+	l, lKnown := left.(bool)
+	r, rKnown := right.(bool)
+	if op == parser.OpAnd {
+		if (lKnown && !l) || (rKnown && !r) {
+			return false, nil
+		}
+	} else {
+		if (lKnown && l) || (rKnown && r) {
+			return true, nil
+		}
+	}
+	if !lKnown || !rKnown {
+		return nil, nil
+	}
+	return op == parser.OpAnd, nil
+}
+
 func compare(left, right interface{}, op parser.Operator) (bool, error) {
 	if isNumeric(left) && isNumeric(right) {
 		lRat, err := toRat(left)
@@ -94,6 +203,8 @@ func compare(left, right interface{}, op parser.Operator) (bool, error) {
 				return l <= r, nil
 			case parser.OpGte:
 				return l >= r, nil
+			case parser.OpLike:
+				return like([]rune(l), []rune(r)), nil
 			}
 			return false, fmt.Errorf("invalid operator for string comparison: %s", op)
 		}
@@ -118,6 +229,34 @@ func compare(left, right interface{}, op parser.Operator) (bool, error) {
 	return false, fmt.Errorf("type mismatch or unsupported comparison: %T %s %T", left, op, right)
 }
 
+// like reports whether s matches a LIKE pattern, in which % stands for any
+// run of characters and _ for any one character.
+func like(s, pattern []rune) bool {
+	// On a mismatch, retry from the last %, letting it take one more
+	// character of s.
+	star, from := -1, 0
+	i, j := 0, 0
+	for i < len(s) {
+		switch {
+		case j < len(pattern) && pattern[j] == '%':
+			star, from = j, i
+			j++
+		case j < len(pattern) && (pattern[j] == '_' || pattern[j] == s[i]):
+			i++
+			j++
+		case star != -1:
+			from++
+			i, j = from, star+1
+		default:
+			return false
+		}
+	}
+	for j < len(pattern) && pattern[j] == '%' {
+		j++
+	}
+	return j == len(pattern)
+}
+
 func isNumeric(v interface{}) bool {
 	switch v.(type) {
 	case int, int64, float64, parser.RawNumber:
@@ -0,0 +1,99 @@
+package execution
+
+import (
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/errors"
+	"minibank/internal/indexing"
+	"minibank/internal/parser"
+	"strings"
+)
+
+// function is a built-in function of STRING arguments. It returns NULL when
+// an argument is NULL, without being applied.
+type function struct {
+	args   int
+	result catalog.ColumnType
+	apply  func(args []string) interface{}
+}
+
+var functions = map[string]function{
+	"LOWER":  {1, catalog.TypeString, func(a []string) interface{} { return strings.ToLower(a[0]) }},
+	"UPPER":  {1, catalog.TypeString, func(a []string) interface{} { return strings.ToUpper(a[0]) }},
+	"LENGTH": {1, catalog.TypeInt, func(a []string) interface{} { return int64(len([]rune(a[0]))) }},
+	// MATCH(value, query) is a fuzzy search: it holds when value contains
+	// enough of the trigrams of query (see indexing.Similarity).
+	"MATCH": {2, catalog.TypeBool, func(a []string) interface{} {
+		return indexing.Similarity(a[0], a[1]) >= indexing.MatchThreshold
+	}},
+}
+
+func lookupFunction(call *parser.FuncCallExpr) (function, error) {
+	fn, ok := functions[call.Name]
+	if !ok {
+		return function{}, fmt.Errorf("unknown function %s", call.Name)
+	}
+	if len(call.Args) != fn.args {
+		return function{}, fmt.Errorf("%s takes %d argument(s), got %d", call.Name, fn.args, len(call.Args))
+	}
+	return fn, nil
+}
+
+// call applies fn to the values of its arguments.
+func (fn function) call(name string, values []interface{}) (interface{}, error) {
+	args := make([]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			return nil, nil
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, argTypeError(name, fmt.Sprintf("%T", v))
+		}
+		args[i] = s
+	}
+	return fn.apply(args), nil
+}
+
+func argTypeError(name, got string) error {
+	return errors.New(errors.ErrTypeMismatch,
+		fmt.Sprintf("%s expects a STRING, got %s", name, got),
+		"Call it on a STRING column, e.g. LOWER(email).")
+}
+
+// ExprType returns the type of the values expr takes on rows of schema. It
+// only handles columns, function calls and strings, which is what index keys
+// and their arguments may be, and the columns an Aggregate computes.
+func ExprType(expr parser.Expression, schema []catalog.Column) (catalog.ColumnType, error) {
+	if i := findExpr(schema, expr); i != -1 {
+		return schema[i].Type, nil
+	}
+	switch e := expr.(type) {
+	case *parser.IdentifierExpr:
+		i := findColumn(schema, e.Name)
+		if i == -1 {
+			return "", fmt.Errorf("column %s not found", e.Name)
+		}
+		return schema[i].Type, nil
+	case *parser.FuncCallExpr:
+		fn, err := lookupFunction(e)
+		if err != nil {
+			return "", err
+		}
+		for _, arg := range e.Args {
+			typ, err := ExprType(arg, schema)
+			if err != nil {
+				return "", err
+			}
+			if typ != catalog.TypeString {
+				return "", argTypeError(e.Name, string(typ))
+			}
+		}
+		return fn.result, nil
+	case *parser.LiteralExpr:
+		if _, ok := e.Value.(string); ok {
+			return catalog.TypeString, nil
+		}
+	}
+	return "", fmt.Errorf("cannot index %s", parser.FormatExpr(expr))
+}
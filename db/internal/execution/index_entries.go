@@ -0,0 +1,300 @@
+package execution
+
+import (
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/errors"
+	"minibank/internal/indexing"
+	"minibank/internal/parser"
+	"minibank/internal/storage"
+	"strings"
+)
+
+// TableIndex is an open index of the table an operator reads or writes.
+type TableIndex struct {
+	Name    string
+	Columns []int               // positions of the indexed columns in the table's schema; -1 for an expression
+	Exprs   []parser.Expression // the key columns or expressions, parallel to Columns
+	Include []int               // positions of the columns stored with the key
+	Where   parser.Expression   // the predicate of a partial index; nil if every row is indexed
+	Unique  bool
+	Primary bool // the unique index of the primary key
+	Index   indexing.Index
+
+	schema   []catalog.Column
+	keyTypes []catalog.ColumnType
+}
+
+// NewTableIndex resolves the columns, expressions and predicate of def on
+// table. It fails if def names a column the table lacks or calls a function
+// on the wrong type. idx may be nil to only validate def or get its key
+// types.
+func NewTableIndex(table *catalog.Table, def catalog.IndexDef, idx indexing.Index) (TableIndex, error) {
+	schema := make([]catalog.Column, len(table.Columns))
+	for i, col := range table.Columns {
+		col.TableName = table.Name
+		schema[i] = col
+	}
+	ti := TableIndex{
+		Name:    def.Name,
+		Columns: make([]int, len(def.Columns)),
+		Exprs:   make([]parser.Expression, len(def.Columns)),
+		Include: make([]int, len(def.Include)),
+		Unique:  def.IsUnique,
+		Primary: def.IsUnique && def.Where == "" && strings.Join(def.Columns, ",") == strings.Join(table.PrimaryKeyColumns(), ","),
+		Index:   idx,
+		schema:  schema,
+	}
+	for i, text := range def.Columns {
+		expr, err := parser.ParseExpr(text)
+		if err != nil {
+			return TableIndex{}, fmt.Errorf("index %s: bad key %s: %w", def.Name, text, err)
+		}
+		ti.Columns[i] = -1
+		if ident, ok := expr.(*parser.IdentifierExpr); ok {
+			if ti.Columns[i] = findColumn(schema, ident.Name); ti.Columns[i] == -1 {
+				return TableIndex{}, fmt.Errorf("column %s not found in table %s", ident.Name, table.Name)
+			}
+		}
+		typ, err := ExprType(expr, schema)
+		if err != nil {
+			return TableIndex{}, fmt.Errorf("index %s on table %s: %w", def.Name, table.Name, err)
+		}
+		ti.Exprs[i] = expr
+		ti.keyTypes = append(ti.keyTypes, typ)
+	}
+	for i, name := range def.Include {
+		ti.Include[i] = findColumn(schema, name)
+		if ti.Include[i] == -1 {
+			return TableIndex{}, fmt.Errorf("column %s not found in table %s", name, table.Name)
+		}
+	}
+	if def.Where != "" {
+		where, err := parser.ParseExpr(def.Where)
+		if err != nil {
+			return TableIndex{}, fmt.Errorf("index %s: bad predicate %s: %w", def.Name, def.Where, err)
+		}
+		if err := checkExpr(where, schema); err != nil {
+			return TableIndex{}, fmt.Errorf("index %s on table %s: %w", def.Name, table.Name, err)
+		}
+		if !isCondition(where, schema) {
+			return TableIndex{}, fmt.Errorf("index %s: predicate %s is not a condition", def.Name, def.Where)
+		}
+		ti.Where = where
+	}
+	return ti, nil
+}
+
+// KeyTypes returns the types of the index's key columns and expressions.
+func (ti TableIndex) KeyTypes() []catalog.ColumnType {
+	return ti.keyTypes
+}
+
+// EntryTypes returns the types of the values of an entry: the key types,
+// then those of the included columns.
+func (ti TableIndex) EntryTypes() []catalog.ColumnType {
+	types := append([]catalog.ColumnType(nil), ti.keyTypes...)
+	for _, col := range ti.Include {
+		types = append(types, ti.schema[col].Type)
+	}
+	return types
+}
+
+// Holds reports whether a tuple of the table belongs in a partial index.
+func (ti TableIndex) Holds(cells []storage.Cell) (bool, error) {
+	if ti.Where == nil {
+		return true, nil
+	}
+	ok, err := Evaluate(&storage.Tuple{Cells: cells}, ti.Where, ti.schema)
+	if err != nil {
+		return false, fmt.Errorf("index %s: %w", ti.Name, err)
+	}
+	return ok, nil
+}
+
+// Key returns the index key of a tuple of the table.
+func (ti TableIndex) Key(cells []storage.Cell) (indexing.Key, error) {
+	key := make(indexing.Key, len(ti.Columns))
+	for i, col := range ti.Columns {
+		if col != -1 {
+			key[i] = cells[col].Value
+			continue
+		}
+		v, err := evalExpr(&storage.Tuple{Cells: cells}, ti.Exprs[i], ti.schema)
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", ti.Name, err)
+		}
+		key[i] = v
+	}
+	return key, nil
+}
+
+// Entry returns the values a tuple of the table stores in the index: its
+// key, then the included columns.
+func (ti TableIndex) Entry(cells []storage.Cell) (indexing.Key, error) {
+	values, err := ti.Key(cells)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range ti.Include {
+		values = append(values, cells[col].Value)
+	}
+	return values, nil
+}
+
+// isCondition reports whether expr evaluates to a BOOL.
+func isCondition(expr parser.Expression, schema []catalog.Column) bool {
+	switch e := expr.(type) {
+	case *parser.BinaryExpr, *parser.IsNullExpr:
+		return true
+	case *parser.FuncCallExpr:
+		typ, err := ExprType(e, schema)
+		return err == nil && typ == catalog.TypeBool
+	case *parser.IdentifierExpr:
+		return schema[findColumn(schema, e.Name)].Type == catalog.TypeBool
+	}
+	return false
+}
+
+// checkExpr checks that the columns and functions expr uses exist.
+func checkExpr(expr parser.Expression, schema []catalog.Column) error {
+	switch e := expr.(type) {
+	case *parser.BinaryExpr:
+		if err := checkExpr(e.Left, schema); err != nil {
+			return err
+		}
+		return checkExpr(e.Right, schema)
+	case *parser.IsNullExpr:
+		return checkExpr(e.Expr, schema)
+	case *parser.FuncCallExpr:
+		if findExpr(schema, e) != -1 {
+			return nil
+		}
+		if _, err := lookupFunction(e); err != nil {
+			return err
+		}
+		for _, arg := range e.Args {
+			if err := checkExpr(arg, schema); err != nil {
+				return err
+			}
+		}
+	case *parser.AggregateExpr:
+		if findExpr(schema, e) == -1 {
+			return aggregateError(e)
+		}
+	case *parser.IdentifierExpr:
+		if findColumn(schema, e.Name) == -1 {
+			return fmt.Errorf("column %s not found", e.Name)
+		}
+	}
+	return nil
+}
+
+// DML operators keep every index of their table in step with the heap.
+
+// insertIndexEntries adds the tuple stored at rid to indexes. Partial
+// indexes only get it if it matches their predicate.
+func insertIndexEntries(indexes []TableIndex, cells []storage.Cell, rid storage.RID) error {
+	return eachEntry(indexes, cells, func(ti TableIndex, entry indexing.Key) error {
+		return ti.Index.Insert(entry, rid)
+	})
+}
+
+// deleteIndexEntries removes the tuple stored at rid from indexes.
+func deleteIndexEntries(indexes []TableIndex, cells []storage.Cell, rid storage.RID) error {
+	return eachEntry(indexes, cells, func(ti TableIndex, entry indexing.Key) error {
+		return ti.Index.Delete(entry, rid)
+	})
+}
+
+// eachEntry calls fn with the entry of a tuple in each index that holds it.
+func eachEntry(indexes []TableIndex, cells []storage.Cell, fn func(TableIndex, indexing.Key) error) error {
+	for _, ti := range indexes {
+		ok, err := ti.Holds(cells)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		entry, err := ti.Entry(cells)
+		if err != nil {
+			return err
+		}
+		if err := fn(ti, entry); err != nil {
+			return fmt.Errorf("index %s: %w", ti.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkConstraints rejects a tuple with a key that one of the unique indexes
+// already holds. PRIMARY KEY and UNIQUE columns always have a unique index,
+// so no heap scan is needed. An UPDATE removes the row's old entries first,
+// so the row never conflicts with itself.
+func checkConstraints(indexes []TableIndex, cells []storage.Cell) error {
+	for _, ti := range indexes {
+		if !ti.Unique {
+			continue
+		}
+		// A unique partial index only constrains the rows it holds.
+		ok, err := ti.Holds(cells)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		key, err := ti.Key(cells)
+		if err != nil {
+			return err
+		}
+		// NULLs never conflict with each other, so a key with a NULL needs
+		// no uniqueness check.
+		if key.HasNull() {
+			continue
+		}
+		rids, err := ti.Index.Get(key)
+		if err != nil {
+			return fmt.Errorf("index %s: %w", ti.Name, err)
+		}
+		if len(rids) == 0 {
+			continue
+		}
+
+		names := make([]string, len(ti.Exprs))
+		for i, expr := range ti.Exprs {
+			names[i] = parser.FormatExpr(expr)
+		}
+		cols, vals := columnList(names), keyString(key)
+		if ti.Primary {
+			return errors.New(errors.ErrConstraintViolation,
+				fmt.Sprintf("duplicate primary key constraint violation: %s (key %s)", cols, vals),
+				"Each row needs a different primary key.")
+		}
+		return errors.New(errors.ErrConstraintViolation,
+			fmt.Sprintf("unique constraint violation: %s (key %s, index %s)", cols, vals, ti.Name),
+			"Each row needs a different value in these columns, or a NULL.")
+	}
+	return nil
+}
+
+// columnList names the columns of a constraint in errors: column 'a' or
+// columns ('a', 'b').
+func columnList(names []string) string {
+	if len(names) == 1 {
+		return fmt.Sprintf("column '%s'", names[0])
+	}
+	return fmt.Sprintf("columns ('%s')", strings.Join(names, "', '"))
+}
+
+func keyString(key indexing.Key) string {
+	vals := make([]string, len(key))
+	for i, v := range key {
+		vals[i] = fmt.Sprint(v)
+	}
+	if len(vals) == 1 {
+		return vals[0]
+	}
+	return "(" + strings.Join(vals, ", ") + ")"
+}
@@ -0,0 +1,90 @@
+package execution
+
+import (
+	"minibank/internal/catalog"
+	"minibank/internal/indexing"
+	"minibank/internal/storage"
+)
+
+// IndexOnlyScan answers a query from the entries of an index without reading
+// the heap. Its tuples hold the index's key columns followed by its included
+// columns, as described by schema. It reads the entries for Key, or when Key
+// is nil those between Lo and Hi, which needs a RangeIndex. Without a Key or
+// bounds it reads every entry. A range is read through a cursor as the scan
+// is pulled, so a Limit above it stops the index read early.
+type IndexOnlyScan struct {
+	Index  indexing.Index
+	Key    indexing.Key
+	Lo, Hi *indexing.Bound
+	schema []catalog.Column
+
+	// Runtime
+	entries []indexing.Entry
+	curr    int
+	cursor  indexing.Cursor // for a range; entries is then unused
+}
+
+func NewIndexOnlyScan(idx indexing.Index, key indexing.Key, lo, hi *indexing.Bound, schema []catalog.Column) *IndexOnlyScan {
+	return &IndexOnlyScan{
+		Index:  idx,
+		Key:    key,
+		Lo:     lo,
+		Hi:     hi,
+		schema: schema,
+	}
+}
+
+func (scan *IndexOnlyScan) Open() error {
+	var entries []indexing.Entry
+	var err error
+	scan.cursor = nil
+	switch {
+	case scan.Key != nil:
+		entries, err = scan.Index.Lookup(scan.Key)
+	case scan.Lo == nil && scan.Hi == nil:
+		err = scan.Index.Scan(func(values indexing.Key, rid storage.RID) error {
+			entries = append(entries, indexing.Entry{Values: values, RID: rid})
+			return nil
+		})
+	default:
+		scan.cursor, err = scan.Index.(indexing.RangeIndex).Cursor(scan.Lo, scan.Hi)
+	}
+	if err != nil {
+		return err
+	}
+	scan.entries = entries
+	scan.curr = 0
+	return nil
+}
+
+func (scan *IndexOnlyScan) Next() (*storage.Tuple, error) {
+	var e indexing.Entry
+	if scan.cursor != nil {
+		var ok bool
+		var err error
+		if e, ok, err = scan.cursor.Next(); err != nil || !ok {
+			return nil, err
+		}
+	} else {
+		if scan.curr >= len(scan.entries) {
+			return nil, nil
+		}
+		e = scan.entries[scan.curr]
+		scan.curr++
+	}
+
+	cells := make([]storage.Cell, len(scan.schema))
+	for i, col := range scan.schema {
+		cells[i] = storage.Cell{Type: col.Type, Value: e.Values[i]}
+	}
+	return &storage.Tuple{Cells: cells, RID: e.RID}, nil
+}
+
+func (scan *IndexOnlyScan) Close() error {
+	scan.entries, scan.cursor = nil, nil
+	return nil
+}
+
+func (scan *IndexOnlyScan) Schema() []catalog.Column {
+	return scan.schema
+}
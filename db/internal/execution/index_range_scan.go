@@ -0,0 +1,62 @@
+package execution
+
+import (
+	"minibank/internal/catalog"
+	"minibank/internal/indexing"
+	"minibank/internal/storage"
+)
+
+// IndexRangeScan returns the tuples whose key lies between Lo and Hi, in key
+// order. A nil bound leaves that end of the range open. It walks the index
+// with a cursor as it is pulled, so under a Limit it stops reading the index
+// and the heap once the page is full.
+type IndexRangeScan struct {
+	Index    indexing.RangeIndex
+	HeapFile *storage.HeapFile
+	Lo, Hi   *indexing.Bound
+	schema   []catalog.Column
+
+	// Runtime
+	cursor indexing.Cursor
+}
+
+func NewIndexRangeScan(idx indexing.RangeIndex, hf *storage.HeapFile, lo, hi *indexing.Bound, schema []catalog.Column) *IndexRangeScan {
+	return &IndexRangeScan{
+		Index:    idx,
+		HeapFile: hf,
+		Lo:       lo,
+		Hi:       hi,
+		schema:   schema,
+	}
+}
+
+func (scan *IndexRangeScan) Open() error {
+	cursor, err := scan.Index.Cursor(scan.Lo, scan.Hi)
+	if err != nil {
+		return err
+	}
+	scan.cursor = cursor
+	return nil
+}
+
+func (scan *IndexRangeScan) Next() (*storage.Tuple, error) {
+	for {
+		e, ok, err := scan.cursor.Next()
+		if err != nil || !ok {
+			return nil, err
+		}
+		tuple, err := fetchRID(scan.HeapFile, scan.schema, e.RID)
+		if err != nil || tuple != nil {
+			return tuple, err
+		}
+	}
+}
+
+func (scan *IndexRangeScan) Close() error {
+	scan.cursor = nil
+	return nil
+}
+
+func (scan *IndexRangeScan) Schema() []catalog.Column {
+	return scan.schema
+}
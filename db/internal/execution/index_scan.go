@@ -6,10 +6,12 @@ import (
 	"minibank/internal/storage"
 )
 
+// IndexScan returns the tuples whose key is Key, or every tuple the index
+// holds when Key is nil, which reads a partial index whole.
 type IndexScan struct {
-	Index    *indexing.HashIndex
+	Index    indexing.Index
 	HeapFile *storage.HeapFile
-	Key      interface{}
+	Key      indexing.Key
 	schema   []catalog.Column
 
 	// Runtime
@@ -17,7 +19,7 @@ type IndexScan struct {
 	curr int
 }
 
-func NewIndexScan(idx *indexing.HashIndex, hf *storage.HeapFile, key interface{}, schema []catalog.Column) *IndexScan {
+func NewIndexScan(idx indexing.Index, hf *storage.HeapFile, key indexing.Key, schema []catalog.Column) *IndexScan {
 	return &IndexScan{
 		Index:    idx,
 		HeapFile: hf,
@@ -27,39 +29,61 @@ func NewIndexScan(idx *indexing.HashIndex, hf *storage.HeapFile, key interface{}
 }
 
 func (scan *IndexScan) Open() error {
-	scan.rids = scan.Index.Get(scan.Key)
+	var rids []storage.RID
+	var err error
+	if scan.Key != nil {
+		rids, err = scan.Index.Get(scan.Key)
+	} else {
+		err = scan.Index.Scan(func(_ indexing.Key, rid storage.RID) error {
+			rids = append(rids, rid)
+			return nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+	scan.rids = rids
 	scan.curr = 0
 	return nil
 }
 
 func (scan *IndexScan) Next() (*storage.Tuple, error) {
-	if scan.curr >= len(scan.rids) {
-		return nil, nil
-	}
+	return fetchRIDs(scan.HeapFile, scan.schema, scan.rids, &scan.curr)
+}
 
-	rid := scan.rids[scan.curr]
-	scan.curr++
+func (scan *IndexScan) Close() error {
+	return nil
+}
 
-	bytes, err := scan.HeapFile.ReadTuple(rid.PageID, rid.SlotID)
-	if err != nil {
-		return nil, err
+func (scan *IndexScan) Schema() []catalog.Column {
+	return scan.schema
+}
+
+// fetchRIDs reads the tuple at rids[*curr], skipping RIDs whose tuple is gone.
+func fetchRIDs(hf *storage.HeapFile, schema []catalog.Column, rids []storage.RID, curr *int) (*storage.Tuple, error) {
+	for *curr < len(rids) {
+		rid := rids[*curr]
+		*curr++
+
+		tuple, err := fetchRID(hf, schema, rid)
+		if err != nil || tuple != nil {
+			return tuple, err
+		}
 	}
-	if bytes == nil {
-		return scan.Next()
+	return nil, nil
+}
+
+// fetchRID reads the tuple at rid, or returns nil if it is gone.
+func fetchRID(hf *storage.HeapFile, schema []catalog.Column, rid storage.RID) (*storage.Tuple, error) {
+	bytes, err := hf.ReadTuple(rid.PageID, rid.SlotID)
+	if err != nil || bytes == nil {
+		return nil, err
 	}
 
-	tuple, err := storage.DeserializeTuple(bytes, scan.schema)
+	tuple, err := storage.DeserializeTuple(bytes, schema)
 	if err != nil {
 		return nil, err
 	}
 	tuple.RID = rid
 	return tuple, nil
 }
-
-func (scan *IndexScan) Close() error {
-	return nil
-}
-
-func (scan *IndexScan) Schema() []catalog.Column {
-	return scan.schema
-}
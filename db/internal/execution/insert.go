@@ -13,22 +13,20 @@ import (
 	"sync"
 )
 
+// Insert adds rows to its Table, with the same checks as every write of the
+// table.
 type Insert struct {
-	HeapFile *storage.HeapFile
-	Values   [][]interface{}
-	schema   []catalog.Column
-	idx      int
-	Indices  map[string]*indexing.HashIndex
-	mu       sync.Mutex
+	Table  *Target
+	Values [][]interface{}
+	idx    int
+	mu     sync.Mutex
 }
 
-func NewInsert(hf *storage.HeapFile, values [][]interface{}, schema []catalog.Column, indices map[string]*indexing.HashIndex) *Insert {
+func NewInsert(table *Target, values [][]interface{}) *Insert {
 	return &Insert{
-		HeapFile: hf,
-		Values:   values,
-		schema:   schema,
-		idx:      0,
-		Indices:  indices,
+		Table:  table,
+		Values: values,
+		idx:    0,
 	}
 }
 
@@ -46,9 +44,9 @@ func (op *Insert) Next() (*storage.Tuple, error) {
 	op.idx++
 
 	// Construct tuple
-	cells := make([]storage.Cell, len(op.schema))
-	for i, col := range op.schema {
-		val, err := op.castValue(vals[i], col.Type)
+	cells := make([]storage.Cell, len(op.Table.Schema))
+	for i, col := range op.Table.Schema {
+		val, err := CastValue(vals[i], col.Type)
 		if err != nil {
 			return nil, fmt.Errorf("column %s: %w", col.Name, err)
 		}
@@ -57,38 +55,21 @@ func (op *Insert) Next() (*storage.Tuple, error) {
 			Value: val,
 		}
 	}
-	tuple := &storage.Tuple{Cells: cells}
 
 	op.mu.Lock()
 	defer op.mu.Unlock()
 
-	if err := op.checkConstraints(tuple); err != nil {
-		return nil, err
-	}
-
-	data, err := storage.SerializeTuple(tuple)
-	if err != nil {
-		return nil, err
-	}
-
-	pid, slotID, err := op.HeapFile.Insert(data)
-	if err != nil {
-		return nil, err
-	}
-
-	rid := storage.RID{PageID: pid, SlotID: slotID}
-
-	for i, col := range op.schema {
-		key := fmt.Sprintf("%s.%s", col.TableName, col.Name)
-		if idx, ok := op.Indices[key]; ok {
-			idx.Insert(tuple.Cells[i].Value, rid)
-		}
-	}
-
-	return tuple, nil
+	return op.Table.insert(cells)
 }
 
-func (op *Insert) castValue(val interface{}, targetType catalog.ColumnType) (interface{}, error) {
+// CastValue converts a literal from the parser to the Go type that values of
+// the column's type have: int64 for INT and TIMESTAMP, string for STRING and
+// DECIMAL, bool for BOOL. The planner casts the literals of WHERE clauses
+// the same way, so both give the same index keys.
+func CastValue(val interface{}, targetType catalog.ColumnType) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
 	if raw, ok := val.(parser.RawNumber); ok {
 		sRaw := string(raw)
 		switch targetType {
@@ -100,7 +81,7 @@ func (op *Insert) castValue(val interface{}, targetType catalog.ColumnType) (int
 			}
 			return strconv.ParseInt(sRaw, 10, 64)
 		case catalog.TypeDecimal:
-			return sRaw, nil
+			return castDecimal(sRaw)
 		case catalog.TypeString:
 			return sRaw, nil
 		default:
@@ -126,7 +107,7 @@ func (op *Insert) castValue(val interface{}, targetType catalog.ColumnType) (int
 		}
 	case catalog.TypeDecimal:
 		if v, ok := val.(string); ok {
-			return v, nil
+			return castDecimal(v)
 		}
 	case catalog.TypeTimestamp:
 		if v, ok := val.(int64); ok {
@@ -138,77 +119,15 @@ func (op *Insert) castValue(val interface{}, targetType catalog.ColumnType) (int
 		"Ensure the value type matches the column definition.")
 }
 
-func (op *Insert) checkConstraints(tuple *storage.Tuple) error {
-	type check struct {
-		colIdx int
-		isPK   bool
-		name   string
-		key    string
-	}
-	var checks []check
-	for i, col := range op.schema {
-		if col.IsPrimary || col.IsUnique {
-			key := fmt.Sprintf("%s.%s", col.TableName, col.Name)
-			checks = append(checks, check{colIdx: i, isPK: col.IsPrimary, name: col.Name, key: key})
-		}
-	}
-
-	if len(checks) == 0 {
-		return nil
+// castDecimal checks that s is a number with a finite decimal expansion. It
+// keeps the text as written; indexes compare its normalized form.
+func castDecimal(s string) (interface{}, error) {
+	if !indexing.ValidKey(catalog.TypeDecimal, s) {
+		return nil, errors.New(errors.ErrTypeMismatch,
+			fmt.Sprintf("invalid input syntax for type decimal: \"%s\"", s),
+			"DECIMAL values are numbers, e.g. 10.50.")
 	}
-
-	needsScan := false
-	for _, c := range checks {
-		if idx, ok := op.Indices[c.key]; ok {
-			val := tuple.Cells[c.colIdx].Value
-			existingRids := idx.Get(val)
-			if len(existingRids) > 0 {
-				if c.isPK {
-					return fmt.Errorf("duplicate primary key constraint violation: column '%s' (via index)", c.name)
-				}
-				return fmt.Errorf("unique constraint violation: column '%s' (via index)", c.name)
-			}
-		} else {
-			needsScan = true
-		}
-	}
-
-	if !needsScan {
-		return nil
-	}
-
-	iter := op.HeapFile.Iterator()
-	for {
-		data, _, err := iter.Next()
-		if err != nil {
-			return err
-		}
-		if data == nil {
-			break
-		}
-
-		existing, err := storage.DeserializeTuple(data, op.schema)
-		if err != nil {
-			return err
-		}
-
-		for _, c := range checks {
-			v1 := tuple.Cells[c.colIdx].Value
-			v2 := existing.Cells[c.colIdx].Value
-
-			if areEqual(v1, v2) {
-				if c.isPK {
-					return fmt.Errorf("duplicate primary key constraint violation: column '%s'", c.name)
-				}
-				return fmt.Errorf("unique constraint violation: column '%s'", c.name)
-			}
-		}
-	}
-	return nil
-}
-
-func areEqual(a, b interface{}) bool {
-	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+	return s, nil
 }
 
 func (op *Insert) Close() error {
@@ -216,5 +135,5 @@ func (op *Insert) Close() error {
 }
 
 func (op *Insert) Schema() []catalog.Column {
-	return op.schema
+	return op.Table.Schema
 }
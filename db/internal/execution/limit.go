@@ -0,0 +1,67 @@
+package execution
+
+import (
+	"minibank/internal/catalog"
+	"minibank/internal/storage"
+)
+
+// Limit returns the tuples of Child after the first Offset, and at most
+// Count of them. It stops pulling tuples from Child once it has returned
+// Count, so the operators below it only read as far as the result needs.
+// With a Count of 0 it does not even open Child.
+type Limit struct {
+	Child  Iterator
+	Count  int // -1 for no limit, with OFFSET alone
+	Offset int
+
+	// Runtime
+	opened   bool
+	skipped  bool
+	returned int
+}
+
+func NewLimit(child Iterator, count, offset int) *Limit {
+	return &Limit{Child: child, Count: count, Offset: offset}
+}
+
+func (l *Limit) Open() error {
+	l.skipped, l.returned = false, 0
+	l.opened = l.Count != 0
+	if !l.opened {
+		return nil
+	}
+	return l.Child.Open()
+}
+
+func (l *Limit) Next() (*storage.Tuple, error) {
+	if l.Count >= 0 && l.returned >= l.Count {
+		return nil, nil
+	}
+	if !l.skipped {
+		for i := 0; i < l.Offset; i++ {
+			t, err := l.Child.Next()
+			if err != nil || t == nil {
+				return t, err
+			}
+		}
+		l.skipped = true
+	}
+	t, err := l.Child.Next()
+	if err != nil || t == nil {
+		return t, err
+	}
+	l.returned++
+	return t, nil
+}
+
+func (l *Limit) Close() error {
+	if !l.opened {
+		return nil
+	}
+	l.opened = false
+	return l.Child.Close()
+}
+
+func (l *Limit) Schema() []catalog.Column {
+	return l.Child.Schema()
+}
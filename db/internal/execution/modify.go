@@ -4,33 +4,63 @@ import (
 	"fmt"
 	"minibank/internal/catalog"
 	"minibank/internal/storage"
+	"reflect"
 )
 
+// Update rewrites each tuple from Child with the SET values. The new version
+// gets a new RID, so every index of the table is updated, not only those on
+// the columns that changed. The new version must pass the same constraint
+// checks as an INSERT, and a changed key that other rows reference is
+// handled by the foreign keys' ON UPDATE actions.
 type Update struct {
-	HeapFile *storage.HeapFile
+	Table    *Target
 	Child    Iterator
 	SetPairs map[string]interface{}
+
+	// Runtime
+	pending []*storage.Tuple
+	curr    int
 }
 
-func NewUpdate(hf *storage.HeapFile, child Iterator, setPairs map[string]interface{}) *Update {
+func NewUpdate(table *Target, child Iterator, setPairs map[string]interface{}) *Update {
 	return &Update{
-		HeapFile: hf,
+		Table:    table,
 		Child:    child,
 		SetPairs: setPairs,
 	}
 }
 
+// Open collects every qualifying tuple before any is rewritten. Updated
+// tuples can land anywhere in the heap, and a scan still in progress would
+// otherwise see (and update) them again.
 func (op *Update) Open() error {
-	return op.Child.Open()
+	if err := op.Child.Open(); err != nil {
+		return err
+	}
+	op.pending = nil
+	op.curr = 0
+	for {
+		t, err := op.Child.Next()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return nil
+		}
+		op.pending = append(op.pending, t)
+	}
 }
 
 func (op *Update) Next() (*storage.Tuple, error) {
-	t, err := op.Child.Next()
-	if err != nil {
-		return nil, err
-	}
-	if t == nil {
-		return nil, nil
+	var t *storage.Tuple
+	for t == nil {
+		if op.curr >= len(op.pending) {
+			return nil, nil
+		}
+		var err error
+		if t, err = op.current(); err != nil {
+			return nil, err
+		}
 	}
 
 	schema := op.Child.Schema()
@@ -41,7 +71,11 @@ func (op *Update) Next() (*storage.Tuple, error) {
 		found := false
 		for i, col := range schema {
 			if col.Name == colName {
-				cells[i].Value = val
+				v, err := CastValue(val, col.Type)
+				if err != nil {
+					return nil, fmt.Errorf("column %s: %w", col.Name, err)
+				}
+				cells[i].Value = v
 				found = true
 				break
 			}
@@ -51,22 +85,27 @@ func (op *Update) Next() (*storage.Tuple, error) {
 		}
 	}
 
-	newTuple := &storage.Tuple{Cells: cells}
+	return op.Table.update(t, cells)
+}
 
-	data, err := storage.SerializeTuple(newTuple)
-	if err != nil {
-		return nil, err
+// current returns the next pending tuple, or nil if the ON UPDATE action of
+// a foreign key has already rewritten or deleted it. Only a table that other
+// rows reference can have its rows changed that way.
+func (op *Update) current() (*storage.Tuple, error) {
+	t := op.pending[op.curr]
+	op.curr++
+	if len(op.Table.Referenced) == 0 {
+		return t, nil
 	}
-	_, _, err = op.HeapFile.Insert(data)
-	if err != nil {
+	data, err := op.Table.HeapFile.ReadTuple(t.RID.PageID, t.RID.SlotID)
+	if err != nil || data == nil {
 		return nil, err
 	}
-
-	if err := op.HeapFile.DeleteTuple(t.RID); err != nil {
+	now, err := storage.DeserializeTuple(data, op.Child.Schema())
+	if err != nil || !reflect.DeepEqual(now.Cells, t.Cells) {
 		return nil, err
 	}
-
-	return newTuple, nil
+	return t, nil
 }
 
 func (op *Update) Close() error {
@@ -77,36 +116,65 @@ func (op *Update) Schema() []catalog.Column {
 	return op.Child.Schema()
 }
 
+// Delete removes each tuple from Child, and its entries from every index of
+// the table. Rows of other tables that reference it get the foreign keys'
+// ON DELETE actions.
 type Delete struct {
-	HeapFile *storage.HeapFile
-	Child    Iterator
-}
+	Table *Target
+	Child Iterator
 
-func NewDelete(hf *storage.HeapFile, child Iterator) *Delete {
-	return &Delete{HeapFile: hf, Child: child}
+	// Runtime
+	pending []*storage.Tuple
+	curr    int
 }
 
-func (op *Delete) Open() error {
-	return op.Child.Open()
+func NewDelete(table *Target, child Iterator) *Delete {
+	return &Delete{Table: table, Child: child}
 }
 
-func (op *Delete) Next() (*storage.Tuple, error) {
-	t, err := op.Child.Next()
-	if err != nil {
-		return nil, err
+// Open collects every qualifying tuple before any is deleted. An ON DELETE
+// SET NULL or CASCADE action on a table that references itself rewrites
+// rows under new RIDs, which a scan still in progress may already have
+// passed; the table tracks the collected rows so they are deleted wherever
+// they moved.
+func (op *Delete) Open() error {
+	if err := op.Child.Open(); err != nil {
+		return err
 	}
-	if t == nil {
-		return nil, nil
+	op.pending = nil
+	op.curr = 0
+	op.Table.tracked = make(map[storage.RID]*storage.Tuple)
+	for {
+		t, err := op.Child.Next()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return nil
+		}
+		op.pending = append(op.pending, t)
+		op.Table.tracked[t.RID] = t
 	}
+}
 
-	if err := op.HeapFile.DeleteTuple(t.RID); err != nil {
-		return nil, err
+func (op *Delete) Next() (*storage.Tuple, error) {
+	for op.curr < len(op.pending) {
+		t := op.pending[op.curr]
+		op.curr++
+		// A row that an action of an earlier delete removed is gone already.
+		if op.Table.tracked[t.RID] != t {
+			continue
+		}
+		if err := op.Table.delete(t); err != nil {
+			return nil, err
+		}
+		return t, nil
 	}
-
-	return t, nil
+	return nil, nil
 }
 
 func (op *Delete) Close() error {
+	op.Table.tracked = nil
 	return op.Child.Close()
 }
 
@@ -1,7 +1,6 @@
 package execution
 
 import (
-	"fmt"
 	"minibank/internal/catalog"
 	"minibank/internal/parser"
 	"minibank/internal/storage"
@@ -90,15 +89,16 @@ func (f *Filter) Schema() []catalog.Column {
 	return f.Child.Schema()
 }
 
-// Project
+// Project returns the columns at positions Columns of its child's rows, in
+// that order; schema describes them.
 type Project struct {
-	Child  Iterator
-	Fields []string
-	schema []catalog.Column
+	Child   Iterator
+	Columns []int
+	schema  []catalog.Column
 }
 
-func NewProject(child Iterator, fields []string, schema []catalog.Column) *Project {
-	return &Project{Child: child, Fields: fields, schema: schema}
+func NewProject(child Iterator, columns []int, schema []catalog.Column) *Project {
+	return &Project{Child: child, Columns: columns, schema: schema}
 }
 
 func (p *Project) Open() error {
@@ -111,21 +111,9 @@ func (p *Project) Next() (*storage.Tuple, error) {
 		return t, err
 	}
 
-	outCells := make([]storage.Cell, len(p.schema))
-	inputSchema := p.Child.Schema()
-
-	for i, col := range p.schema {
-		found := false
-		for j, inCol := range inputSchema {
-			if inCol.Name == col.Name {
-				outCells[i] = t.Cells[j]
-				found = true
-				break
-			}
-		}
-		if !found {
-			return nil, fmt.Errorf("column %s not found", col.Name)
-		}
+	outCells := make([]storage.Cell, len(p.Columns))
+	for i, j := range p.Columns {
+		outCells[i] = t.Cells[j]
 	}
 	return &storage.Tuple{Cells: outCells}, nil
 }
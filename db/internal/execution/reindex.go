@@ -0,0 +1,98 @@
+package execution
+
+import (
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/storage"
+)
+
+type ReindexTarget struct {
+	TableName string
+	HeapFile  *storage.HeapFile
+	Schema    []catalog.Column
+	Index     TableIndex
+}
+
+// Reindex empties one index per Next call, fills it again from its table
+// and reports how many entries it now has. Like `-mode reindex`, it keeps
+// whatever the rows hold: a unique index whose rows repeat a key is rebuilt
+// anyway, and `-mode check` reports the key.
+type Reindex struct {
+	Targets []ReindexTarget
+	schema  []catalog.Column
+	curr    int
+}
+
+func NewReindex(targets []ReindexTarget) *Reindex {
+	return &Reindex{
+		Targets: targets,
+		schema: []catalog.Column{
+			{Name: "table", Type: catalog.TypeString},
+			{Name: "index", Type: catalog.TypeString},
+			{Name: "entries", Type: catalog.TypeInt},
+		},
+	}
+}
+
+func (op *Reindex) Open() error {
+	op.curr = 0
+	return nil
+}
+
+func (op *Reindex) Next() (*storage.Tuple, error) {
+	if op.curr >= len(op.Targets) {
+		return nil, nil
+	}
+	target := op.Targets[op.curr]
+	op.curr++
+
+	count, err := rebuildIndex(target)
+	if err != nil {
+		return nil, fmt.Errorf("reindex %s: %w", target.Index.Name, err)
+	}
+	return &storage.Tuple{Cells: []storage.Cell{
+		{Type: catalog.TypeString, Value: target.TableName},
+		{Type: catalog.TypeString, Value: target.Index.Name},
+		{Type: catalog.TypeInt, Value: int64(count)},
+	}}, nil
+}
+
+func rebuildIndex(target ReindexTarget) (int, error) {
+	if err := target.Index.Index.Clear(); err != nil {
+		return 0, err
+	}
+	iter := target.HeapFile.Iterator()
+	count := 0
+	for {
+		data, rid, err := iter.Next()
+		if err != nil {
+			return 0, err
+		}
+		if data == nil {
+			return count, nil
+		}
+		tuple, err := storage.DeserializeTuple(data, target.Schema)
+		if err != nil {
+			return 0, err
+		}
+		ok, err := target.Index.Holds(tuple.Cells)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		if err := insertIndexEntries([]TableIndex{target.Index}, tuple.Cells, rid); err != nil {
+			return 0, err
+		}
+		count++
+	}
+}
+
+func (op *Reindex) Close() error {
+	return nil
+}
+
+func (op *Reindex) Schema() []catalog.Column {
+	return op.schema
+}
@@ -0,0 +1,95 @@
+package execution
+
+import (
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/indexing"
+	"minibank/internal/storage"
+	"strings"
+)
+
+type IndexInfo struct {
+	TableName string
+	Def       catalog.IndexDef
+	Index     indexing.Index
+}
+
+// ShowIndexes describes one index per Next call, with the number of entries
+// it holds and the size of its file.
+type ShowIndexes struct {
+	Indexes []IndexInfo
+	schema  []catalog.Column
+	curr    int
+}
+
+func NewShowIndexes(indexes []IndexInfo) *ShowIndexes {
+	return &ShowIndexes{
+		Indexes: indexes,
+		schema: []catalog.Column{
+			{Name: "table", Type: catalog.TypeString},
+			{Name: "index", Type: catalog.TypeString},
+			{Name: "type", Type: catalog.TypeString},
+			{Name: "columns", Type: catalog.TypeString},
+			{Name: "unique", Type: catalog.TypeBool},
+			{Name: "entries", Type: catalog.TypeInt},
+			{Name: "pages", Type: catalog.TypeInt},
+			{Name: "bytes", Type: catalog.TypeInt},
+		},
+	}
+}
+
+func (op *ShowIndexes) Open() error {
+	op.curr = 0
+	return nil
+}
+
+func (op *ShowIndexes) Next() (*storage.Tuple, error) {
+	if op.curr >= len(op.Indexes) {
+		return nil, nil
+	}
+	info := op.Indexes[op.curr]
+	op.curr++
+
+	entries := 0
+	err := info.Index.Scan(func(indexing.Key, storage.RID) error {
+		entries++
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("index %s: %w", info.Def.Name, err)
+	}
+	pages, err := info.Index.Pages()
+	if err != nil {
+		return nil, fmt.Errorf("index %s: %w", info.Def.Name, err)
+	}
+
+	typ := info.Def.Type
+	if typ == "" {
+		typ = catalog.IndexTypeHash
+	}
+	cols := strings.Join(info.Def.Columns, ", ")
+	if len(info.Def.Include) > 0 {
+		cols += " INCLUDE (" + strings.Join(info.Def.Include, ", ") + ")"
+	}
+	if info.Def.Where != "" {
+		cols += " WHERE " + info.Def.Where
+	}
+	return &storage.Tuple{Cells: []storage.Cell{
+		{Type: catalog.TypeString, Value: info.TableName},
+		{Type: catalog.TypeString, Value: info.Def.Name},
+		{Type: catalog.TypeString, Value: typ},
+		{Type: catalog.TypeString, Value: cols},
+		{Type: catalog.TypeBool, Value: info.Def.IsUnique},
+		{Type: catalog.TypeInt, Value: int64(entries)},
+		{Type: catalog.TypeInt, Value: int64(pages)},
+		{Type: catalog.TypeInt, Value: int64(pages) * storage.PageSize},
+	}}, nil
+}
+
+func (op *ShowIndexes) Close() error {
+	return nil
+}
+
+func (op *ShowIndexes) Schema() []catalog.Column {
+	return op.schema
+}
@@ -0,0 +1,460 @@
+package execution
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"minibank/internal/catalog"
+	"minibank/internal/indexing"
+	"minibank/internal/parser"
+	"minibank/internal/storage"
+	"os"
+	"sort"
+)
+
+// DefaultSortMemory is the memory a Sort may use before it spills sorted
+// runs to temporary files.
+const DefaultSortMemory = 4 << 20
+
+const (
+	// sortRowOverhead approximates the memory a buffered row takes besides
+	// its key and tuple bytes.
+	sortRowOverhead = 64
+	// sortFanIn is the number of runs merged at once. More runs are merged
+	// in several passes.
+	sortFanIn = 16
+)
+
+// SortKey is one expression of ORDER BY.
+type SortKey struct {
+	Expr parser.Expression
+	Desc bool
+}
+
+// Sort returns the tuples of Child ordered by Keys. Each row's sort key is
+// encoded like an index key (indexing.EncodeKey), with the bytes of DESC
+// columns inverted, so rows compare as byte strings: DECIMAL values compare
+// as exact numbers, TIMESTAMPs as instants, and NULL sorts first, or last
+// for DESC. Rows with equal keys keep their input order.
+//
+// Rows are buffered in memory up to Memory bytes. A larger input is written
+// to temporary files in sorted runs, which are then merged.
+//
+// When only the first Limit rows will be read (ORDER BY with LIMIT), Sort
+// keeps just those in a heap as it reads its input, and sorts them at the
+// end. If even they do not fit in Memory, it sorts the whole input instead.
+type Sort struct {
+	Child  Iterator
+	Keys   []SortKey
+	Memory int    // bytes; DefaultSortMemory if 0
+	Dir    string // for the temporary files; os.TempDir() if empty
+	Limit  int    // rows that will be read; 0 for all of them
+
+	types []catalog.ColumnType
+
+	// Runtime
+	rows  []sortRow // the rows, when they fit in memory
+	curr  int
+	runs  []string // files of the sorted runs
+	merge *runMerger
+}
+
+type sortRow struct {
+	key  []byte
+	data []byte // the serialized tuple
+	seq  int    // position in the input; not written to runs
+}
+
+func (r sortRow) size() int {
+	return len(r.key) + len(r.data) + sortRowOverhead
+}
+
+// NewSort returns a Sort of child by keys. It fails if a key is not a column
+// of child or a function of one.
+func NewSort(child Iterator, keys []SortKey, memory int) (*Sort, error) {
+	s := &Sort{Child: child, Keys: keys, Memory: memory}
+	for _, k := range keys {
+		if err := checkExpr(k.Expr, child.Schema()); err != nil {
+			return nil, fmt.Errorf("ORDER BY %s: %w", parser.FormatExpr(k.Expr), err)
+		}
+		typ, err := ExprType(k.Expr, child.Schema())
+		if err != nil {
+			return nil, fmt.Errorf("ORDER BY %s: %w", parser.FormatExpr(k.Expr), err)
+		}
+		s.types = append(s.types, typ)
+	}
+	return s, nil
+}
+
+// Open reads and sorts the whole input.
+func (s *Sort) Open() error {
+	s.reset()
+	if err := s.Child.Open(); err != nil {
+		return err
+	}
+	if err := s.load(); err != nil {
+		s.reset()
+		return err
+	}
+	return nil
+}
+
+func (s *Sort) load() error {
+	memory := s.Memory
+	if memory <= 0 {
+		memory = DefaultSortMemory
+	}
+	used := 0
+	var top topRows // the first Limit rows so far
+	limited := s.Limit > 0
+	for seq := 0; ; seq++ {
+		t, err := s.Child.Next()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			break
+		}
+		row, err := s.row(t)
+		if err != nil {
+			return err
+		}
+		row.seq = seq
+		used += row.size()
+		if limited {
+			heap.Push(&top, row)
+			if top.Len() > s.Limit {
+				used -= heap.Pop(&top).(sortRow).size()
+			}
+			if used <= memory {
+				continue
+			}
+			// The first Limit rows do not fit: sort every row instead. The
+			// rows dropped so far are not among them, so they stay dropped.
+			limited = false
+			s.rows, top = top, nil
+		} else {
+			s.rows = append(s.rows, row)
+		}
+		if used > memory {
+			if err := s.spill(); err != nil {
+				return err
+			}
+			used = 0
+		}
+	}
+	if limited {
+		s.rows = top
+	}
+	if len(s.runs) == 0 {
+		sortRows(s.rows)
+		return nil
+	}
+	if len(s.rows) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	// Merge runs into longer ones until one pass can merge them all.
+	for len(s.runs) > sortFanIn {
+		var merged []string
+		for i := 0; i < len(s.runs); i += sortFanIn {
+			end := i + sortFanIn
+			if end > len(s.runs) {
+				end = len(s.runs)
+			}
+			run, err := s.mergeRuns(s.runs[i:end])
+			if err != nil {
+				s.runs = append(merged, s.runs[i:]...)
+				return err
+			}
+			merged = append(merged, run)
+		}
+		s.runs = merged
+	}
+	m, err := openRuns(s.runs)
+	if err != nil {
+		return err
+	}
+	s.merge = m
+	return nil
+}
+
+// row encodes the sort key of t and serializes it.
+func (s *Sort) row(t *storage.Tuple) (sortRow, error) {
+	var key []byte
+	for i, k := range s.Keys {
+		v, err := evalExpr(t, k.Expr, s.Child.Schema())
+		if err != nil {
+			return sortRow{}, err
+		}
+		enc, err := indexing.EncodeKey(s.types[i:i+1], indexing.Key{v})
+		if err != nil {
+			return sortRow{}, fmt.Errorf("ORDER BY %s: %w", parser.FormatExpr(k.Expr), err)
+		}
+		if k.Desc {
+			// The encoding of a value is never a prefix of another's, so
+			// inverting it reverses the order.
+			for j := range enc {
+				enc[j] = ^enc[j]
+			}
+		}
+		key = append(key, enc...)
+	}
+	data, err := storage.SerializeTuple(&storage.Tuple{Cells: t.Cells})
+	if err != nil {
+		return sortRow{}, err
+	}
+	return sortRow{key: key, data: data}, nil
+}
+
+// sortRows sorts rows by key, and rows with equal keys by their position in
+// the input.
+func sortRows(rows []sortRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		return rowBefore(rows[i], rows[j])
+	})
+}
+
+func rowBefore(a, b sortRow) bool {
+	if c := bytes.Compare(a.key, b.key); c != 0 {
+		return c < 0
+	}
+	return a.seq < b.seq
+}
+
+// topRows is a heap of rows whose top is the row that sorts last, so that
+// it is the one to drop when a later row sorts before it.
+type topRows []sortRow
+
+func (h topRows) Len() int            { return len(h) }
+func (h topRows) Less(i, j int) bool  { return rowBefore(h[j], h[i]) }
+func (h topRows) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topRows) Push(x interface{}) { *h = append(*h, x.(sortRow)) }
+func (h *topRows) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// spill sorts the buffered rows and writes them to a new run.
+func (s *Sort) spill() error {
+	sortRows(s.rows)
+	i := 0
+	run, err := writeRun(s.Dir, func() (sortRow, bool, error) {
+		if i == len(s.rows) {
+			return sortRow{}, false, nil
+		}
+		i++
+		return s.rows[i-1], true, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	s.rows = s.rows[:0]
+	return nil
+}
+
+// mergeRuns merges runs into a new one and removes them.
+func (s *Sort) mergeRuns(runs []string) (string, error) {
+	m, err := openRuns(runs)
+	if err != nil {
+		return "", err
+	}
+	run, err := writeRun(s.Dir, m.next)
+	m.close()
+	if err != nil {
+		return "", err
+	}
+	for _, r := range runs {
+		os.Remove(r)
+	}
+	return run, nil
+}
+
+// writeRun writes the rows next returns, until it reports no more, to a new
+// temporary file and returns its name.
+func writeRun(dir string, next func() (sortRow, bool, error)) (string, error) {
+	f, err := os.CreateTemp(dir, "minibank-sort-*.run")
+	if err != nil {
+		return "", fmt.Errorf("sort: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for {
+		var row sortRow
+		var ok bool
+		if row, ok, err = next(); err != nil || !ok {
+			break
+		}
+		if err = writeSortRow(w, row); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("sort: %w", err)
+	}
+	return f.Name(), nil
+}
+
+func (s *Sort) Next() (*storage.Tuple, error) {
+	var row sortRow
+	if s.merge != nil {
+		var ok bool
+		var err error
+		if row, ok, err = s.merge.next(); err != nil || !ok {
+			return nil, err
+		}
+	} else {
+		if s.curr >= len(s.rows) {
+			return nil, nil
+		}
+		row = s.rows[s.curr]
+		s.curr++
+	}
+	return storage.DeserializeTuple(row.data, s.Child.Schema())
+}
+
+// Close removes the temporary files.
+func (s *Sort) Close() error {
+	s.reset()
+	return s.Child.Close()
+}
+
+// reset drops the sorted rows and removes the temporary files.
+func (s *Sort) reset() {
+	if s.merge != nil {
+		s.merge.close()
+		s.merge = nil
+	}
+	for _, run := range s.runs {
+		os.Remove(run)
+	}
+	s.runs, s.rows, s.curr = nil, nil, 0
+}
+
+func (s *Sort) Schema() []catalog.Column {
+	return s.Child.Schema()
+}
+
+// A run holds rows as the length of the key, the key, the length of the
+// tuple and the tuple, with lengths as uvarints.
+
+func writeSortRow(w *bufio.Writer, row sortRow) error {
+	var n [binary.MaxVarintLen64]byte
+	for _, b := range [][]byte{row.key, row.data} {
+		if _, err := w.Write(n[:binary.PutUvarint(n[:], uint64(len(b)))]); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSortRow reads the next row of a run; ok is false at its end.
+func readSortRow(r *bufio.Reader) (row sortRow, ok bool, err error) {
+	var parts [2][]byte
+	for i := range parts {
+		n, err := binary.ReadUvarint(r)
+		if err == io.EOF && i == 0 {
+			return sortRow{}, false, nil
+		}
+		if err != nil {
+			return sortRow{}, false, fmt.Errorf("truncated sort run: %w", err)
+		}
+		parts[i] = make([]byte, n)
+		if _, err := io.ReadFull(r, parts[i]); err != nil {
+			return sortRow{}, false, fmt.Errorf("truncated sort run: %w", err)
+		}
+	}
+	return sortRow{key: parts[0], data: parts[1]}, true, nil
+}
+
+// runMerger merges sorted runs. On equal keys the row of the earlier run
+// comes first, which keeps the sort stable.
+type runMerger struct {
+	files   []*os.File
+	readers []*bufio.Reader
+	heads   runHeap
+}
+
+type runHead struct {
+	row sortRow
+	run int
+}
+
+type runHeap []runHead
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].row.key, h[j].row.key); c != 0 {
+		return c < 0
+	}
+	return h[i].run < h[j].run
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(runHead)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func openRuns(runs []string) (*runMerger, error) {
+	m := &runMerger{}
+	for i, run := range runs {
+		f, err := os.Open(run)
+		if err != nil {
+			m.close()
+			return nil, fmt.Errorf("sort: %w", err)
+		}
+		m.files = append(m.files, f)
+		m.readers = append(m.readers, bufio.NewReader(f))
+		if err := m.advance(i); err != nil {
+			m.close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// advance pushes the next row of run i, if it has one.
+func (m *runMerger) advance(i int) error {
+	row, ok, err := readSortRow(m.readers[i])
+	if err != nil || !ok {
+		return err
+	}
+	heap.Push(&m.heads, runHead{row: row, run: i})
+	return nil
+}
+
+func (m *runMerger) next() (sortRow, bool, error) {
+	if m.heads.Len() == 0 {
+		return sortRow{}, false, nil
+	}
+	head := heap.Pop(&m.heads).(runHead)
+	if err := m.advance(head.run); err != nil {
+		return sortRow{}, false, err
+	}
+	return head.row, true, nil
+}
+
+func (m *runMerger) close() {
+	for _, f := range m.files {
+		f.Close()
+	}
+}
@@ -0,0 +1,325 @@
+package execution
+
+import (
+	"bytes"
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/errors"
+	"minibank/internal/indexing"
+	"minibank/internal/storage"
+)
+
+// Target is a table that a DML statement writes: the one it names, or one
+// that the actions of a foreign key reach. It keeps the table's indexes and
+// constraints in step with every row it writes.
+type Target struct {
+	Name     string
+	HeapFile *storage.HeapFile
+	Schema   []catalog.Column
+	Indexes  []TableIndex
+	Checks   []Check
+	// References are the foreign keys of the table, Referenced those of the
+	// tables that reference it (possibly itself).
+	References []*ForeignKey
+	Referenced []*ForeignKey
+
+	// tracked holds the rows a Delete has yet to remove, by their current
+	// RID. update moves a tracked row to its new RID and delete drops it, so
+	// rows that foreign key actions rewrite are still found.
+	tracked map[storage.RID]*storage.Tuple
+}
+
+// ForeignKey is a foreign key from the rows of Table to those of RefTable.
+type ForeignKey struct {
+	Name       string
+	Table      *Target
+	Columns    []int // positions in Table.Schema
+	RefTable   *Target
+	RefColumns []int // positions in RefTable.Schema, parallel to Columns
+	OnDelete   string
+	OnUpdate   string
+}
+
+// insert adds a row to the table.
+func (t *Target) insert(cells []storage.Cell) (*storage.Tuple, error) {
+	tuple := &storage.Tuple{Cells: cells}
+	if err := checkRow(t.Schema, t.Checks, cells); err != nil {
+		return nil, err
+	}
+	if err := t.checkReferences(nil, cells); err != nil {
+		return nil, err
+	}
+	if err := checkConstraints(t.Indexes, cells); err != nil {
+		return nil, err
+	}
+
+	data, err := storage.SerializeTuple(tuple)
+	if err != nil {
+		return nil, err
+	}
+	pid, slotID, err := t.HeapFile.Insert(data)
+	if err != nil {
+		return nil, err
+	}
+	tuple.RID = storage.RID{PageID: pid, SlotID: slotID}
+	if err := insertIndexEntries(t.Indexes, cells, tuple.RID); err != nil {
+		return nil, err
+	}
+	return tuple, nil
+}
+
+// update replaces the row old with one holding cells, which gets a new RID,
+// then applies the ON UPDATE action of each foreign key whose referenced key
+// it changed.
+func (t *Target) update(old *storage.Tuple, cells []storage.Cell) (*storage.Tuple, error) {
+	tuple := &storage.Tuple{Cells: cells}
+	if err := checkRow(t.Schema, t.Checks, cells); err != nil {
+		return nil, err
+	}
+	if err := t.checkReferences(old.Cells, cells); err != nil {
+		return nil, err
+	}
+
+	data, err := storage.SerializeTuple(tuple)
+	if err != nil {
+		return nil, err
+	}
+	if err := deleteIndexEntries(t.Indexes, old.Cells, old.RID); err != nil {
+		return nil, err
+	}
+	if err := checkConstraints(t.Indexes, cells); err != nil {
+		return nil, err
+	}
+	if err := t.HeapFile.DeleteTuple(old.RID); err != nil {
+		return nil, err
+	}
+	pid, slotID, err := t.HeapFile.Insert(data)
+	if err != nil {
+		return nil, err
+	}
+	tuple.RID = storage.RID{PageID: pid, SlotID: slotID}
+	if err := insertIndexEntries(t.Indexes, cells, tuple.RID); err != nil {
+		return nil, err
+	}
+	if row, ok := t.tracked[old.RID]; ok {
+		delete(t.tracked, old.RID)
+		row.RID, row.Cells = tuple.RID, cells
+		t.tracked[tuple.RID] = row
+	}
+
+	for _, fk := range t.Referenced {
+		oldKey, newKey := keyOf(old.Cells, fk.RefColumns), keyOf(cells, fk.RefColumns)
+		if oldKey.HasNull() || fk.sameKey(oldKey, newKey) {
+			continue
+		}
+		if err := fk.apply(fk.OnUpdate, "UPDATE", oldKey, newKey); err != nil {
+			return nil, err
+		}
+	}
+	return tuple, nil
+}
+
+// delete removes the row old, then applies the ON DELETE action of each
+// foreign key that references the table. The row is gone by then, so a row
+// that references itself does not hold up its own deletion.
+func (t *Target) delete(old *storage.Tuple) error {
+	if err := deleteIndexEntries(t.Indexes, old.Cells, old.RID); err != nil {
+		return err
+	}
+	if err := t.HeapFile.DeleteTuple(old.RID); err != nil {
+		return err
+	}
+	delete(t.tracked, old.RID)
+
+	for _, fk := range t.Referenced {
+		key := keyOf(old.Cells, fk.RefColumns)
+		if key.HasNull() {
+			continue
+		}
+		if err := fk.apply(fk.OnDelete, "DELETE", key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkReferences rejects a row whose foreign keys reference no row. A key
+// with a NULL references nothing and always passes, an UPDATE (old not nil)
+// only checks the keys it changes, and a row may reference itself.
+func (t *Target) checkReferences(old, cells []storage.Cell) error {
+	for _, fk := range t.References {
+		key := keyOf(cells, fk.Columns)
+		if key.HasNull() || old != nil && fk.sameKey(keyOf(old, fk.Columns), key) {
+			continue
+		}
+		if fk.RefTable == t && fk.sameKey(keyOf(cells, fk.RefColumns), key) {
+			continue
+		}
+		rows, err := fk.RefTable.find(fk.RefColumns, key)
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			continue
+		}
+		return errors.New(errors.ErrConstraintViolation,
+			fmt.Sprintf("foreign key constraint violation: %s (key %s, constraint %s) is not present in table %s",
+				columnList(columnNames(t.Schema, fk.Columns)), keyString(key), fk.Name, fk.RefTable.Name),
+			fmt.Sprintf("Insert the row of %s first, or leave the column NULL.", fk.RefTable.Name))
+	}
+	return nil
+}
+
+// apply carries out action for the rows that reference the key oldKey of a
+// deleted or updated row (event is "DELETE" or "UPDATE"). newKey is the
+// row's new key, nil after a DELETE.
+func (fk *ForeignKey) apply(action, event string, oldKey, newKey indexing.Key) error {
+	rows, err := fk.Table.find(fk.Columns, oldKey)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	switch action {
+	case catalog.ActionCascade, catalog.ActionSetNull:
+		for _, row := range rows {
+			if action == catalog.ActionCascade && newKey == nil {
+				if err := fk.Table.delete(row); err != nil {
+					return err
+				}
+				continue
+			}
+			cells := make([]storage.Cell, len(row.Cells))
+			copy(cells, row.Cells)
+			for i, col := range fk.Columns {
+				cells[col].Value = nil
+				if action == catalog.ActionCascade {
+					cells[col].Value = newKey[i]
+				}
+			}
+			if _, err := fk.Table.update(row, cells); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.New(errors.ErrConstraintViolation,
+			fmt.Sprintf("foreign key constraint violation: %s (key %s) of table %s is still referenced from table %s (constraint %s)",
+				columnList(columnNames(fk.RefTable.Schema, fk.RefColumns)), keyString(oldKey), fk.RefTable.Name, fk.Table.Name, fk.Name),
+			fmt.Sprintf("Change or delete the rows of %s first, or declare the foreign key ON %s CASCADE or ON %s SET NULL.", fk.Table.Name, event, event))
+	}
+}
+
+// sameKey reports whether two keys of the foreign key hold the same values,
+// so that 10.5 and 10.50 are one DECIMAL key.
+func (fk *ForeignKey) sameKey(a, b indexing.Key) bool {
+	types := make([]catalog.ColumnType, len(fk.Columns))
+	for i, col := range fk.Columns {
+		types[i] = fk.Table.Schema[col].Type
+	}
+	ka, errA := indexing.EncodeKey(types, a)
+	kb, errB := indexing.EncodeKey(types, b)
+	return errA == nil && errB == nil && bytes.Equal(ka, kb)
+}
+
+// find returns the rows whose columns cols hold key. It reads them through
+// an index on those columns when the table has one, and scans the heap
+// otherwise.
+func (t *Target) find(cols []int, key indexing.Key) ([]*storage.Tuple, error) {
+	types := make([]catalog.ColumnType, len(cols))
+	for i, col := range cols {
+		types[i] = t.Schema[col].Type
+	}
+	want, err := indexing.EncodeKey(types, key)
+	if err != nil {
+		return nil, err
+	}
+	matches := func(cells []storage.Cell) bool {
+		got, err := indexing.EncodeKey(types, keyOf(cells, cols))
+		return err == nil && bytes.Equal(got, want)
+	}
+
+	var rows []*storage.Tuple
+	if rids, ok, err := t.lookup(cols, key); err != nil {
+		return nil, err
+	} else if ok {
+		for curr := 0; ; {
+			tuple, err := fetchRIDs(t.HeapFile, t.Schema, rids, &curr)
+			if err != nil {
+				return nil, err
+			}
+			if tuple == nil {
+				return rows, nil
+			}
+			if matches(tuple.Cells) {
+				rows = append(rows, tuple)
+			}
+		}
+	}
+
+	iter := t.HeapFile.Iterator()
+	defer iter.Close()
+	for {
+		data, rid, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return rows, nil
+		}
+		tuple, err := storage.DeserializeTuple(data, t.Schema)
+		if err != nil {
+			return nil, err
+		}
+		if matches(tuple.Cells) {
+			tuple.RID = rid
+			rows = append(rows, tuple)
+		}
+	}
+}
+
+// lookup returns the RIDs an index of the table holds for key on the
+// columns cols. Only an index that holds every row and whose leading key
+// columns are cols can answer; ok is false if there is none.
+func (t *Target) lookup(cols []int, key indexing.Key) (rids []storage.RID, ok bool, err error) {
+	for _, ti := range t.Indexes {
+		if _, trigram := ti.Index.(*indexing.TrigramIndex); trigram || ti.Where != nil || len(ti.Columns) < len(cols) {
+			continue
+		}
+		leading := true
+		for i, col := range cols {
+			leading = leading && ti.Columns[i] == col
+		}
+		if !leading {
+			continue
+		}
+		if len(ti.Columns) == len(cols) {
+			rids, err = ti.Index.Get(key)
+		} else if ri, ordered := ti.Index.(indexing.RangeIndex); ordered {
+			bound := &indexing.Bound{Key: key, Inclusive: true}
+			rids, err = ri.Range(bound, bound)
+		} else {
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("index %s: %w", ti.Name, err)
+		}
+		return rids, true, nil
+	}
+	return nil, false, nil
+}
+
+// keyOf returns the values of a row in the columns cols.
+func keyOf(cells []storage.Cell, cols []int) indexing.Key {
+	key := make(indexing.Key, len(cols))
+	for i, col := range cols {
+		key[i] = cells[col].Value
+	}
+	return key
+}
+
+func columnNames(schema []catalog.Column, cols []int) []string {
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = schema[col].Name
+	}
+	return names
+}
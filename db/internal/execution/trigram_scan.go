@@ -0,0 +1,54 @@
+package execution
+
+import (
+	"minibank/internal/catalog"
+	"minibank/internal/indexing"
+	"minibank/internal/storage"
+)
+
+// TrigramScan returns the tuples whose indexed value has at least Min of
+// Trigrams, in RID order. They are only candidates for the LIKE or MATCH
+// that chose the index, so a Filter must still check them.
+type TrigramScan struct {
+	Index    *indexing.TrigramIndex
+	HeapFile *storage.HeapFile
+	Trigrams []string
+	Min      int
+	schema   []catalog.Column
+
+	// Runtime
+	rids []storage.RID
+	curr int
+}
+
+func NewTrigramScan(idx *indexing.TrigramIndex, hf *storage.HeapFile, trigrams []string, min int, schema []catalog.Column) *TrigramScan {
+	return &TrigramScan{
+		Index:    idx,
+		HeapFile: hf,
+		Trigrams: trigrams,
+		Min:      min,
+		schema:   schema,
+	}
+}
+
+func (scan *TrigramScan) Open() error {
+	rids, err := scan.Index.Search(scan.Trigrams, scan.Min)
+	if err != nil {
+		return err
+	}
+	scan.rids = rids
+	scan.curr = 0
+	return nil
+}
+
+func (scan *TrigramScan) Next() (*storage.Tuple, error) {
+	return fetchRIDs(scan.HeapFile, scan.schema, scan.rids, &scan.curr)
+}
+
+func (scan *TrigramScan) Close() error {
+	return nil
+}
+
+func (scan *TrigramScan) Schema() []catalog.Column {
+	return scan.schema
+}
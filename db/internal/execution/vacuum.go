@@ -0,0 +1,78 @@
+package execution
+
+import (
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/storage"
+)
+
+type VacuumTarget struct {
+	TableName string
+	HeapFile  *storage.HeapFile
+	Schema    []catalog.Column
+	Indexes   []TableIndex
+}
+
+// Vacuum compacts one table per Next call and reports what it reclaimed.
+// Tuples the heap relocates are re-pointed in the table's indexes.
+type Vacuum struct {
+	Targets []VacuumTarget
+	schema  []catalog.Column
+	curr    int
+}
+
+func NewVacuum(targets []VacuumTarget) *Vacuum {
+	return &Vacuum{
+		Targets: targets,
+		schema: []catalog.Column{
+			{Name: "table", Type: catalog.TypeString},
+			{Name: "pages_before", Type: catalog.TypeInt},
+			{Name: "pages_after", Type: catalog.TypeInt},
+			{Name: "tuples_moved", Type: catalog.TypeInt},
+			{Name: "slots_reclaimed", Type: catalog.TypeInt},
+		},
+	}
+}
+
+func (op *Vacuum) Open() error {
+	op.curr = 0
+	return nil
+}
+
+func (op *Vacuum) Next() (*storage.Tuple, error) {
+	if op.curr >= len(op.Targets) {
+		return nil, nil
+	}
+	target := op.Targets[op.curr]
+	op.curr++
+
+	stats, err := target.HeapFile.Vacuum(func(data []byte, from, to storage.RID) error {
+		tuple, err := storage.DeserializeTuple(data, target.Schema)
+		if err != nil {
+			return err
+		}
+		if err := deleteIndexEntries(target.Indexes, tuple.Cells, from); err != nil {
+			return err
+		}
+		return insertIndexEntries(target.Indexes, tuple.Cells, to)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vacuum %s: %w", target.TableName, err)
+	}
+
+	return &storage.Tuple{Cells: []storage.Cell{
+		{Type: catalog.TypeString, Value: target.TableName},
+		{Type: catalog.TypeInt, Value: int64(stats.PagesBefore)},
+		{Type: catalog.TypeInt, Value: int64(stats.PagesAfter)},
+		{Type: catalog.TypeInt, Value: int64(stats.TuplesMoved)},
+		{Type: catalog.TypeInt, Value: int64(stats.SlotsReclaimed)},
+	}}, nil
+}
+
+func (op *Vacuum) Close() error {
+	return nil
+}
+
+func (op *Vacuum) Schema() []catalog.Column {
+	return op.schema
+}
@@ -0,0 +1,541 @@
+package indexing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/storage"
+	"sort"
+	"sync"
+)
+
+// BTree is an ordered index stored in its own file (<table>.<index>.idx).
+// Page 0 is a meta page holding the root page; every other page is a node in
+// the entry page layout (see entry_page.go), with a child page in each entry
+// of an internal node.
+//
+// An entry holds the values of the indexed columns followed by those of the
+// included columns. Entries are ordered by these values and then by RID, so
+// duplicate keys are still distinct entries. In a leaf, link is the next leaf
+// to the right, which range scans follow. In an internal node, link is the
+// child holding everything below the first entry, and each entry's child
+// holds everything from that entry up to the next one. Nodes split when full
+// but are never merged.
+//
+// Pages go through the buffer pool like heap pages, so changes to the tree
+// are logged and roll back with the statement that made them.
+type BTree struct {
+	pager   *storage.Pager
+	pool    *storage.BufferPool
+	types   []catalog.ColumnType // key columns, then included columns
+	keyCols int
+	mu      sync.RWMutex
+}
+
+const (
+	btreeRootOffset = storage.PageMetaSize
+
+	btreeMetaPage storage.PageID = 0
+	btreeNoPage   storage.PageID = 0 // page 0 is the meta page, never a node
+)
+
+// minRID sorts before every real RID, so (key, minRID) is where the entries
+// of key start.
+var minRID = storage.RID{PageID: -1, SlotID: -1}
+
+type btreeNode struct {
+	id      storage.PageID
+	leaf    bool
+	link    storage.PageID
+	entries []indexEntry
+}
+
+// NewBTree returns the tree stored in pager, whose keys are the values of
+// columns of the given key types and whose entries also hold the values of
+// columns of the include types. An empty file is an empty tree; its pages are
+// created by the first insert.
+func NewBTree(pager *storage.Pager, pool *storage.BufferPool, keyTypes, includeTypes []catalog.ColumnType) *BTree {
+	return &BTree{pager: pager, pool: pool, types: entryTypes(keyTypes, includeTypes), keyCols: len(keyTypes)}
+}
+
+func (t *BTree) Insert(values Key, rid storage.RID) error {
+	k, err := encodeKey(t.types, values)
+	if err != nil {
+		return err
+	}
+	if err := checkKeySize(k); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	root, err := t.root()
+	if err != nil {
+		return err
+	}
+	if root == btreeNoPage {
+		if root, err = t.create(); err != nil {
+			return err
+		}
+	}
+
+	split, err := t.insert(root, indexEntry{key: k, rid: rid})
+	if err != nil || split == nil {
+		return err
+	}
+	newRoot := &btreeNode{id: t.pager.AllocatePage(), link: root, entries: []indexEntry{*split}}
+	if err := t.writeNode(newRoot); err != nil {
+		return err
+	}
+	return t.setRoot(newRoot.id)
+}
+
+// insert adds e below node id. If the node had to split, it returns the
+// entry that the parent must add for the new right sibling.
+func (t *BTree) insert(id storage.PageID, e indexEntry) (*indexEntry, error) {
+	n, err := t.readNode(id)
+	if err != nil {
+		return nil, err
+	}
+	if !n.leaf {
+		split, err := t.insert(t.child(n, e.key, e.rid), e)
+		if err != nil || split == nil {
+			return nil, err
+		}
+		e = *split
+	}
+
+	i := t.search(n, e.key, e.rid)
+	if n.leaf && i < len(n.entries) && t.compare(n.entries[i], e.key, e.rid) == 0 {
+		return nil, nil // already indexed
+	}
+	n.entries = append(n.entries, indexEntry{})
+	copy(n.entries[i+1:], n.entries[i:])
+	n.entries[i] = e
+
+	if entriesSize(n.entries, !n.leaf) <= entryCapacity {
+		return nil, t.writeNode(n)
+	}
+	return t.split(n)
+}
+
+// split moves the upper half of an overfull node to a new page.
+func (t *BTree) split(n *btreeNode) (*indexEntry, error) {
+	half, mid, used := entriesSize(n.entries, !n.leaf)/2, 0, 0
+	for mid < len(n.entries)-1 && used < half {
+		used += entrySize(n.entries[mid], !n.leaf)
+		mid++
+	}
+
+	right := &btreeNode{id: t.pager.AllocatePage(), leaf: n.leaf}
+	var sep indexEntry
+	if n.leaf {
+		right.entries = append([]indexEntry(nil), n.entries[mid:]...)
+		right.link = n.link
+		n.link = right.id
+		sep = indexEntry{key: right.entries[0].key, rid: right.entries[0].rid}
+	} else {
+		// The middle entry moves up; its child becomes the right node's
+		// leftmost child.
+		sep = n.entries[mid]
+		right.link = sep.child
+		right.entries = append([]indexEntry(nil), n.entries[mid+1:]...)
+	}
+	n.entries = n.entries[:mid]
+	sep.child = right.id
+
+	if err := t.writeNode(n); err != nil {
+		return nil, err
+	}
+	if err := t.writeNode(right); err != nil {
+		return nil, err
+	}
+	return &sep, nil
+}
+
+func (t *BTree) Delete(values Key, rid storage.RID) error {
+	k, err := encodeKey(t.types, values)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	root, err := t.root()
+	if err != nil || root == btreeNoPage {
+		return err
+	}
+	n, err := t.findLeaf(root, k, rid)
+	if err != nil {
+		return err
+	}
+	i := t.search(n, k, rid)
+	if i == len(n.entries) || t.compare(n.entries[i], k, rid) != 0 {
+		return nil
+	}
+	n.entries = append(n.entries[:i], n.entries[i+1:]...)
+	return t.writeNode(n)
+}
+
+// Clear removes every entry, e.g. before the index is rebuilt.
+func (t *BTree) Clear() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return clearIndexFile(t.pager, t.pool)
+}
+
+func (t *BTree) Get(key Key) ([]storage.RID, error) {
+	entries, err := t.Lookup(key)
+	return entryRIDs(entries), err
+}
+
+func (t *BTree) Lookup(key Key) ([]Entry, error) {
+	if len(key) != t.keyCols {
+		return nil, fmt.Errorf("index key has %d values, the index has %d columns", len(key), t.keyCols)
+	}
+	b := &Bound{Key: key, Inclusive: true}
+	return t.RangeEntries(b, b)
+}
+
+func (t *BTree) Range(lo, hi *Bound) ([]storage.RID, error) {
+	var rids []storage.RID
+	err := t.rangeScan(lo, hi, func(e indexEntry) error {
+		rids = append(rids, e.rid)
+		return nil
+	})
+	return rids, err
+}
+
+func (t *BTree) RangeEntries(lo, hi *Bound) ([]Entry, error) {
+	var entries []Entry
+	err := t.rangeScan(lo, hi, func(e indexEntry) error {
+		values, err := decodeKey(t.types, e.key)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, Entry{Values: values, RID: e.rid})
+		return nil
+	})
+	return entries, err
+}
+
+// rangeScan visits the entries between lo and hi in order.
+func (t *BTree) rangeScan(lo, hi *Bound, visit func(e indexEntry) error) error {
+	r, err := t.newKeyRange(lo, hi)
+	if err != nil {
+		return err
+	}
+	return t.scan(r.loKey, func(e indexEntry) (bool, error) {
+		in, more := t.inRange(r, e)
+		if !in {
+			return more, nil
+		}
+		return true, visit(e)
+	})
+}
+
+// keyRange holds the bounds of a range scan and their encoded keys. The
+// bounds are prefixes of the key columns; an entry equal to a bound on those
+// columns is in range when the bound is inclusive.
+type keyRange struct {
+	lo, hi       *Bound
+	loKey, hiKey []byte
+}
+
+func (t *BTree) newKeyRange(lo, hi *Bound) (*keyRange, error) {
+	r := &keyRange{lo: lo, hi: hi}
+	var err error
+	if lo != nil {
+		if r.loKey, err = t.encodeBound(lo); err != nil {
+			return nil, err
+		}
+	}
+	if hi != nil {
+		if r.hiKey, err = t.encodeBound(hi); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// inRange reports whether e lies in r, and whether entries after it may, so
+// that a scan stops at the first entry past the upper bound.
+func (t *BTree) inRange(r *keyRange, e indexEntry) (in, more bool) {
+	if r.lo == nil {
+		if leadingNull(e.key) {
+			return false, true
+		}
+	} else if c := compareKeys(t.types, e.key, r.loKey); c < 0 || (c == 0 && !r.lo.Inclusive) {
+		return false, true
+	}
+	if r.hi != nil {
+		if c := compareKeys(t.types, e.key, r.hiKey); c > 0 || (c == 0 && !r.hi.Inclusive) {
+			return false, false
+		}
+	}
+	return true, true
+}
+
+// Cursor returns a cursor over the entries between lo and hi. It descends to
+// the first leaf when first advanced and then follows the leaf chain one leaf
+// at a time, so a LIMIT that stops early never reads the rest of the range.
+func (t *BTree) Cursor(lo, hi *Bound) (Cursor, error) {
+	r, err := t.newKeyRange(lo, hi)
+	if err != nil {
+		return nil, err
+	}
+	return &btreeCursor{t: t, r: r}, nil
+}
+
+// btreeCursor holds a copy of the leaf it is reading and the position of
+// the next entry in it. It only holds the tree's lock while it reads a page,
+// so the tree may change between calls to Next.
+type btreeCursor struct {
+	t    *BTree
+	r    *keyRange
+	leaf *btreeNode // nil until the first Next
+	pos  int
+	done bool
+}
+
+func (c *btreeCursor) Next() (Entry, bool, error) {
+	for !c.done {
+		e, ok, err := c.step()
+		if err != nil {
+			return Entry{}, false, err
+		}
+		if !ok {
+			break
+		}
+		in, more := c.t.inRange(c.r, e)
+		if !more {
+			break
+		}
+		if !in {
+			continue
+		}
+		values, err := decodeKey(c.t.types, e.key)
+		if err != nil {
+			return Entry{}, false, err
+		}
+		return Entry{Values: values, RID: e.rid}, true, nil
+	}
+	c.done = true
+	return Entry{}, false, nil
+}
+
+// step returns the next entry of the leaf chain. At the end of a leaf it
+// reads the leaf again and resumes after the last entry it returned, in case
+// the leaf split and moved later entries to a new right sibling since it was
+// copied; nodes are never merged, so those entries can only have moved right.
+func (c *btreeCursor) step() (indexEntry, bool, error) {
+	t := c.t
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if c.leaf == nil {
+		root, err := t.root()
+		if err != nil || root == btreeNoPage {
+			return indexEntry{}, false, err
+		}
+		if c.leaf, err = t.findLeaf(root, c.r.loKey, minRID); err != nil {
+			return indexEntry{}, false, err
+		}
+		c.pos = 0
+	}
+	for c.pos >= len(c.leaf.entries) {
+		fresh, err := t.readNode(c.leaf.id)
+		if err != nil {
+			return indexEntry{}, false, err
+		}
+		if c.pos > 0 {
+			last := c.leaf.entries[c.pos-1]
+			i := t.search(fresh, last.key, last.rid)
+			if i < len(fresh.entries) && t.compare(fresh.entries[i], last.key, last.rid) == 0 {
+				i++
+			}
+			if i < len(fresh.entries) {
+				c.leaf, c.pos = fresh, i
+				break
+			}
+		}
+		if fresh.link == btreeNoPage {
+			return indexEntry{}, false, nil
+		}
+		if c.leaf, err = t.readNode(fresh.link); err != nil {
+			return indexEntry{}, false, err
+		}
+		c.pos = 0
+	}
+	e := c.leaf.entries[c.pos]
+	c.pos++
+	return e, true, nil
+}
+
+func (t *BTree) encodeBound(b *Bound) ([]byte, error) {
+	if len(b.Key) > t.keyCols {
+		return nil, fmt.Errorf("index bound has %d values, the index has %d columns", len(b.Key), t.keyCols)
+	}
+	return encodePrefix(t.types, b.Key)
+}
+
+func (t *BTree) Scan(visit func(values Key, rid storage.RID) error) error {
+	return t.scan(nil, func(e indexEntry) (bool, error) {
+		values, err := decodeKey(t.types, e.key)
+		if err != nil {
+			return false, err
+		}
+		return true, visit(values, e.rid)
+	})
+}
+
+// scan walks the leaves in order, starting at the first entry for from (which
+// may be a key prefix) or at the leftmost leaf when from is nil, until visit
+// returns false.
+func (t *BTree) scan(from []byte, visit func(e indexEntry) (bool, error)) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	root, err := t.root()
+	if err != nil || root == btreeNoPage {
+		return err
+	}
+	n, err := t.findLeaf(root, from, minRID)
+	if err != nil {
+		return err
+	}
+	for {
+		for _, e := range n.entries {
+			more, err := visit(e)
+			if err != nil || !more {
+				return err
+			}
+		}
+		if n.link == btreeNoPage {
+			return nil
+		}
+		if n, err = t.readNode(n.link); err != nil {
+			return err
+		}
+	}
+}
+
+// findLeaf descends to the leaf that holds (key, rid). A nil key finds the
+// leftmost leaf.
+func (t *BTree) findLeaf(id storage.PageID, key []byte, rid storage.RID) (*btreeNode, error) {
+	for {
+		n, err := t.readNode(id)
+		if err != nil {
+			return nil, err
+		}
+		if n.leaf {
+			return n, nil
+		}
+		if key == nil {
+			id = n.link
+		} else {
+			id = t.child(n, key, rid)
+		}
+	}
+}
+
+// child returns the child of an internal node that covers (key, rid).
+func (t *BTree) child(n *btreeNode, key []byte, rid storage.RID) storage.PageID {
+	i := sort.Search(len(n.entries), func(i int) bool { return t.compare(n.entries[i], key, rid) > 0 })
+	if i == 0 {
+		return n.link
+	}
+	return n.entries[i-1].child
+}
+
+// search returns the position of the first entry not below (key, rid).
+func (t *BTree) search(n *btreeNode, key []byte, rid storage.RID) int {
+	return sort.Search(len(n.entries), func(i int) bool { return t.compare(n.entries[i], key, rid) >= 0 })
+}
+
+func (t *BTree) compare(e indexEntry, key []byte, rid storage.RID) int {
+	if c := compareKeys(t.types, e.key, key); c != 0 {
+		return c
+	}
+	return compareRIDs(e.rid, rid)
+}
+
+func (t *BTree) Pages() (int, error) {
+	return t.pager.PageCount()
+}
+
+// root returns the root page, or btreeNoPage while the tree is empty.
+func (t *BTree) root() (storage.PageID, error) {
+	count, err := t.pager.PageCount()
+	if err != nil || count == 0 {
+		return btreeNoPage, err
+	}
+	page, err := t.pool.FetchPage(t.pager, btreeMetaPage)
+	if err != nil {
+		return btreeNoPage, err
+	}
+	defer t.pool.UnpinPage(t.pager, btreeMetaPage, false)
+	return storage.PageID(binary.BigEndian.Uint32(page.Data[btreeRootOffset:])), nil
+}
+
+func (t *BTree) setRoot(id storage.PageID) error {
+	page, err := t.pool.FetchPage(t.pager, btreeMetaPage)
+	if err != nil {
+		return err
+	}
+	page.SetType(storage.PageTypeBTreeMeta)
+	binary.BigEndian.PutUint32(page.Data[btreeRootOffset:], uint32(id))
+	t.pool.UnpinPage(t.pager, btreeMetaPage, true)
+	return nil
+}
+
+// create writes the meta page and an empty root leaf.
+func (t *BTree) create() (storage.PageID, error) {
+	count, err := t.pager.PageCount()
+	if err != nil {
+		return btreeNoPage, err
+	}
+	if count == 0 {
+		t.pager.AllocatePage() // meta page
+	}
+	root := &btreeNode{id: t.pager.AllocatePage(), leaf: true}
+	if err := t.writeNode(root); err != nil {
+		return btreeNoPage, err
+	}
+	return root.id, t.setRoot(root.id)
+}
+
+func (t *BTree) readNode(id storage.PageID) (*btreeNode, error) {
+	page, err := t.pool.FetchPage(t.pager, id)
+	if err != nil {
+		return nil, err
+	}
+	defer t.pool.UnpinPage(t.pager, id, false)
+
+	n := &btreeNode{id: id}
+	switch page.Type() {
+	case storage.PageTypeBTreeLeaf:
+		n.leaf = true
+	case storage.PageTypeBTreeInternal:
+	default:
+		return nil, fmt.Errorf("b+tree page %d is a %s page", id, page.Type())
+	}
+	n.link, n.entries, err = decodeEntryPage(page, !n.leaf)
+	return n, err
+}
+
+func (t *BTree) writeNode(n *btreeNode) error {
+	page, err := t.pool.FetchPage(t.pager, n.id)
+	if err != nil {
+		return err
+	}
+	typ := storage.PageTypeBTreeInternal
+	if n.leaf {
+		typ = storage.PageTypeBTreeLeaf
+	}
+	encodeEntryPage(page, typ, n.link, n.entries, !n.leaf)
+	t.pool.UnpinPage(t.pager, n.id, true)
+	return nil
+}
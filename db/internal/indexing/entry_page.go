@@ -0,0 +1,146 @@
+package indexing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"minibank/internal/storage"
+)
+
+// B+tree nodes and hash buckets share one page layout after the common page
+// header:
+//
+//	[entry count uint16][link uint32][entries...]
+//	entry: [key length uint16][key][rid page uint32][rid slot uint16]
+//
+// B+tree internal nodes append [child page uint32] to every entry. What link
+// points to depends on the page type.
+const (
+	entryCountOffset = storage.PageMetaSize
+	entryLinkOffset  = storage.PageMetaSize + 2
+	entryDataOffset  = storage.PageMetaSize + 6
+	entryCapacity    = storage.PageSize - entryDataOffset
+
+	entryOverhead = 2 + 4 + 2 // key length + RID
+
+	// MaxKeySize is the largest encoded key an on-disk index accepts. It
+	// keeps at least three entries on every page.
+	MaxKeySize = 1024
+)
+
+type indexEntry struct {
+	key   []byte
+	rid   storage.RID
+	child storage.PageID // internal B+tree nodes only
+}
+
+func entrySize(e indexEntry, withChild bool) int {
+	if withChild {
+		return entryOverhead + len(e.key) + 4
+	}
+	return entryOverhead + len(e.key)
+}
+
+func entriesSize(entries []indexEntry, withChild bool) int {
+	size := 0
+	for _, e := range entries {
+		size += entrySize(e, withChild)
+	}
+	return size
+}
+
+func checkKeySize(key []byte) error {
+	if len(key) > MaxKeySize {
+		return fmt.Errorf("index key of %d bytes exceeds the limit of %d bytes", len(key), MaxKeySize)
+	}
+	return nil
+}
+
+func decodeEntryPage(page *storage.Page, withChild bool) (storage.PageID, []indexEntry, error) {
+	count := int(binary.BigEndian.Uint16(page.Data[entryCountOffset:]))
+	link := storage.PageID(binary.BigEndian.Uint32(page.Data[entryLinkOffset:]))
+	entries := make([]indexEntry, 0, count)
+	pos := entryDataOffset
+	for i := 0; i < count; i++ {
+		if pos+2 > storage.PageSize {
+			return 0, nil, fmt.Errorf("index page %d: entry %d is past the end of the page", page.ID, i)
+		}
+		keyLen := int(binary.BigEndian.Uint16(page.Data[pos:]))
+		pos += 2
+		end := pos + keyLen + 6
+		if withChild {
+			end += 4
+		}
+		if keyLen == 0 || end > storage.PageSize {
+			return 0, nil, fmt.Errorf("index page %d: entry %d has a bad key length %d", page.ID, i, keyLen)
+		}
+
+		e := indexEntry{key: append([]byte(nil), page.Data[pos:pos+keyLen]...)}
+		pos += keyLen
+		e.rid = storage.RID{
+			PageID: storage.PageID(binary.BigEndian.Uint32(page.Data[pos:])),
+			SlotID: int(binary.BigEndian.Uint16(page.Data[pos+4:])),
+		}
+		pos += 6
+		if withChild {
+			e.child = storage.PageID(binary.BigEndian.Uint32(page.Data[pos:]))
+			pos += 4
+		}
+		entries = append(entries, e)
+	}
+	return link, entries, nil
+}
+
+// encodeEntryPage replaces the page's contents. The entries must fit in
+// entryCapacity.
+func encodeEntryPage(page *storage.Page, typ storage.PageType, link storage.PageID, entries []indexEntry, withChild bool) {
+	page.Data = [storage.PageSize]byte{}
+	page.SetType(typ)
+	binary.BigEndian.PutUint16(page.Data[entryCountOffset:], uint16(len(entries)))
+	binary.BigEndian.PutUint32(page.Data[entryLinkOffset:], uint32(link))
+	pos := entryDataOffset
+	for _, e := range entries {
+		binary.BigEndian.PutUint16(page.Data[pos:], uint16(len(e.key)))
+		pos += 2
+		pos += copy(page.Data[pos:], e.key)
+		binary.BigEndian.PutUint32(page.Data[pos:], uint32(e.rid.PageID))
+		binary.BigEndian.PutUint16(page.Data[pos+4:], uint16(e.rid.SlotID))
+		pos += 6
+		if withChild {
+			binary.BigEndian.PutUint32(page.Data[pos:], uint32(e.child))
+			pos += 4
+		}
+	}
+}
+
+// compareRIDs orders RIDs by page and then slot.
+func compareRIDs(a, b storage.RID) int {
+	switch {
+	case a.PageID < b.PageID:
+		return -1
+	case a.PageID > b.PageID:
+		return 1
+	case a.SlotID < b.SlotID:
+		return -1
+	case a.SlotID > b.SlotID:
+		return 1
+	}
+	return 0
+}
+
+// clearIndexFile empties the file of an on-disk index inside the current
+// transaction. Every page is overwritten before the file is truncated, so the
+// before-images are logged and a rollback restores them. Pages are written
+// without being read, so this also works when they are corrupt.
+func clearIndexFile(pager *storage.Pager, pool *storage.BufferPool) error {
+	count, err := pager.PageCount()
+	if err != nil || count == 0 {
+		return err
+	}
+	pool.DiscardFrom(pager, 0)
+	for id := storage.PageID(0); int(id) < count; id++ {
+		if err := pager.WritePage(&storage.Page{ID: id}); err != nil {
+			return err
+		}
+	}
+	return pager.Truncate(0)
+}
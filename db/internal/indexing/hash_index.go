@@ -1,44 +1,133 @@
 package indexing
 
 import (
+	"minibank/internal/catalog"
 	"minibank/internal/storage"
 	"sync"
 )
 
+// HashIndex is an in-memory index, filled by scanning its table. It is only
+// used when a hash index has no file yet and the engine is read-only, so the
+// file cannot be built; otherwise hash indexes are LinearHash files. Such an
+// index predates INCLUDE, so its entries hold only the key.
 type HashIndex struct {
-	Items map[interface{}][]storage.RID
+	Items map[string]*hashItem // keyed by the key's encoding
+	types []catalog.ColumnType
 	mu    sync.RWMutex
 }
 
-func NewHashIndex() *HashIndex {
+type hashItem struct {
+	key  Key
+	rids []storage.RID
+}
+
+// NewHashIndex returns an empty index on columns of the given key types.
+func NewHashIndex(keyTypes []catalog.ColumnType) *HashIndex {
 	return &HashIndex{
-		Items: make(map[interface{}][]storage.RID),
+		Items: make(map[string]*hashItem),
+		types: keyTypes,
 	}
 }
 
-func (idx *HashIndex) Insert(key interface{}, rid storage.RID) {
+func (idx *HashIndex) itemKey(key Key) (string, error) {
+	k, err := encodeKey(idx.types, key)
+	return string(k), err
+}
+
+func (idx *HashIndex) Insert(key Key, rid storage.RID) error {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	idx.Items[key] = append(idx.Items[key], rid)
+	k, err := idx.itemKey(key)
+	if err != nil {
+		return err
+	}
+	item, ok := idx.Items[k]
+	if !ok {
+		item = &hashItem{key: key}
+		idx.Items[k] = item
+	}
+	item.rids = append(item.rids, rid)
+	return nil
+}
+
+func (idx *HashIndex) Get(key Key) ([]storage.RID, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	k, err := idx.itemKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if item, ok := idx.Items[k]; ok {
+		return item.rids, nil
+	}
+	return nil, nil
 }
 
-func (idx *HashIndex) Get(key interface{}) []storage.RID {
+func (idx *HashIndex) Lookup(key Key) ([]Entry, error) {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	return idx.Items[key]
+	k, err := idx.itemKey(key)
+	if err != nil {
+		return nil, err
+	}
+	item, ok := idx.Items[k]
+	if !ok {
+		return nil, nil
+	}
+	entries := make([]Entry, len(item.rids))
+	for i, rid := range item.rids {
+		entries[i] = Entry{Values: item.key, RID: rid}
+	}
+	return entries, nil
 }
 
-func (idx *HashIndex) Delete(key interface{}, rid storage.RID) {
+func (idx *HashIndex) Delete(key Key, rid storage.RID) error {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	rids := idx.Items[key]
-	for i, r := range rids {
+	k, err := idx.itemKey(key)
+	if err != nil {
+		return err
+	}
+	item, ok := idx.Items[k]
+	if !ok {
+		return nil
+	}
+	for i, r := range item.rids {
 		if r == rid {
-			idx.Items[key] = append(rids[:i], rids[i+1:]...)
-			return
+			item.rids = append(item.rids[:i], item.rids[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (idx *HashIndex) Scan(visit func(key Key, rid storage.RID) error) error {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	for _, item := range idx.Items {
+		for _, rid := range item.rids {
+			if err := visit(item.key, rid); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
+}
+
+func (idx *HashIndex) Clear() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.Items = make(map[string]*hashItem)
+	return nil
+}
+
+// Pages is always 0: the index is only kept in memory.
+func (idx *HashIndex) Pages() (int, error) {
+	return 0, nil
 }
@@ -0,0 +1,76 @@
+package indexing
+
+import "minibank/internal/storage"
+
+// Key is an index key: the values of the indexed columns, in the order of the
+// index. A value may be nil, which is how NULL cells are indexed.
+type Key []interface{}
+
+// HasNull reports whether a value of the key is NULL. Such keys never
+// conflict in a unique index.
+func (k Key) HasNull() bool {
+	for _, v := range k {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Entry is an entry of an index: the values of a tuple's indexed columns,
+// followed by those of the columns the index includes, and the tuple's RID.
+type Entry struct {
+	Values Key
+	RID    storage.RID
+}
+
+// Index maps keys to the RIDs of the tuples holding them. A covering index
+// also stores the values of some other columns (INCLUDE), which Insert,
+// Delete and Scan take after the key; they are not part of the key.
+type Index interface {
+	Insert(values Key, rid storage.RID) error
+	Delete(values Key, rid storage.RID) error
+	Get(key Key) ([]storage.RID, error)
+	// Lookup is Get, returning the values stored with each RID too.
+	Lookup(key Key) ([]Entry, error)
+	// Scan visits every entry of the index.
+	Scan(visit func(values Key, rid storage.RID) error) error
+	// Clear removes every entry, e.g. before the index is rebuilt.
+	Clear() error
+	// Pages returns the number of pages the index takes in its file.
+	Pages() (int, error)
+}
+
+// Bound is one end of a key range. Its key may hold only the leading columns
+// of the index; the others are then not compared.
+type Bound struct {
+	Key       Key
+	Inclusive bool
+}
+
+// RangeIndex is an index that keeps its keys in order. Range returns the RIDs
+// of keys between lo and hi, in key order; a nil bound leaves that end open.
+// An open lower end skips keys whose first column is NULL.
+type RangeIndex interface {
+	Index
+	Range(lo, hi *Bound) ([]storage.RID, error)
+	// RangeEntries is Range, returning the values stored with each RID too.
+	RangeEntries(lo, hi *Bound) ([]Entry, error)
+	// Cursor returns the entries of RangeEntries one at a time, reading the
+	// index only as far as the caller advances it.
+	Cursor(lo, hi *Bound) (Cursor, error)
+}
+
+// Cursor walks the entries of a key range in order. Next reports false once
+// the range is exhausted.
+type Cursor interface {
+	Next() (Entry, bool, error)
+}
+
+func entryRIDs(entries []Entry) []storage.RID {
+	var rids []storage.RID
+	for _, e := range entries {
+		rids = append(rids, e.RID)
+	}
+	return rids
+}
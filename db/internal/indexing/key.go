@@ -0,0 +1,403 @@
+package indexing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"minibank/internal/catalog"
+	"strings"
+)
+
+// KeyFormat is the version of the key encoding below. catalog.IndexDef
+// records the version an index file was built with, and an index built with
+// another one is rebuilt when it is opened.
+const KeyFormat = 1
+
+// Index values are encoded so that comparing the bytes orders them like the
+// values, and so that equal values always have the same bytes: a tag byte (0
+// for NULL, which sorts first, and 1 for a value) followed by
+//
+//   - INT and TIMESTAMP: 8 big-endian bytes with the sign bit flipped;
+//   - BOOL: one byte, 0 or 1;
+//   - STRING: the bytes of the string with each 0x00 written as 0x00 0xFF,
+//     ended by 0x00 0x01, so a string sorts before any longer one it starts;
+//   - DECIMAL: the number in normalized form, so 10.5, 10.50 and 1.05e1 are
+//     the same key. A sign byte (0 negative, 1 zero, 2 positive) is followed,
+//     for a nonzero number written as 0.d1d2...dn * 10^e with d1 and dn not
+//     zero, by e as 4 big-endian bytes with the sign bit flipped, the digits
+//     as ASCII and a 0x00. A negative number has these bytes inverted, so a
+//     larger magnitude sorts first.
+//
+// Each encoded value knows its own length, so the key of a multi-column index
+// is the concatenation of its values, and the encoding of a key prefix is a
+// byte prefix of the encoding of the key. Entries store the values of the
+// included columns after the key in the same way, except that an included
+// DECIMAL, which is never compared, keeps its text as a STRING does.
+const (
+	keyTagNull  = 0
+	keyTagValue = 1
+
+	decimalNegative = 0
+	decimalZero     = 1
+	decimalPositive = 2
+)
+
+// EncodeKey returns the canonical encoding of the values of a key (or of a
+// key prefix) of an index on columns of the given types. Two keys are equal
+// exactly when their encodings are, and bytes.Compare orders encodings the
+// way an ordered index orders the keys.
+func EncodeKey(types []catalog.ColumnType, key Key) ([]byte, error) {
+	return encodePrefix(types, key)
+}
+
+// entryTypes returns the types the values of an entry are encoded as: the key
+// types, then the include types with DECIMAL stored as its text.
+func entryTypes(keyTypes, includeTypes []catalog.ColumnType) []catalog.ColumnType {
+	types := append([]catalog.ColumnType(nil), keyTypes...)
+	for _, typ := range includeTypes {
+		if typ == catalog.TypeDecimal {
+			typ = catalog.TypeString
+		}
+		types = append(types, typ)
+	}
+	return types
+}
+
+// encodeKey encodes a whole entry of an index whose entries hold values of
+// the given types.
+func encodeKey(types []catalog.ColumnType, key Key) ([]byte, error) {
+	if len(key) != len(types) {
+		return nil, fmt.Errorf("index key has %d values, the index has %d columns", len(key), len(types))
+	}
+	return encodePrefix(types, key)
+}
+
+// encodePrefix encodes the leading columns of a key, e.g. for a range bound.
+func encodePrefix(types []catalog.ColumnType, key Key) ([]byte, error) {
+	if len(key) == 0 || len(key) > len(types) {
+		return nil, fmt.Errorf("index key has %d values, the index has %d columns", len(key), len(types))
+	}
+	var buf []byte
+	for i, v := range key {
+		b, err := encodeValue(types[i], v)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
+
+func decodeKey(types []catalog.ColumnType, k []byte) (Key, error) {
+	parts := splitKey(types, k)
+	if len(parts) != len(types) {
+		return nil, fmt.Errorf("bad index key of %d bytes", len(k))
+	}
+	key := make(Key, len(parts))
+	for i, part := range parts {
+		v, err := decodeValue(types[i], part)
+		if err != nil {
+			return nil, err
+		}
+		key[i] = v
+	}
+	return key, nil
+}
+
+// splitKey returns the encoded values of a key, or nil if it is malformed.
+func splitKey(types []catalog.ColumnType, k []byte) [][]byte {
+	var parts [][]byte
+	for len(k) > 0 {
+		if len(parts) == len(types) {
+			return nil
+		}
+		n := valueLength(types[len(parts)], k)
+		if n == 0 {
+			return nil
+		}
+		parts = append(parts, k[:n])
+		k = k[n:]
+	}
+	return parts
+}
+
+// valueLength returns the length of the encoded value k starts with, or 0 if
+// it is malformed.
+func valueLength(typ catalog.ColumnType, k []byte) int {
+	if len(k) == 0 {
+		return 0
+	}
+	if k[0] == keyTagNull {
+		return 1
+	}
+	var n int
+	switch typ {
+	case catalog.TypeInt, catalog.TypeTimestamp:
+		n = 9
+	case catalog.TypeBool:
+		n = 2
+	case catalog.TypeString:
+		for i := 1; i+1 < len(k); i++ {
+			if k[i] == 0x00 {
+				if k[i+1] == 0x01 {
+					return i + 2
+				}
+				i++
+			}
+		}
+		return 0
+	case catalog.TypeDecimal:
+		if len(k) < 2 {
+			return 0
+		}
+		if k[1] == decimalZero {
+			return 2
+		}
+		end := byte(0x00)
+		if k[1] == decimalNegative {
+			end = 0xFF
+		}
+		if len(k) < 7 {
+			return 0
+		}
+		if i := bytes.IndexByte(k[6:], end); i >= 0 {
+			return 6 + i + 1
+		}
+		return 0
+	}
+	if len(k) < n {
+		return 0
+	}
+	return n
+}
+
+// compareKeys orders two encoded keys. If one holds fewer columns, only those
+// are compared.
+func compareKeys(types []catalog.ColumnType, a, b []byte) int {
+	pa, pb := splitKey(types, a), splitKey(types, b)
+	if pa == nil || pb == nil {
+		return bytes.Compare(a, b)
+	}
+	n := min(len(pa), len(pb))
+	return bytes.Compare(keyPrefix(types, a, n), keyPrefix(types, b, n))
+}
+
+// keyPrefix returns the bytes of the first n values of an encoded key, or
+// the whole key if it holds fewer.
+func keyPrefix(types []catalog.ColumnType, k []byte, n int) []byte {
+	parts := splitKey(types, k)
+	if n >= len(parts) {
+		return k
+	}
+	end := 0
+	for _, part := range parts[:n] {
+		end += len(part)
+	}
+	return k[:end]
+}
+
+// leadingNull reports whether the first column of an encoded key is NULL.
+func leadingNull(k []byte) bool {
+	return len(k) > 0 && k[0] == keyTagNull
+}
+
+func encodeValue(typ catalog.ColumnType, v interface{}) ([]byte, error) {
+	if v == nil {
+		return []byte{keyTagNull}, nil
+	}
+	switch typ {
+	case catalog.TypeInt, catalog.TypeTimestamp:
+		var n int64
+		switch x := v.(type) {
+		case int64:
+			n = x
+		case int:
+			n = int64(x)
+		default:
+			return nil, keyTypeError(typ, v)
+		}
+		buf := make([]byte, 9)
+		buf[0] = keyTagValue
+		binary.BigEndian.PutUint64(buf[1:], uint64(n)^(1<<63))
+		return buf, nil
+	case catalog.TypeString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, keyTypeError(typ, v)
+		}
+		buf := make([]byte, 0, len(s)+3)
+		buf = append(buf, keyTagValue)
+		for i := 0; i < len(s); i++ {
+			buf = append(buf, s[i])
+			if s[i] == 0x00 {
+				buf = append(buf, 0xFF)
+			}
+		}
+		return append(buf, 0x00, 0x01), nil
+	case catalog.TypeDecimal:
+		return encodeDecimal(v)
+	case catalog.TypeBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, keyTypeError(typ, v)
+		}
+		if b {
+			return []byte{keyTagValue, 1}, nil
+		}
+		return []byte{keyTagValue, 0}, nil
+	}
+	return nil, fmt.Errorf("cannot index values of type %s", typ)
+}
+
+func decodeValue(typ catalog.ColumnType, b []byte) (interface{}, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty index value")
+	}
+	if b[0] == keyTagNull {
+		return nil, nil
+	}
+	val := b[1:]
+	switch typ {
+	case catalog.TypeInt, catalog.TypeTimestamp:
+		if len(val) != 8 {
+			return nil, fmt.Errorf("bad %s index value of %d bytes", typ, len(val))
+		}
+		return int64(binary.BigEndian.Uint64(val) ^ (1 << 63)), nil
+	case catalog.TypeString:
+		if len(val) < 2 {
+			return nil, fmt.Errorf("bad STRING index value of %d bytes", len(val))
+		}
+		return strings.ReplaceAll(string(val[:len(val)-2]), "\x00\xff", "\x00"), nil
+	case catalog.TypeDecimal:
+		return decodeDecimal(val)
+	case catalog.TypeBool:
+		if len(val) != 1 {
+			return nil, fmt.Errorf("bad BOOL index value of %d bytes", len(val))
+		}
+		return val[0] == 1, nil
+	}
+	return nil, fmt.Errorf("cannot index values of type %s", typ)
+}
+
+// maxDecimalDigits bounds the significant digits of an indexed DECIMAL; an
+// encoded key is limited to MaxKeySize bytes anyway.
+const maxDecimalDigits = MaxKeySize
+
+// encodeDecimal encodes a DECIMAL, given as its text or as an integer.
+func encodeDecimal(v interface{}) ([]byte, error) {
+	r := new(big.Rat)
+	switch x := v.(type) {
+	case string:
+		if _, ok := r.SetString(x); !ok {
+			return nil, fmt.Errorf("index key %q is not a DECIMAL", x)
+		}
+	case int64:
+		r.SetInt64(x)
+	case int:
+		r.SetInt64(int64(x))
+	default:
+		return nil, keyTypeError(catalog.TypeDecimal, v)
+	}
+	if r.Sign() == 0 {
+		return []byte{keyTagValue, decimalZero}, nil
+	}
+
+	// Scale the number to an integer by a power of ten. That only works if
+	// its denominator has no prime factors but 2 and 5.
+	den := new(big.Int).Set(r.Denom())
+	scale := 0
+	for _, p := range []int64{2, 5} {
+		n := 0
+		for m := new(big.Int); ; n++ {
+			q, rem := new(big.Int).QuoRem(den, big.NewInt(p), m)
+			if rem.Sign() != 0 {
+				break
+			}
+			den = q
+		}
+		scale = max(scale, n)
+	}
+	if den.Cmp(big.NewInt(1)) != 0 || scale > maxDecimalDigits {
+		return nil, fmt.Errorf("index key %v is not a DECIMAL", v)
+	}
+	n := new(big.Int).Mul(r.Num(), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+	n.Quo(n, r.Denom())
+	digits := n.Abs(n).String()
+	exp := len(digits) - scale
+	digits = strings.TrimRight(digits, "0")
+	if len(digits) > maxDecimalDigits {
+		return nil, fmt.Errorf("index key %v has more than %d digits", v, maxDecimalDigits)
+	}
+
+	buf := make([]byte, 0, 7+len(digits))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(int32(exp))^(1<<31))
+	buf = append(buf, digits...)
+	buf = append(buf, 0x00)
+	sign := byte(decimalPositive)
+	if r.Sign() < 0 {
+		sign = decimalNegative
+		for i := range buf {
+			buf[i] = ^buf[i]
+		}
+	}
+	return append([]byte{keyTagValue, sign}, buf...), nil
+}
+
+// decodeDecimal returns the normalized text of an encoded DECIMAL, such as
+// "10.5" for a key inserted as "10.50".
+func decodeDecimal(val []byte) (string, error) {
+	if len(val) == 1 && val[0] == decimalZero {
+		return "0", nil
+	}
+	if len(val) < 6 || (val[0] != decimalNegative && val[0] != decimalPositive) {
+		return "", fmt.Errorf("bad DECIMAL index value of %d bytes", len(val))
+	}
+	body := append([]byte(nil), val[1:]...)
+	if val[0] == decimalNegative {
+		for i := range body {
+			body[i] = ^body[i]
+		}
+	}
+	exp := int(int32(binary.BigEndian.Uint32(body) ^ (1 << 31)))
+	digits := string(body[4 : len(body)-1])
+
+	var s string
+	switch {
+	case exp <= 0:
+		s = "0." + strings.Repeat("0", -exp) + digits
+	case exp >= len(digits):
+		s = digits + strings.Repeat("0", exp-len(digits))
+	default:
+		s = digits[:exp] + "." + digits[exp:]
+	}
+	if val[0] == decimalNegative {
+		s = "-" + s
+	}
+	return s, nil
+}
+
+// ValidKey reports whether v can be used as a key of an index on a column of
+// type typ.
+func ValidKey(typ catalog.ColumnType, v interface{}) bool {
+	_, err := encodeValue(typ, v)
+	return err == nil
+}
+
+// CompareValues orders two values of a column of type typ the way an ordered
+// index does.
+func CompareValues(typ catalog.ColumnType, a, b interface{}) (int, error) {
+	ka, err := encodeValue(typ, a)
+	if err != nil {
+		return 0, err
+	}
+	kb, err := encodeValue(typ, b)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Compare(ka, kb), nil
+}
+
+func keyTypeError(typ catalog.ColumnType, v interface{}) error {
+	return fmt.Errorf("index key %v (%T) does not match column type %s", v, v, typ)
+}
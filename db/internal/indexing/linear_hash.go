@@ -0,0 +1,507 @@
+package indexing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"minibank/internal/catalog"
+	"minibank/internal/storage"
+	"sync"
+)
+
+// LinearHash is a hash index stored in its own file (<table>.<index>.idx).
+// It uses linear hashing, so it grows one bucket at a time and never has to
+// be rebuilt:
+//
+//	meta page 0:    [level uint32][split uint32][used bytes uint64]
+//	                [directory page count uint32][directory page ids uint32...]
+//	directory page: [bucket page uint32]...
+//	bucket page:    entry page layout (see entry_page.go); link is the next
+//	                overflow page of the bucket
+//
+// An entry holds the values of the indexed columns followed by those of the
+// included columns; only the indexed columns are hashed, in their canonical
+// encoding (see key.go), so equal keys always share a bucket.
+//
+// There are 2^level + split buckets. A key whose hash is h lives in bucket
+// h mod 2^level, or h mod 2^(level+1) when that bucket was already split in
+// this round (it is below split). Once the entries take up more than
+// hashFillPercent of the buckets' primary pages, bucket split is split: its
+// entries are divided between it and the new bucket 2^level + split.
+//
+// Like the B+tree, its pages go through the buffer pool and the WAL.
+type LinearHash struct {
+	pager   *storage.Pager
+	pool    *storage.BufferPool
+	types   []catalog.ColumnType // key columns, then included columns
+	keyCols int
+	mu      sync.RWMutex
+}
+
+const (
+	hashLevelOffset    = storage.PageMetaSize
+	hashSplitOffset    = storage.PageMetaSize + 4
+	hashUsedOffset     = storage.PageMetaSize + 8
+	hashDirCountOffset = storage.PageMetaSize + 16
+	hashDirsOffset     = storage.PageMetaSize + 20
+
+	hashMaxDirPages = (storage.PageSize - hashDirsOffset) / 4
+	hashDirEntries  = (storage.PageSize - storage.PageMetaSize) / 4
+	hashFillPercent = 75
+	hashMetaPage    = storage.PageID(0)
+	hashNoPage      = storage.PageID(0) // page 0 is the meta page, never a bucket
+	hashMaxLevel    = 31
+)
+
+type hashMeta struct {
+	level uint32
+	split uint32
+	used  uint64 // bytes taken by all entries
+	dirs  []storage.PageID
+}
+
+func (m *hashMeta) buckets() uint32 {
+	return 1<<m.level + m.split
+}
+
+func (m *hashMeta) bucketOf(h uint64) uint32 {
+	b := uint32(h & (1<<m.level - 1))
+	if b < m.split {
+		b = uint32(h & (1<<(m.level+1) - 1))
+	}
+	return b
+}
+
+type hashPage struct {
+	id      storage.PageID
+	link    storage.PageID
+	entries []indexEntry
+}
+
+// NewLinearHash returns the hash index stored in pager, whose keys are the
+// values of columns of the given key types and whose entries also hold the
+// values of columns of the include types. An empty file is an empty index;
+// its pages are created by the first insert.
+func NewLinearHash(pager *storage.Pager, pool *storage.BufferPool, keyTypes, includeTypes []catalog.ColumnType) *LinearHash {
+	return &LinearHash{pager: pager, pool: pool, types: entryTypes(keyTypes, includeTypes), keyCols: len(keyTypes)}
+}
+
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
+
+// key returns the key columns of an encoded entry.
+func (h *LinearHash) key(entry []byte) []byte {
+	return keyPrefix(h.types, entry, h.keyCols)
+}
+
+func (h *LinearHash) Insert(values Key, rid storage.RID) error {
+	k, err := encodeKey(h.types, values)
+	if err != nil {
+		return err
+	}
+	if err := checkKeySize(k); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	m, err := h.readMeta()
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		if m, err = h.create(); err != nil {
+			return err
+		}
+	}
+
+	e := indexEntry{key: k, rid: rid}
+	chain, err := h.bucketChain(m, h.key(k))
+	if err != nil {
+		return err
+	}
+	var target *hashPage
+	for _, p := range chain {
+		for _, existing := range p.entries {
+			if existing.rid == rid && bytes.Equal(existing.key, k) {
+				return nil // already indexed
+			}
+		}
+		if target == nil && entriesSize(p.entries, false)+entrySize(e, false) <= entryCapacity {
+			target = p
+		}
+	}
+	if target == nil {
+		last := chain[len(chain)-1]
+		target = &hashPage{id: h.pager.AllocatePage()}
+		last.link = target.id
+		if err := h.writeBucket(last); err != nil {
+			return err
+		}
+	}
+	target.entries = append(target.entries, e)
+	if err := h.writeBucket(target); err != nil {
+		return err
+	}
+
+	m.used += uint64(entrySize(e, false))
+	if m.used*100 > uint64(m.buckets())*entryCapacity*hashFillPercent && m.level < hashMaxLevel {
+		if err := h.split(m); err != nil {
+			return err
+		}
+	}
+	return h.writeMeta(m)
+}
+
+// split divides the entries of bucket m.split between it and a new bucket.
+func (h *LinearHash) split(m *hashMeta) error {
+	old := m.split
+	pid, err := h.bucketPage(m, old)
+	if err != nil {
+		return err
+	}
+	chain, err := h.readChain(pid)
+	if err != nil {
+		return err
+	}
+
+	mask := uint64(1)<<(m.level+1) - 1
+	var stay, move []indexEntry
+	for _, p := range chain {
+		for _, e := range p.entries {
+			if uint32(hashKey(h.key(e.key))&mask) == old {
+				stay = append(stay, e)
+			} else {
+				move = append(move, e)
+			}
+		}
+	}
+
+	newPid := h.pager.AllocatePage()
+	if err := h.setBucketPage(m, old+1<<m.level, newPid); err != nil {
+		return err
+	}
+	ids := make([]storage.PageID, len(chain))
+	for i, p := range chain {
+		ids[i] = p.id
+	}
+	if err := h.writeChain(ids, stay); err != nil {
+		return err
+	}
+	if err := h.writeChain([]storage.PageID{newPid}, move); err != nil {
+		return err
+	}
+
+	m.split++
+	if m.split == 1<<m.level {
+		m.level++
+		m.split = 0
+	}
+	return nil
+}
+
+// writeChain stores entries in the pages ids, in order, allocating more pages
+// as needed. Pages left over stay in the chain, empty, for later inserts.
+func (h *LinearHash) writeChain(ids []storage.PageID, entries []indexEntry) error {
+	var pages []*hashPage
+	cur := &hashPage{id: ids[0]}
+	pages = append(pages, cur)
+	for _, e := range entries {
+		if entriesSize(cur.entries, false)+entrySize(e, false) > entryCapacity {
+			next := &hashPage{}
+			if len(pages) < len(ids) {
+				next.id = ids[len(pages)]
+			} else {
+				next.id = h.pager.AllocatePage()
+			}
+			pages = append(pages, next)
+			cur = next
+		}
+		cur.entries = append(cur.entries, e)
+	}
+	for len(pages) < len(ids) {
+		pages = append(pages, &hashPage{id: ids[len(pages)]})
+	}
+	for i, p := range pages {
+		if i+1 < len(pages) {
+			p.link = pages[i+1].id
+		}
+		if err := h.writeBucket(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *LinearHash) Delete(values Key, rid storage.RID) error {
+	k, err := encodeKey(h.types, values)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	m, err := h.readMeta()
+	if err != nil || m == nil {
+		return err
+	}
+	chain, err := h.bucketChain(m, h.key(k))
+	if err != nil {
+		return err
+	}
+	for _, p := range chain {
+		for i, e := range p.entries {
+			if e.rid != rid || !bytes.Equal(e.key, k) {
+				continue
+			}
+			p.entries = append(p.entries[:i], p.entries[i+1:]...)
+			if err := h.writeBucket(p); err != nil {
+				return err
+			}
+			m.used -= uint64(entrySize(e, false))
+			return h.writeMeta(m)
+		}
+	}
+	return nil
+}
+
+func (h *LinearHash) Get(key Key) ([]storage.RID, error) {
+	entries, err := h.Lookup(key)
+	return entryRIDs(entries), err
+}
+
+func (h *LinearHash) Lookup(key Key) ([]Entry, error) {
+	if len(key) != h.keyCols {
+		return nil, fmt.Errorf("index key has %d values, the index has %d columns", len(key), h.keyCols)
+	}
+	k, err := encodePrefix(h.types, key)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	m, err := h.readMeta()
+	if err != nil || m == nil {
+		return nil, err
+	}
+	chain, err := h.bucketChain(m, k)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, p := range chain {
+		for _, e := range p.entries {
+			if !bytes.Equal(h.key(e.key), k) {
+				continue
+			}
+			values, err := decodeKey(h.types, e.key)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, Entry{Values: values, RID: e.rid})
+		}
+	}
+	return entries, nil
+}
+
+func (h *LinearHash) Scan(visit func(values Key, rid storage.RID) error) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	m, err := h.readMeta()
+	if err != nil || m == nil {
+		return err
+	}
+	for b := uint32(0); b < m.buckets(); b++ {
+		pid, err := h.bucketPage(m, b)
+		if err != nil {
+			return err
+		}
+		chain, err := h.readChain(pid)
+		if err != nil {
+			return err
+		}
+		for _, p := range chain {
+			for _, e := range p.entries {
+				values, err := decodeKey(h.types, e.key)
+				if err != nil {
+					return err
+				}
+				if err := visit(values, e.rid); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Clear removes every entry, e.g. before the index is rebuilt.
+func (h *LinearHash) Clear() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return clearIndexFile(h.pager, h.pool)
+}
+
+func (h *LinearHash) Pages() (int, error) {
+	return h.pager.PageCount()
+}
+
+func (h *LinearHash) bucketChain(m *hashMeta, key []byte) ([]*hashPage, error) {
+	pid, err := h.bucketPage(m, m.bucketOf(hashKey(key)))
+	if err != nil {
+		return nil, err
+	}
+	return h.readChain(pid)
+}
+
+func (h *LinearHash) readChain(pid storage.PageID) ([]*hashPage, error) {
+	count, err := h.pager.PageCount()
+	if err != nil {
+		return nil, err
+	}
+	var chain []*hashPage
+	for pid != hashNoPage {
+		if len(chain) > count {
+			return nil, fmt.Errorf("hash bucket chain at page %d loops", chain[0].id)
+		}
+		page, err := h.pool.FetchPage(h.pager, pid)
+		if err != nil {
+			return nil, err
+		}
+		if page.Type() != storage.PageTypeHashBucket {
+			h.pool.UnpinPage(h.pager, pid, false)
+			return nil, fmt.Errorf("hash page %d is a %s page, expected a bucket", pid, page.Type())
+		}
+		link, entries, err := decodeEntryPage(page, false)
+		h.pool.UnpinPage(h.pager, pid, false)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, &hashPage{id: pid, link: link, entries: entries})
+		pid = link
+	}
+	return chain, nil
+}
+
+func (h *LinearHash) writeBucket(p *hashPage) error {
+	page, err := h.pool.FetchPage(h.pager, p.id)
+	if err != nil {
+		return err
+	}
+	encodeEntryPage(page, storage.PageTypeHashBucket, p.link, p.entries, false)
+	h.pool.UnpinPage(h.pager, p.id, true)
+	return nil
+}
+
+func (h *LinearHash) bucketPage(m *hashMeta, b uint32) (storage.PageID, error) {
+	d := int(b) / hashDirEntries
+	if d >= len(m.dirs) {
+		return hashNoPage, fmt.Errorf("hash bucket %d is past the directory", b)
+	}
+	page, err := h.pool.FetchPage(h.pager, m.dirs[d])
+	if err != nil {
+		return hashNoPage, err
+	}
+	defer h.pool.UnpinPage(h.pager, m.dirs[d], false)
+	pos := storage.PageMetaSize + (int(b)%hashDirEntries)*4
+	pid := storage.PageID(binary.BigEndian.Uint32(page.Data[pos:]))
+	if pid == hashNoPage {
+		return hashNoPage, fmt.Errorf("hash bucket %d has no page", b)
+	}
+	return pid, nil
+}
+
+func (h *LinearHash) setBucketPage(m *hashMeta, b uint32, pid storage.PageID) error {
+	d := int(b) / hashDirEntries
+	if d == len(m.dirs) {
+		if d == hashMaxDirPages {
+			return fmt.Errorf("hash index directory is full")
+		}
+		m.dirs = append(m.dirs, h.pager.AllocatePage())
+	}
+	page, err := h.pool.FetchPage(h.pager, m.dirs[d])
+	if err != nil {
+		return err
+	}
+	page.SetType(storage.PageTypeHashDirectory)
+	binary.BigEndian.PutUint32(page.Data[storage.PageMetaSize+(int(b)%hashDirEntries)*4:], uint32(pid))
+	h.pool.UnpinPage(h.pager, m.dirs[d], true)
+	return nil
+}
+
+// readMeta returns nil while the index has no pages yet.
+func (h *LinearHash) readMeta() (*hashMeta, error) {
+	count, err := h.pager.PageCount()
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	page, err := h.pool.FetchPage(h.pager, hashMetaPage)
+	if err != nil {
+		return nil, err
+	}
+	defer h.pool.UnpinPage(h.pager, hashMetaPage, false)
+	if page.Type() != storage.PageTypeHashMeta {
+		return nil, nil // allocated by a statement that rolled back
+	}
+
+	m := &hashMeta{
+		level: binary.BigEndian.Uint32(page.Data[hashLevelOffset:]),
+		split: binary.BigEndian.Uint32(page.Data[hashSplitOffset:]),
+		used:  binary.BigEndian.Uint64(page.Data[hashUsedOffset:]),
+	}
+	n := int(binary.BigEndian.Uint32(page.Data[hashDirCountOffset:]))
+	if n > hashMaxDirPages {
+		return nil, fmt.Errorf("hash meta page lists %d directory pages", n)
+	}
+	for i := 0; i < n; i++ {
+		m.dirs = append(m.dirs, storage.PageID(binary.BigEndian.Uint32(page.Data[hashDirsOffset+i*4:])))
+	}
+	return m, nil
+}
+
+func (h *LinearHash) writeMeta(m *hashMeta) error {
+	page, err := h.pool.FetchPage(h.pager, hashMetaPage)
+	if err != nil {
+		return err
+	}
+	page.Data = [storage.PageSize]byte{}
+	page.SetType(storage.PageTypeHashMeta)
+	binary.BigEndian.PutUint32(page.Data[hashLevelOffset:], m.level)
+	binary.BigEndian.PutUint32(page.Data[hashSplitOffset:], m.split)
+	binary.BigEndian.PutUint64(page.Data[hashUsedOffset:], m.used)
+	binary.BigEndian.PutUint32(page.Data[hashDirCountOffset:], uint32(len(m.dirs)))
+	for i, d := range m.dirs {
+		binary.BigEndian.PutUint32(page.Data[hashDirsOffset+i*4:], uint32(d))
+	}
+	h.pool.UnpinPage(h.pager, hashMetaPage, true)
+	return nil
+}
+
+// create writes the meta page, the first directory page and bucket 0.
+func (h *LinearHash) create() (*hashMeta, error) {
+	count, err := h.pager.PageCount()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		h.pager.AllocatePage() // meta page
+	}
+	m := &hashMeta{}
+	bucket := &hashPage{id: h.pager.AllocatePage()}
+	if err := h.writeBucket(bucket); err != nil {
+		return nil, err
+	}
+	if err := h.setBucketPage(m, 0, bucket.id); err != nil {
+		return nil, err
+	}
+	return m, h.writeMeta(m)
+}
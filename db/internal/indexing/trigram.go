@@ -0,0 +1,223 @@
+package indexing
+
+import (
+	"fmt"
+	"math"
+	"minibank/internal/catalog"
+	"minibank/internal/storage"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MatchThreshold is the share of the trigrams of a MATCH query that a value
+// must contain for MATCH to hold.
+const MatchThreshold = 0.5
+
+// Trigrams returns the distinct trigrams of s, in order. As in PostgreSQL's
+// pg_trgm, s is lower-cased and split into words of letters and digits, and
+// each word is padded with two spaces in front and one behind, so "Bob" has
+// the trigrams "  b", " bo", "bob" and "ob ".
+func Trigrams(s string) []string {
+	set := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), notWordRune) {
+		addTrigrams(set, "  "+word+" ")
+	}
+	return sortedSet(set)
+}
+
+// LikeTrigrams returns trigrams that every value matching a LIKE pattern
+// contains, as Trigrams computes them, or none if the pattern's literal parts
+// are too short. A run of letters and digits only gets the padding of a word
+// boundary where the pattern fixes one: next to another character, or at an
+// end of the pattern that is not a wildcard.
+func LikeTrigrams(pattern string) []string {
+	set := make(map[string]bool)
+	runes := []rune(strings.ToLower(pattern))
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && isWordRune(runes[j]) {
+			j++
+		}
+		run := string(runes[i:j])
+		if i == 0 || !isWildcard(runes[i-1]) {
+			run = "  " + run
+		}
+		if j == len(runes) || !isWildcard(runes[j]) {
+			run += " "
+		}
+		addTrigrams(set, run)
+		i = j
+	}
+	return sortedSet(set)
+}
+
+// Similarity returns the share of query's trigrams that value contains, the
+// measure MATCH compares with MatchThreshold. A query without trigrams
+// matches nothing.
+func Similarity(value, query string) float64 {
+	want := Trigrams(query)
+	if len(want) == 0 {
+		return 0
+	}
+	have := make(map[string]bool)
+	for _, t := range Trigrams(value) {
+		have[t] = true
+	}
+	found := 0
+	for _, t := range want {
+		if have[t] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(want))
+}
+
+// MatchMinimum returns how many of a query's n trigrams a value must contain
+// to match it.
+func MatchMinimum(n int) int {
+	return int(math.Ceil(MatchThreshold * float64(n)))
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func notWordRune(r rune) bool {
+	return !isWordRune(r)
+}
+
+func isWildcard(r rune) bool {
+	return r == '%' || r == '_'
+}
+
+func addTrigrams(set map[string]bool, s string) {
+	runes := []rune(s)
+	for i := 0; i+3 <= len(runes); i++ {
+		set[string(runes[i:i+3])] = true
+	}
+}
+
+func sortedSet(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for s := range set {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// TrigramIndex is an inverted index of the trigrams of a STRING column (or
+// expression), for LIKE '%...%' and MATCH. It is a B+tree of (trigram, RID)
+// entries in its own file, so it is logged and rolls back like the other
+// index types. Insert and Delete take the column's value and add or remove
+// an entry for each of its trigrams; a NULL has none.
+//
+// Get returns the RIDs of the values that hold every trigram of the key's
+// value, a superset of those equal to it; Search is the general form. Scan
+// visits the (trigram, RID) entries. The values themselves are not stored,
+// so Lookup is not supported.
+type TrigramIndex struct {
+	tree *BTree
+}
+
+// NewTrigramIndex returns the trigram index stored in pager.
+func NewTrigramIndex(pager *storage.Pager, pool *storage.BufferPool) *TrigramIndex {
+	return &TrigramIndex{tree: NewBTree(pager, pool, []catalog.ColumnType{catalog.TypeString}, nil)}
+}
+
+func (idx *TrigramIndex) Insert(values Key, rid storage.RID) error {
+	trigrams, err := valueTrigrams(values)
+	if err != nil {
+		return err
+	}
+	for _, t := range trigrams {
+		if err := idx.tree.Insert(Key{t}, rid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *TrigramIndex) Delete(values Key, rid storage.RID) error {
+	trigrams, err := valueTrigrams(values)
+	if err != nil {
+		return err
+	}
+	for _, t := range trigrams {
+		if err := idx.tree.Delete(Key{t}, rid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *TrigramIndex) Get(key Key) ([]storage.RID, error) {
+	trigrams, err := valueTrigrams(key)
+	if err != nil {
+		return nil, err
+	}
+	return idx.Search(trigrams, len(trigrams))
+}
+
+func (idx *TrigramIndex) Lookup(key Key) ([]Entry, error) {
+	return nil, fmt.Errorf("a trigram index does not store values")
+}
+
+// Search returns the RIDs that have entries for at least min of trigrams, in
+// RID order. Without trigrams it returns none.
+func (idx *TrigramIndex) Search(trigrams []string, min int) ([]storage.RID, error) {
+	counts := make(map[storage.RID]int)
+	for _, t := range trigrams {
+		rids, err := idx.tree.Get(Key{t})
+		if err != nil {
+			return nil, err
+		}
+		for _, rid := range rids {
+			counts[rid]++
+		}
+	}
+	var rids []storage.RID
+	for rid, n := range counts {
+		if n >= min {
+			rids = append(rids, rid)
+		}
+	}
+	sort.Slice(rids, func(i, j int) bool {
+		if rids[i].PageID != rids[j].PageID {
+			return rids[i].PageID < rids[j].PageID
+		}
+		return rids[i].SlotID < rids[j].SlotID
+	})
+	return rids, nil
+}
+
+func (idx *TrigramIndex) Scan(visit func(values Key, rid storage.RID) error) error {
+	return idx.tree.Scan(visit)
+}
+
+func (idx *TrigramIndex) Clear() error {
+	return idx.tree.Clear()
+}
+
+func (idx *TrigramIndex) Pages() (int, error) {
+	return idx.tree.Pages()
+}
+
+func valueTrigrams(values Key) ([]string, error) {
+	if len(values) != 1 {
+		return nil, fmt.Errorf("trigram index entry has %d values, expected 1", len(values))
+	}
+	if values[0] == nil {
+		return nil, nil
+	}
+	s, ok := values[0].(string)
+	if !ok {
+		return nil, keyTypeError(catalog.TypeString, values[0])
+	}
+	return Trigrams(s), nil
+}
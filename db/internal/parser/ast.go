@@ -11,8 +11,28 @@ const (
 	NodeUpdate
 	NodeDelete
 	NodeCreateIndex
+	NodeVacuum
+	NodeDropIndex
+	NodeReindex
+	NodeShowIndexes
 )
 
+// ReadOnly reports whether a statement only reads, so that it can run on a
+// read-only engine.
+func ReadOnly(n ASTNode) bool {
+	return n.Type() == NodeSelect || n.Type() == NodeShowIndexes
+}
+
+// IsDDL reports whether a statement changes the catalog, so that the
+// in-memory catalog must be reloaded if it rolls back.
+func IsDDL(n ASTNode) bool {
+	switch n.Type() {
+	case NodeCreateTable, NodeCreateIndex, NodeDropIndex:
+		return true
+	}
+	return false
+}
+
 type RawNumber string
 
 type ASTNode interface {
@@ -20,8 +40,31 @@ type ASTNode interface {
 }
 
 type CreateTableStmt struct {
-	TableName string
-	Columns   []catalog.Column
+	TableName   string
+	Columns     []catalog.Column // a DEFAULT is kept as SQL, as FormatExpr writes it
+	PrimaryKey  []string         // from a column's PRIMARY KEY or a PRIMARY KEY (...) constraint
+	Checks      []CheckConstraint
+	ForeignKeys []ForeignKeyConstraint
+}
+
+// CheckConstraint is a CHECK constraint of CREATE TABLE. Name is empty unless
+// CONSTRAINT gave one; Column is set for a constraint written on a column.
+type CheckConstraint struct {
+	Name   string
+	Column string
+	Expr   Expression
+}
+
+// ForeignKeyConstraint is a REFERENCES or FOREIGN KEY constraint of CREATE
+// TABLE. RefColumns is empty when it references the primary key, and
+// OnDelete and OnUpdate are empty unless given.
+type ForeignKeyConstraint struct {
+	Name       string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnDelete   string
+	OnUpdate   string
 }
 
 func (n *CreateTableStmt) Type() NodeType { return NodeCreateTable }
@@ -34,11 +77,24 @@ type InsertStmt struct {
 
 func (n *InsertStmt) Type() NodeType { return NodeInsert }
 
+// SelectStmt is a SELECT. Fields are the selected expressions, usually
+// column names or aggregates; they are nil for SELECT *.
 type SelectStmt struct {
 	TableName string
-	Fields    []string
+	Fields    []Expression
 	Where     *WhereClause
 	Join      *JoinClause
+	GroupBy   []Expression
+	Having    Expression // nil without HAVING
+	OrderBy   []OrderByItem
+	Limit     *int // nil without LIMIT
+	Offset    int
+}
+
+// OrderByItem is one expression of ORDER BY.
+type OrderByItem struct {
+	Expr Expression
+	Desc bool
 }
 
 func (n *SelectStmt) Type() NodeType { return NodeSelect }
@@ -61,11 +117,52 @@ func (n *DeleteStmt) Type() NodeType { return NodeDelete }
 type CreateIndexStmt struct {
 	IndexName string
 	TableName string
-	Column    string
+	Columns   []string   // key columns or expressions, as SQL text (see FormatExpr)
+	Include   []string   // stored in the index but not part of its key
+	Where     Expression // the predicate of a partial index; nil for all rows
+	Using     string     // a catalog.IndexType; empty for the default
+	Unique    bool
 }
 
 func (n *CreateIndexStmt) Type() NodeType { return NodeCreateIndex }
 
+// VacuumStmt compacts one table, or every user table when TableName is empty.
+type VacuumStmt struct {
+	TableName string
+}
+
+func (n *VacuumStmt) Type() NodeType { return NodeVacuum }
+
+// IndexRef names an index as name or table.name. Index names only need to
+// be unique within their table.
+type IndexRef struct {
+	TableName string // empty when not qualified
+	IndexName string
+}
+
+type DropIndexStmt struct {
+	Index IndexRef
+}
+
+func (n *DropIndexStmt) Type() NodeType { return NodeDropIndex }
+
+// ReindexStmt rebuilds every index of TableName, or the one index named by
+// Index.
+type ReindexStmt struct {
+	TableName string
+	Index     *IndexRef
+}
+
+func (n *ReindexStmt) Type() NodeType { return NodeReindex }
+
+// ShowIndexesStmt lists the indexes of one table, or of every table when
+// TableName is empty.
+type ShowIndexesStmt struct {
+	TableName string
+}
+
+func (n *ShowIndexesStmt) Type() NodeType { return NodeShowIndexes }
+
 type WhereClause struct {
 	Left  string
 	Op    Operator
@@ -84,6 +181,9 @@ const (
 	ExprBinary ExprType = iota
 	ExprLiteral
 	ExprIdentifier
+	ExprIsNull
+	ExprFuncCall
+	ExprAggregate
 )
 
 type Expression interface {
@@ -93,14 +193,15 @@ type Expression interface {
 type Operator string
 
 const (
-	OpEq  Operator = "="
-	OpNeq Operator = "!="
-	OpLt  Operator = "<"
-	OpGte Operator = ">="
-	OpGt  Operator = ">"
-	OpLte Operator = "<="
-	OpAnd Operator = "AND"
-	OpOr  Operator = "OR"
+	OpEq   Operator = "="
+	OpNeq  Operator = "!="
+	OpLt   Operator = "<"
+	OpGte  Operator = ">="
+	OpGt   Operator = ">"
+	OpLte  Operator = "<="
+	OpLike Operator = "LIKE" // % matches any run of characters, _ any one
+	OpAnd  Operator = "AND"
+	OpOr   Operator = "OR"
 )
 
 type BinaryExpr struct {
@@ -111,6 +212,7 @@ type BinaryExpr struct {
 
 func (b *BinaryExpr) ExprType() ExprType { return ExprBinary }
 
+// LiteralExpr is a constant. A nil Value is the NULL literal.
 type LiteralExpr struct {
 	Value interface{}
 }
@@ -122,3 +224,33 @@ type IdentifierExpr struct {
 }
 
 func (i *IdentifierExpr) ExprType() ExprType { return ExprIdentifier }
+
+// IsNullExpr is `Expr IS NULL`, or `Expr IS NOT NULL` when Not is set.
+type IsNullExpr struct {
+	Expr Expression
+	Not  bool
+}
+
+func (i *IsNullExpr) ExprType() ExprType { return ExprIsNull }
+
+// FuncCallExpr calls a built-in function such as LOWER. Name is upper case.
+type FuncCallExpr struct {
+	Name string
+	Args []Expression
+}
+
+func (f *FuncCallExpr) ExprType() ExprType { return ExprFuncCall }
+
+// AggregateExpr calls an aggregate function: COUNT, SUM, AVG, MIN or MAX.
+// Name is upper case, and Arg is nil for COUNT(*). With Distinct, the
+// function only sees each distinct value of Arg once.
+type AggregateExpr struct {
+	Name     string
+	Arg      Expression
+	Distinct bool
+}
+
+func (a *AggregateExpr) ExprType() ExprType { return ExprAggregate }
+
+// Aggregates are the names of the aggregate functions.
+var Aggregates = map[string]bool{"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true}
@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FormatExpr writes an expression back as SQL that ParseExpr reads into the
+// same expression. The catalog stores index expressions and predicates this
+// way.
+func FormatExpr(expr Expression) string {
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		// AND and OR group from the left with equal precedence, so only a
+		// right operand, or an operand of a comparison, needs parentheses.
+		left := FormatExpr(e.Left)
+		if e.Op != OpAnd && e.Op != OpOr {
+			left = operand(e.Left)
+		}
+		return left + " " + string(e.Op) + " " + operand(e.Right)
+	case *IsNullExpr:
+		if e.Not {
+			return operand(e.Expr) + " IS NOT NULL"
+		}
+		return operand(e.Expr) + " IS NULL"
+	case *IdentifierExpr:
+		return e.Name
+	case *FuncCallExpr:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = FormatExpr(arg)
+		}
+		return e.Name + "(" + strings.Join(args, ", ") + ")"
+	case *AggregateExpr:
+		switch {
+		case e.Arg == nil:
+			return e.Name + "(*)"
+		case e.Distinct:
+			return e.Name + "(DISTINCT " + FormatExpr(e.Arg) + ")"
+		}
+		return e.Name + "(" + FormatExpr(e.Arg) + ")"
+	case *LiteralExpr:
+		switch v := e.Value.(type) {
+		case nil:
+			return "NULL"
+		case string:
+			return "'" + v + "'"
+		case RawNumber:
+			return string(v)
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	return fmt.Sprintf("<%T>", expr)
+}
+
+func operand(expr Expression) string {
+	if _, ok := expr.(*BinaryExpr); ok {
+		return "(" + FormatExpr(expr) + ")"
+	}
+	return FormatExpr(expr)
+}
+
+// ParseExpr parses an expression on its own, such as one written by
+// FormatExpr.
+func ParseExpr(sql string) (Expression, error) {
+	p := NewParser(NewLexer(sql))
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if p.curToken.Type != TokenEOF {
+		return nil, fmt.Errorf("unexpected token after expression: %v", p.curToken)
+	}
+	return expr, nil
+}
@@ -116,7 +116,13 @@ func isKeyword(val string) bool {
 		"DELETE": true, "CREATE": true, "TABLE": true, "INDEX": true,
 		"ON": true, "JOIN": true, "AND": true, "OR": true,
 		"INT": true, "STRING": true, "DECIMAL": true, "BOOL": true, "TIMESTAMP": true,
-		"PRIMARY": true, "KEY": true, "UNIQUE": true,
+		"PRIMARY": true, "KEY": true, "UNIQUE": true, "VACUUM": true,
+		"NULL": true, "IS": true, "NOT": true, "USING": true, "BETWEEN": true,
+		"INCLUDE": true, "DROP": true, "REINDEX": true, "SHOW": true, "INDEXES": true,
+		"LIKE": true, "DEFAULT": true, "CHECK": true, "CONSTRAINT": true,
+		"FOREIGN": true, "REFERENCES": true, "CASCADE": true, "RESTRICT": true,
+		"ORDER": true, "BY": true, "ASC": true, "DESC": true, "LIMIT": true, "OFFSET": true,
+		"GROUP": true, "HAVING": true, "DISTINCT": true,
 	}
 	return keywords[strings.ToUpper(val)]
 }
@@ -3,6 +3,8 @@ package parser
 import (
 	"fmt"
 	"minibank/internal/catalog"
+	"strconv"
+	"strings"
 )
 
 type Parser struct {
@@ -37,6 +39,14 @@ func (p *Parser) Parse() (ASTNode, error) {
 			return p.parseUpdate()
 		case "DELETE":
 			return p.parseDelete()
+		case "VACUUM":
+			return p.parseVacuum()
+		case "DROP":
+			return p.parseDrop()
+		case "REINDEX":
+			return p.parseReindex()
+		case "SHOW":
+			return p.parseShowIndexes()
 		default:
 			return nil, fmt.Errorf("unexpected token: %v", p.curToken)
 		}
@@ -45,14 +55,20 @@ func (p *Parser) Parse() (ASTNode, error) {
 	}
 }
 
-// CREATE TABLE or CREATE INDEX
+// CREATE TABLE or CREATE [UNIQUE] INDEX
 func (p *Parser) parseCreate() (ASTNode, error) {
 	p.nextToken() // skip CREATE
 	switch p.curToken.Value {
 	case "TABLE":
 		return p.parseCreateTable()
 	case "INDEX":
-		return p.parseCreateIndex()
+		return p.parseCreateIndex(false)
+	case "UNIQUE":
+		p.nextToken()
+		if p.curToken.Value != "INDEX" {
+			return nil, fmt.Errorf("expected INDEX after CREATE UNIQUE")
+		}
+		return p.parseCreateIndex(true)
 	default:
 		return nil, fmt.Errorf("expected TABLE or INDEX after CREATE")
 	}
@@ -72,7 +88,68 @@ func (p *Parser) parseCreateTable() (*CreateTableStmt, error) {
 	p.nextToken()
 
 	stmt := &CreateTableStmt{TableName: name}
+	var tablePK []string
 	for p.curToken.Value != ")" {
+		if p.curToken.Type == TokenKeyword && p.curToken.Value == "PRIMARY" {
+			if tablePK != nil || len(stmt.PrimaryKey) > 0 {
+				return nil, fmt.Errorf("multiple primary keys for table %s", name)
+			}
+			p.nextToken()
+			if p.curToken.Value != "KEY" {
+				return nil, fmt.Errorf("expected KEY after PRIMARY")
+			}
+			p.nextToken()
+			cols, err := p.parseColumnList()
+			if err != nil {
+				return nil, err
+			}
+			tablePK = cols
+			if p.curToken.Value == "," {
+				p.nextToken()
+			}
+			continue
+		}
+		if p.curToken.Type == TokenKeyword && tableConstraints[p.curToken.Value] {
+			cname, err := p.parseConstraintName()
+			if err != nil {
+				return nil, err
+			}
+			switch p.curToken.Value {
+			case "CHECK":
+				check, err := p.parseCheck()
+				if err != nil {
+					return nil, err
+				}
+				check.Name = cname
+				stmt.Checks = append(stmt.Checks, check)
+			case "FOREIGN":
+				p.nextToken()
+				if p.curToken.Value != "KEY" {
+					return nil, fmt.Errorf("expected KEY after FOREIGN")
+				}
+				p.nextToken()
+				cols, err := p.parseColumnList()
+				if err != nil {
+					return nil, err
+				}
+				if p.curToken.Value != "REFERENCES" {
+					return nil, fmt.Errorf("expected REFERENCES after FOREIGN KEY (%s)", strings.Join(cols, ", "))
+				}
+				fk, err := p.parseReferences()
+				if err != nil {
+					return nil, err
+				}
+				fk.Name, fk.Columns = cname, cols
+				stmt.ForeignKeys = append(stmt.ForeignKeys, fk)
+			default:
+				return nil, fmt.Errorf("expected CHECK or FOREIGN KEY after CONSTRAINT %s", cname)
+			}
+			if p.curToken.Value == "," {
+				p.nextToken()
+			}
+			continue
+		}
+
 		colName := p.curToken.Value
 		if p.curToken.Type != TokenIdentifier {
 			return nil, fmt.Errorf("expected column name")
@@ -100,7 +177,7 @@ func (p *Parser) parseCreateTable() (*CreateTableStmt, error) {
 		col := catalog.Column{Name: colName, Type: colType}
 
 		// Constraints
-		for p.curToken.Value == "PRIMARY" || p.curToken.Value == "UNIQUE" {
+		for p.curToken.Type == TokenKeyword && columnConstraints[p.curToken.Value] {
 			switch p.curToken.Value {
 			case "PRIMARY":
 				p.nextToken()
@@ -108,10 +185,53 @@ func (p *Parser) parseCreateTable() (*CreateTableStmt, error) {
 					return nil, fmt.Errorf("expected KEY after PRIMARY")
 				}
 				p.nextToken()
+				if tablePK != nil || len(stmt.PrimaryKey) > 0 {
+					return nil, fmt.Errorf("multiple primary keys for table %s", name)
+				}
 				col.IsPrimary = true
+				stmt.PrimaryKey = []string{colName}
 			case "UNIQUE":
 				p.nextToken()
 				col.IsUnique = true
+			case "NOT":
+				p.nextToken()
+				if p.curToken.Value != "NULL" {
+					return nil, fmt.Errorf("expected NULL after NOT")
+				}
+				p.nextToken()
+				col.NotNull = true
+			case "NULL":
+				p.nextToken()
+			case "DEFAULT":
+				p.nextToken()
+				expr, err := p.parseSimpleExpr()
+				if err != nil {
+					return nil, fmt.Errorf("DEFAULT of column %s: %w", colName, err)
+				}
+				col.Default = FormatExpr(expr)
+			case "CHECK", "REFERENCES", "CONSTRAINT":
+				cname, err := p.parseConstraintName()
+				if err != nil {
+					return nil, err
+				}
+				switch p.curToken.Value {
+				case "CHECK":
+					check, err := p.parseCheck()
+					if err != nil {
+						return nil, err
+					}
+					check.Name, check.Column = cname, colName
+					stmt.Checks = append(stmt.Checks, check)
+				case "REFERENCES":
+					fk, err := p.parseReferences()
+					if err != nil {
+						return nil, err
+					}
+					fk.Name, fk.Columns = cname, []string{colName}
+					stmt.ForeignKeys = append(stmt.ForeignKeys, fk)
+				default:
+					return nil, fmt.Errorf("expected CHECK or REFERENCES after CONSTRAINT %s", cname)
+				}
 			}
 		}
 
@@ -122,40 +242,242 @@ func (p *Parser) parseCreateTable() (*CreateTableStmt, error) {
 		}
 	}
 	p.nextToken()
+
+	for _, pk := range tablePK {
+		found := false
+		for i := range stmt.Columns {
+			if stmt.Columns[i].Name == pk {
+				stmt.Columns[i].IsPrimary = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("primary key column %s does not exist", pk)
+		}
+	}
+	if tablePK != nil {
+		stmt.PrimaryKey = tablePK
+	}
 	return stmt, nil
 }
 
-func (p *Parser) parseCreateIndex() (*CreateIndexStmt, error) {
+// columnConstraints are the keywords that start a constraint after a
+// column's type.
+var columnConstraints = map[string]bool{
+	"PRIMARY": true, "UNIQUE": true, "NOT": true, "NULL": true,
+	"DEFAULT": true, "CHECK": true, "REFERENCES": true, "CONSTRAINT": true,
+}
+
+// tableConstraints are the keywords that start a table constraint other than
+// PRIMARY KEY.
+var tableConstraints = map[string]bool{
+	"CHECK": true, "FOREIGN": true, "CONSTRAINT": true,
+}
+
+// parseConstraintName parses the `CONSTRAINT name` that may start a
+// constraint, and returns "" if there is none.
+func (p *Parser) parseConstraintName() (string, error) {
+	if p.curToken.Value != "CONSTRAINT" {
+		return "", nil
+	}
 	p.nextToken()
-	idxName := p.curToken.Value
+	if p.curToken.Type != TokenIdentifier {
+		return "", fmt.Errorf("expected constraint name")
+	}
+	name := p.curToken.Value
 	p.nextToken()
+	return name, nil
+}
 
-	if p.curToken.Value != "ON" {
-		return nil, fmt.Errorf("expected ON")
-	}
+// parseCheck parses `CHECK (expr)`.
+func (p *Parser) parseCheck() (CheckConstraint, error) {
+	var check CheckConstraint
 	p.nextToken()
+	if p.curToken.Value != "(" {
+		return check, fmt.Errorf("expected ( after CHECK")
+	}
+	expr, err := p.parseSimpleExpr()
+	if err != nil {
+		return check, err
+	}
+	check.Expr = expr
+	return check, nil
+}
 
-	tableName := p.curToken.Value
+// parseReferences parses `REFERENCES table [(col, ...)]` and its
+// `ON DELETE action` and `ON UPDATE action`, where an action is RESTRICT,
+// CASCADE or SET NULL.
+func (p *Parser) parseReferences() (ForeignKeyConstraint, error) {
+	var fk ForeignKeyConstraint
 	p.nextToken()
+	if p.curToken.Type != TokenIdentifier {
+		return fk, fmt.Errorf("expected table name after REFERENCES")
+	}
+	fk.RefTable = p.curToken.Value
+	p.nextToken()
+	if p.curToken.Value == "(" {
+		cols, err := p.parseColumnList()
+		if err != nil {
+			return fk, err
+		}
+		fk.RefColumns = cols
+	}
+	for p.curToken.Value == "ON" {
+		p.nextToken()
+		event := p.curToken.Value
+		target := map[string]*string{"DELETE": &fk.OnDelete, "UPDATE": &fk.OnUpdate}[event]
+		if target == nil {
+			return fk, fmt.Errorf("expected DELETE or UPDATE after ON")
+		}
+		if *target != "" {
+			return fk, fmt.Errorf("ON %s is given more than once", event)
+		}
+		p.nextToken()
+		switch p.curToken.Value {
+		case "RESTRICT":
+			*target = catalog.ActionRestrict
+		case "CASCADE":
+			*target = catalog.ActionCascade
+		case "SET":
+			p.nextToken()
+			if p.curToken.Value != "NULL" {
+				return fk, fmt.Errorf("expected NULL after SET")
+			}
+			*target = catalog.ActionSetNull
+		default:
+			return fk, fmt.Errorf("expected RESTRICT, CASCADE or SET NULL after ON %s", event)
+		}
+		p.nextToken()
+	}
+	return fk, nil
+}
 
+// parseColumnList parses `(col, ...)`. Each column may appear only once.
+func (p *Parser) parseColumnList() ([]string, error) {
 	if p.curToken.Value != "(" {
 		return nil, fmt.Errorf("expected (")
 	}
 	p.nextToken()
 
-	colName := p.curToken.Value
+	var cols []string
+	seen := make(map[string]bool)
+	for {
+		if p.curToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected column name")
+		}
+		if seen[p.curToken.Value] {
+			return nil, fmt.Errorf("column %s is listed more than once", p.curToken.Value)
+		}
+		seen[p.curToken.Value] = true
+		cols = append(cols, p.curToken.Value)
+		p.nextToken()
+
+		if p.curToken.Value == ")" {
+			p.nextToken()
+			return cols, nil
+		}
+		if p.curToken.Value != "," {
+			return nil, fmt.Errorf("expected , or )")
+		}
+		p.nextToken()
+	}
+}
+
+func (p *Parser) parseCreateIndex(unique bool) (*CreateIndexStmt, error) {
+	p.nextToken()
+	idxName := p.curToken.Value
 	p.nextToken()
 
-	if p.curToken.Value != ")" {
-		return nil, fmt.Errorf("expected )")
+	if p.curToken.Value != "ON" {
+		return nil, fmt.Errorf("expected ON")
 	}
 	p.nextToken()
 
-	return &CreateIndexStmt{
+	tableName := p.curToken.Value
+	p.nextToken()
+
+	cols, err := p.parseIndexKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &CreateIndexStmt{
 		IndexName: idxName,
 		TableName: tableName,
-		Column:    colName,
-	}, nil
+		Columns:   cols,
+		Unique:    unique,
+	}
+	// INCLUDE (...) and USING ... may come in either order.
+	for {
+		switch {
+		case p.curToken.Value == "INCLUDE" && stmt.Include == nil:
+			p.nextToken()
+			if stmt.Include, err = p.parseColumnList(); err != nil {
+				return nil, err
+			}
+			for _, inc := range stmt.Include {
+				for _, col := range cols {
+					if inc == col {
+						return nil, fmt.Errorf("column %s is both a key column and included", inc)
+					}
+				}
+			}
+		case p.curToken.Value == "USING" && stmt.Using == "":
+			p.nextToken()
+			switch method := strings.ToUpper(p.curToken.Value); method {
+			case catalog.IndexTypeHash, catalog.IndexTypeBTree, catalog.IndexTypeTrigram:
+				stmt.Using = method
+			default:
+				return nil, fmt.Errorf("unknown index method %s (expected BTREE, HASH or TRIGRAM)", p.curToken.Value)
+			}
+			p.nextToken()
+		case p.curToken.Value == "WHERE" && stmt.Where == nil:
+			p.nextToken()
+			if stmt.Where, err = p.parseExpression(); err != nil {
+				return nil, err
+			}
+		default:
+			return stmt, nil
+		}
+	}
+}
+
+// parseIndexKeys parses the key of an index: a parenthesized list of columns
+// and expressions such as LOWER(email), each returned as SQL text.
+func (p *Parser) parseIndexKeys() ([]string, error) {
+	if p.curToken.Value != "(" {
+		return nil, fmt.Errorf("expected (")
+	}
+	p.nextToken()
+
+	var keys []string
+	seen := make(map[string]bool)
+	for {
+		expr, err := p.parseSimpleExpr()
+		if err != nil {
+			return nil, err
+		}
+		switch expr.(type) {
+		case *IdentifierExpr, *FuncCallExpr:
+		default:
+			return nil, fmt.Errorf("index keys must be columns or function calls")
+		}
+		key := FormatExpr(expr)
+		if seen[key] {
+			return nil, fmt.Errorf("column %s is listed more than once", key)
+		}
+		seen[key] = true
+		keys = append(keys, key)
+
+		if p.curToken.Value == ")" {
+			p.nextToken()
+			return keys, nil
+		}
+		if p.curToken.Value != "," {
+			return nil, fmt.Errorf("expected , or )")
+		}
+		p.nextToken()
+	}
 }
 
 func (p *Parser) parseInsert() (*InsertStmt, error) {
@@ -213,20 +535,18 @@ func (p *Parser) parseSelect() (*SelectStmt, error) {
 	stmt := &SelectStmt{}
 
 	// Fields
-	// Fields
-	for p.curToken.Value != "FROM" {
-		name := p.curToken.Value
+	if p.curToken.Value == "*" {
 		p.nextToken()
-		if p.curToken.Value == "." {
-			p.nextToken() // consume dot
-			if p.curToken.Type != TokenIdentifier {
-				return nil, fmt.Errorf("expected column name after dot")
-			}
-			name = name + "." + p.curToken.Value
-			p.nextToken()
+		if p.curToken.Value != "FROM" {
+			return nil, fmt.Errorf("expected FROM after *, got %s", p.curToken.Value)
 		}
-		stmt.Fields = append(stmt.Fields, name)
-
+	}
+	for p.curToken.Value != "FROM" {
+		expr, err := p.parseSimpleExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Fields = append(stmt.Fields, expr)
 		if p.curToken.Value == "," {
 			p.nextToken()
 		} else if p.curToken.Value != "FROM" {
@@ -273,9 +593,91 @@ func (p *Parser) parseSelect() (*SelectStmt, error) {
 		stmt.Where = &WhereClause{Expr: expr}
 	}
 
+	if p.curToken.Value == "GROUP" {
+		p.nextToken()
+		if p.curToken.Value != "BY" {
+			return nil, fmt.Errorf("expected BY after GROUP")
+		}
+		p.nextToken()
+		for {
+			expr, err := p.parseSimpleExpr()
+			if err != nil {
+				return nil, err
+			}
+			stmt.GroupBy = append(stmt.GroupBy, expr)
+			if p.curToken.Value != "," {
+				break
+			}
+			p.nextToken()
+		}
+	}
+
+	if p.curToken.Value == "HAVING" {
+		p.nextToken()
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Having = expr
+	}
+
+	if p.curToken.Value == "ORDER" {
+		p.nextToken()
+		if p.curToken.Value != "BY" {
+			return nil, fmt.Errorf("expected BY after ORDER")
+		}
+		p.nextToken()
+		for {
+			expr, err := p.parseSimpleExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := OrderByItem{Expr: expr}
+			switch p.curToken.Value {
+			case "ASC":
+				p.nextToken()
+			case "DESC":
+				item.Desc = true
+				p.nextToken()
+			}
+			stmt.OrderBy = append(stmt.OrderBy, item)
+			if p.curToken.Value != "," {
+				break
+			}
+			p.nextToken()
+		}
+	}
+
+	if p.curToken.Value == "LIMIT" {
+		p.nextToken()
+		n, err := p.parseCount("LIMIT")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Limit = &n
+	}
+	if p.curToken.Value == "OFFSET" {
+		p.nextToken()
+		n, err := p.parseCount("OFFSET")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Offset = n
+	}
+
 	return stmt, nil
 }
 
+// parseCount parses the row count of a LIMIT or OFFSET clause.
+func (p *Parser) parseCount(clause string) (int, error) {
+	n, err := strconv.Atoi(p.curToken.Value)
+	if p.curToken.Type != TokenNumber || err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a row count after %s, got %v", clause, p.curToken)
+	}
+	p.nextToken()
+	return n, nil
+}
+
 func (p *Parser) parseExpression() (Expression, error) {
 
 	left, err := p.parseTerm()
@@ -301,7 +703,43 @@ func (p *Parser) parseTerm() (Expression, error) {
 		return nil, err
 	}
 
-	if isOperator(p.curToken.Value) {
+	if p.curToken.Value == "IS" {
+		p.nextToken()
+		expr := &IsNullExpr{Expr: left}
+		if p.curToken.Value == "NOT" {
+			expr.Not = true
+			p.nextToken()
+		}
+		if p.curToken.Value != "NULL" {
+			return nil, fmt.Errorf("expected NULL after IS")
+		}
+		p.nextToken()
+		return expr, nil
+	}
+
+	// `x BETWEEN a AND b` is shorthand for `x >= a AND x <= b`.
+	if p.curToken.Value == "BETWEEN" {
+		p.nextToken()
+		low, err := p.parseSimpleExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.curToken.Value != "AND" {
+			return nil, fmt.Errorf("expected AND in BETWEEN")
+		}
+		p.nextToken()
+		high, err := p.parseSimpleExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{
+			Left:  &BinaryExpr{Left: left, Op: OpGte, Right: low},
+			Op:    OpAnd,
+			Right: &BinaryExpr{Left: left, Op: OpLte, Right: high},
+		}, nil
+	}
+
+	if isOperator(p.curToken.Value) || p.curToken.Value == "LIKE" {
 		op := Operator(p.curToken.Value)
 		p.nextToken()
 		right, err := p.parseSimpleExpr()
@@ -319,6 +757,12 @@ func (p *Parser) parseSimpleExpr() (Expression, error) {
 	case TokenIdentifier:
 		name := p.curToken.Value
 		p.nextToken()
+		if p.curToken.Value == "(" {
+			if Aggregates[strings.ToUpper(name)] {
+				return p.parseAggregate(name)
+			}
+			return p.parseFuncCall(name)
+		}
 		if p.curToken.Value == "." {
 			p.nextToken()
 			if p.curToken.Type != TokenIdentifier {
@@ -336,17 +780,97 @@ func (p *Parser) parseSimpleExpr() (Expression, error) {
 		val := p.curToken.Value
 		p.nextToken()
 		return &LiteralExpr{Value: RawNumber(val)}, nil
+	case TokenSymbol:
+		if p.curToken.Value != "(" {
+			return nil, fmt.Errorf("unexpected token in expression: %v", p.curToken)
+		}
+		p.nextToken()
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if p.curToken.Value != ")" {
+			return nil, fmt.Errorf("expected )")
+		}
+		p.nextToken()
+		return expr, nil
+	case TokenKeyword:
+		if p.curToken.Value == "NULL" {
+			p.nextToken()
+			return &LiteralExpr{Value: nil}, nil
+		}
+		return nil, fmt.Errorf("unexpected token in expression: %v", p.curToken)
 	default:
 		return nil, fmt.Errorf("unexpected token in expression: %v", p.curToken)
 	}
 }
 
+// parseAggregate parses the argument of a call to the aggregate function
+// name, from the opening parenthesis: *, an expression, or DISTINCT and an
+// expression.
+func (p *Parser) parseAggregate(name string) (Expression, error) {
+	agg := &AggregateExpr{Name: strings.ToUpper(name)}
+	p.nextToken()
+	if p.curToken.Value == "DISTINCT" {
+		agg.Distinct = true
+		p.nextToken()
+	}
+	if p.curToken.Value == "*" && !agg.Distinct {
+		if agg.Name != "COUNT" {
+			return nil, fmt.Errorf("%s(*) is not supported; only COUNT(*) is", agg.Name)
+		}
+		p.nextToken()
+	} else {
+		arg, err := p.parseSimpleExpr()
+		if err != nil {
+			return nil, err
+		}
+		agg.Arg = arg
+	}
+	if p.curToken.Value != ")" {
+		return nil, fmt.Errorf("expected ) after the argument of %s", agg.Name)
+	}
+	p.nextToken()
+	return agg, nil
+}
+
+// parseFuncCall parses the arguments of a call to the function name, from
+// the opening parenthesis.
+func (p *Parser) parseFuncCall(name string) (Expression, error) {
+	call := &FuncCallExpr{Name: strings.ToUpper(name)}
+	p.nextToken()
+	if p.curToken.Value == ")" {
+		p.nextToken()
+		return call, nil
+	}
+	for {
+		arg, err := p.parseSimpleExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		switch p.curToken.Value {
+		case ")":
+			p.nextToken()
+			return call, nil
+		case ",":
+			p.nextToken()
+		default:
+			return nil, fmt.Errorf("expected , or ) in call to %s", call.Name)
+		}
+	}
+}
+
 func (p *Parser) parseLiteral() (interface{}, error) {
 	switch p.curToken.Type {
 	case TokenString:
 		return p.curToken.Value, nil
 	case TokenNumber:
 		return RawNumber(p.curToken.Value), nil
+	case TokenKeyword:
+		if p.curToken.Value == "NULL" {
+			return nil, nil
+		}
 	}
 	return nil, fmt.Errorf("expected literal")
 }
@@ -369,7 +893,10 @@ func (p *Parser) parseUpdate() (*UpdateStmt, error) {
 			return nil, fmt.Errorf("expected =")
 		}
 		p.nextToken()
-		val, _ := p.parseLiteral()
+		val, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
 		stmt.SetPairs[col] = val
 		p.nextToken()
 
@@ -405,6 +932,90 @@ func (p *Parser) parseDelete() (*DeleteStmt, error) {
 	return stmt, nil
 }
 
+func (p *Parser) parseVacuum() (*VacuumStmt, error) {
+	p.nextToken()
+	stmt := &VacuumStmt{}
+	if p.curToken.Type == TokenIdentifier {
+		stmt.TableName = p.curToken.Value
+		p.nextToken()
+	}
+	return stmt, nil
+}
+
+// DROP INDEX [table.]name
+func (p *Parser) parseDrop() (*DropIndexStmt, error) {
+	p.nextToken()
+	if p.curToken.Value != "INDEX" {
+		return nil, fmt.Errorf("expected INDEX after DROP")
+	}
+	p.nextToken()
+	ref, err := p.parseIndexRef()
+	if err != nil {
+		return nil, err
+	}
+	return &DropIndexStmt{Index: *ref}, nil
+}
+
+// REINDEX TABLE table or REINDEX INDEX [table.]name
+func (p *Parser) parseReindex() (*ReindexStmt, error) {
+	p.nextToken()
+	switch p.curToken.Value {
+	case "TABLE":
+		p.nextToken()
+		if p.curToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected table name")
+		}
+		stmt := &ReindexStmt{TableName: p.curToken.Value}
+		p.nextToken()
+		return stmt, nil
+	case "INDEX":
+		p.nextToken()
+		ref, err := p.parseIndexRef()
+		if err != nil {
+			return nil, err
+		}
+		return &ReindexStmt{Index: ref}, nil
+	default:
+		return nil, fmt.Errorf("expected TABLE or INDEX after REINDEX")
+	}
+}
+
+// SHOW INDEXES [FROM table]
+func (p *Parser) parseShowIndexes() (*ShowIndexesStmt, error) {
+	p.nextToken()
+	if p.curToken.Value != "INDEXES" {
+		return nil, fmt.Errorf("expected INDEXES after SHOW")
+	}
+	p.nextToken()
+	stmt := &ShowIndexesStmt{}
+	if p.curToken.Value == "FROM" {
+		p.nextToken()
+		if p.curToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected table name")
+		}
+		stmt.TableName = p.curToken.Value
+		p.nextToken()
+	}
+	return stmt, nil
+}
+
+func (p *Parser) parseIndexRef() (*IndexRef, error) {
+	if p.curToken.Type != TokenIdentifier {
+		return nil, fmt.Errorf("expected index name")
+	}
+	ref := &IndexRef{IndexName: p.curToken.Value}
+	p.nextToken()
+	if p.curToken.Value == "." {
+		p.nextToken()
+		if p.curToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected index name after .")
+		}
+		ref.TableName, ref.IndexName = ref.IndexName, p.curToken.Value
+		p.nextToken()
+	}
+	return ref, nil
+}
+
 func isOperator(s string) bool {
 	return s == "=" || s == "!=" || s == "<" || s == ">" || s == "<=" || s == ">="
 }
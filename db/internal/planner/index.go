@@ -0,0 +1,1236 @@
+package planner
+
+import (
+	"fmt"
+	"minibank/internal/catalog"
+	"minibank/internal/errors"
+	"minibank/internal/execution"
+	"minibank/internal/indexing"
+	"minibank/internal/parser"
+	"minibank/internal/storage"
+	"strings"
+)
+
+// CreateTable adds the table of stmt to the catalog, with a unique index for
+// its primary key and for each UNIQUE column. It must run inside the
+// statement's transaction.
+func (p *Planner) CreateTable(stmt *parser.CreateTableStmt) error {
+	taken, err := constraintNames(stmt)
+	if err != nil {
+		return err
+	}
+	checks := checkDefs(stmt, taken)
+	if err := p.Catalog.CreateTable(stmt.TableName, stmt.Columns, stmt.PrimaryKey, checks); err != nil {
+		return err
+	}
+	table, _ := p.Catalog.GetTable(stmt.TableName)
+	if table.ForeignKeys, err = p.foreignKeyDefs(stmt, table, taken); err != nil {
+		return err
+	}
+	if _, err := execution.NewChecks(table); err != nil {
+		return err
+	}
+	for _, col := range table.Columns {
+		if _, err := execution.DefaultValue(col); err != nil {
+			return err
+		}
+	}
+	if _, err := p.Storage.GetHeapFile(stmt.TableName); err != nil {
+		return err
+	}
+
+	opened := make(map[string]indexing.Index)
+	for _, def := range backingIndexes(table) {
+		idx, _, err := p.buildIndex(table, def)
+		if err != nil {
+			return err
+		}
+		opened[indexKey(table.Name, def.Name)] = idx
+	}
+	if err := p.Storage.SaveCatalog(p.Catalog); err != nil {
+		return fmt.Errorf("failed to persist catalog: %w", err)
+	}
+	for key, idx := range opened {
+		p.Indices[key] = idx
+	}
+	return nil
+}
+
+// constraintNames returns the names that CONSTRAINT gives the CHECK and
+// FOREIGN KEY constraints of stmt. A table may use each name once.
+func constraintNames(stmt *parser.CreateTableStmt) (map[string]bool, error) {
+	var names []string
+	for _, c := range stmt.Checks {
+		names = append(names, c.Name)
+	}
+	for _, fk := range stmt.ForeignKeys {
+		names = append(names, fk.Name)
+	}
+	taken := make(map[string]bool)
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if taken[name] {
+			return nil, fmt.Errorf("constraint %s is defined more than once", name)
+		}
+		taken[name] = true
+	}
+	return taken, nil
+}
+
+// constraintName returns name, with a number added if taken already has it,
+// and adds the result to taken.
+func constraintName(name string, taken map[string]bool) string {
+	for base, n := name, 1; taken[name]; n++ {
+		name = fmt.Sprintf("%s%d", base, n)
+	}
+	taken[name] = true
+	return name
+}
+
+// checkDefs names the CHECK constraints of stmt as PostgreSQL does:
+// <table>_<column>_check for one written on a column and <table>_check for
+// one on the table, with a number added to repeated names.
+func checkDefs(stmt *parser.CreateTableStmt, taken map[string]bool) []catalog.CheckDef {
+	var defs []catalog.CheckDef
+	for _, c := range stmt.Checks {
+		name := c.Name
+		if name == "" {
+			name = stmt.TableName + "_check"
+			if c.Column != "" {
+				name = fmt.Sprintf("%s_%s_check", stmt.TableName, c.Column)
+			}
+			name = constraintName(name, taken)
+		}
+		defs = append(defs, catalog.CheckDef{Name: name, Expr: parser.FormatExpr(c.Expr)})
+	}
+	return defs
+}
+
+// foreignKeyDefs resolves the FOREIGN KEY constraints of stmt on table, which
+// is in the catalog already so that it can reference itself. A foreign key
+// references the primary key of its table unless it names other columns,
+// which must be the primary key or a UNIQUE column; their unique index then
+// finds the referenced row. Unnamed foreign keys are called
+// <table>_<columns>_fkey, and both actions default to RESTRICT.
+func (p *Planner) foreignKeyDefs(stmt *parser.CreateTableStmt, table *catalog.Table, taken map[string]bool) ([]catalog.ForeignKeyDef, error) {
+	var defs []catalog.ForeignKeyDef
+	for _, fk := range stmt.ForeignKeys {
+		def := catalog.ForeignKeyDef{
+			Name:       fk.Name,
+			Columns:    fk.Columns,
+			RefTable:   fk.RefTable,
+			RefColumns: fk.RefColumns,
+			OnDelete:   fk.OnDelete,
+			OnUpdate:   fk.OnUpdate,
+		}
+		if def.Name == "" {
+			def.Name = constraintName(fmt.Sprintf("%s_%s_fkey", table.Name, strings.Join(fk.Columns, "_")), taken)
+		}
+		if def.OnDelete == "" {
+			def.OnDelete = catalog.ActionRestrict
+		}
+		if def.OnUpdate == "" {
+			def.OnUpdate = catalog.ActionRestrict
+		}
+
+		ref, ok := p.Catalog.GetTable(def.RefTable)
+		if !ok || catalog.IsSystemTable(def.RefTable) {
+			return nil, fmt.Errorf("foreign key %s: table %s not found", def.Name, def.RefTable)
+		}
+		pk := ref.PrimaryKeyColumns()
+		if len(def.RefColumns) == 0 {
+			if len(pk) == 0 {
+				return nil, fmt.Errorf("foreign key %s: table %s has no primary key to reference", def.Name, ref.Name)
+			}
+			def.RefColumns = pk
+		}
+		if len(def.RefColumns) != len(def.Columns) {
+			return nil, fmt.Errorf("foreign key %s: %d columns cannot reference %d columns of %s", def.Name, len(def.Columns), len(def.RefColumns), ref.Name)
+		}
+		if sameColumnSet(def.RefColumns, pk) {
+			// Keep the columns in key order, so that the primary key's index
+			// finds the referenced row.
+			byRef := make(map[string]string)
+			for i, col := range def.RefColumns {
+				byRef[col] = def.Columns[i]
+			}
+			def.Columns, def.RefColumns = make([]string, len(pk)), pk
+			for i, col := range pk {
+				def.Columns[i] = byRef[col]
+			}
+		} else if i := columnIndex(ref, def.RefColumns[0]); len(def.RefColumns) != 1 || i == -1 || !ref.Columns[i].IsUnique {
+			return nil, fmt.Errorf("foreign key %s: (%s) is neither the primary key of %s nor a UNIQUE column", def.Name, strings.Join(def.RefColumns, ", "), ref.Name)
+		}
+
+		for i, name := range def.Columns {
+			col := columnIndex(table, name)
+			if col == -1 {
+				return nil, fmt.Errorf("foreign key %s: column %s not found in table %s", def.Name, name, table.Name)
+			}
+			refCol := ref.Columns[columnIndex(ref, def.RefColumns[i])]
+			if table.Columns[col].Type != refCol.Type {
+				return nil, fmt.Errorf("foreign key %s: column %s is %s but %s.%s is %s", def.Name, name, table.Columns[col].Type, ref.Name, refCol.Name, refCol.Type)
+			}
+			nullable := !table.Columns[col].IsPrimary && !table.Columns[col].NotNull
+			if !nullable && (def.OnDelete == catalog.ActionSetNull || def.OnUpdate == catalog.ActionSetNull) {
+				return nil, fmt.Errorf("foreign key %s: SET NULL needs column %s to allow NULL", def.Name, name)
+			}
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// sameColumnSet reports whether a and b list the same columns, in any order.
+func sameColumnSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	in := make(map[string]bool)
+	for _, col := range b {
+		in[col] = true
+	}
+	for _, col := range a {
+		if !in[col] {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateIndex records the index of stmt in the catalog and fills it from the
+// table's rows. It returns the number of rows indexed. It must run inside the
+// statement's transaction, so a failure leaves neither the catalog entry nor
+// the index file behind.
+func (p *Planner) CreateIndex(stmt *parser.CreateIndexStmt) (int, error) {
+	table, exists := p.Catalog.GetTable(stmt.TableName)
+	if !exists {
+		return 0, fmt.Errorf("table %s not found", stmt.TableName)
+	}
+	def := catalog.IndexDef{
+		Name:      stmt.IndexName,
+		Columns:   stmt.Columns,
+		Include:   stmt.Include,
+		Type:      stmt.Using,
+		IsUnique:  stmt.Unique,
+		KeyFormat: indexing.KeyFormat,
+	}
+	if def.Type == "" {
+		def.Type = catalog.IndexTypeHash
+	}
+	if stmt.Where != nil {
+		def.Where = parser.FormatExpr(stmt.Where)
+	}
+	ti, err := execution.NewTableIndex(table, def, nil)
+	if err != nil {
+		return 0, err
+	}
+	if def.Type == catalog.IndexTypeTrigram && (len(def.Columns) != 1 || ti.KeyTypes()[0] != catalog.TypeString || len(def.Include) > 0 || def.IsUnique) {
+		return 0, fmt.Errorf("index %s: a TRIGRAM index takes one STRING column or expression, and cannot be UNIQUE or INCLUDE columns", def.Name)
+	}
+	for _, existing := range table.Indexes {
+		if existing.Name == stmt.IndexName {
+			return 0, fmt.Errorf("index %s already exists on table %s", stmt.IndexName, stmt.TableName)
+		}
+	}
+	idx, count, err := p.buildIndex(table, def)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := p.Storage.SaveCatalog(p.Catalog); err != nil {
+		return 0, fmt.Errorf("failed to persist catalog: %w", err)
+	}
+	p.Indices[indexKey(table.Name, def.Name)] = idx
+	return count, nil
+}
+
+// DropIndex removes the index of stmt from the catalog and empties its file,
+// which is deleted when the statement commits. The unique index that
+// enforces a primary key or UNIQUE column cannot be dropped. It must run
+// inside the statement's transaction; if that rolls back, ReloadCatalog
+// opens the index again.
+func (p *Planner) DropIndex(stmt *parser.DropIndexStmt) error {
+	table, def, err := p.findIndex(stmt.Index)
+	if err != nil {
+		return err
+	}
+	rest := *table
+	rest.Indexes = nil
+	for _, d := range table.Indexes {
+		if d.Name != def.Name {
+			rest.Indexes = append(rest.Indexes, d)
+		}
+	}
+	if len(backingIndexes(&rest)) > 0 {
+		what := "a UNIQUE column"
+		if strings.Join(def.Columns, ",") == strings.Join(table.PrimaryKeyColumns(), ",") {
+			what = "the primary key"
+		}
+		return errors.New(errors.ErrConstraintViolation,
+			fmt.Sprintf("cannot drop index %s: it enforces %s of table %s", def.Name, what, table.Name),
+			"Create another unique index on the same columns first.")
+	}
+
+	key := indexKey(table.Name, def.Name)
+	if idx, ok := p.Indices[key]; ok {
+		if err := idx.Clear(); err != nil {
+			return fmt.Errorf("index %s: %w", def.Name, err)
+		}
+	}
+	if err := p.Catalog.RemoveIndex(table.Name, def.Name); err != nil {
+		return err
+	}
+	if err := p.Storage.SaveCatalog(p.Catalog); err != nil {
+		return fmt.Errorf("failed to persist catalog: %w", err)
+	}
+	p.Storage.DropFile(storage.IndexFileName(table.Name, def.Name))
+	delete(p.Indices, key)
+	return nil
+}
+
+// findIndex returns the index ref names and its table. An unqualified name
+// must belong to only one table.
+func (p *Planner) findIndex(ref parser.IndexRef) (*catalog.Table, catalog.IndexDef, error) {
+	if ref.TableName != "" {
+		if _, ok := p.Catalog.GetTable(ref.TableName); !ok {
+			return nil, catalog.IndexDef{}, fmt.Errorf("table %s not found", ref.TableName)
+		}
+	}
+	var table *catalog.Table
+	var def catalog.IndexDef
+	var tables []string
+	for _, t := range p.Catalog.UserTables() {
+		if ref.TableName != "" && t.Name != ref.TableName {
+			continue
+		}
+		for _, d := range t.Indexes {
+			if d.Name == ref.IndexName {
+				table, def = t, d
+				tables = append(tables, t.Name)
+			}
+		}
+	}
+	switch {
+	case len(tables) == 0 && ref.TableName != "":
+		return nil, def, fmt.Errorf("index %s not found on table %s", ref.IndexName, ref.TableName)
+	case len(tables) == 0:
+		return nil, def, fmt.Errorf("index %s not found", ref.IndexName)
+	case len(tables) > 1:
+		return nil, def, fmt.Errorf("index %s exists on tables %s; name it as <table>.%s", ref.IndexName, strings.Join(tables, ", "), ref.IndexName)
+	}
+	return table, def, nil
+}
+
+// buildIndex adds def to the catalog and fills the new index from the table's
+// rows. A unique index is refused if the rows already repeat a key. A new
+// index file is registered with Engine.CreatedFile, so an abort deletes it.
+func (p *Planner) buildIndex(table *catalog.Table, def catalog.IndexDef) (indexing.Index, int, error) {
+	if err := p.Catalog.AddIndex(table.Name, def); err != nil {
+		return nil, 0, err
+	}
+	fileName := storage.IndexFileName(table.Name, def.Name)
+	exists, err := p.Storage.FileExists(fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !exists {
+		p.Storage.CreatedFile(fileName)
+	}
+	idx, err := p.openIndex(table, def)
+	if err != nil {
+		return nil, 0, err
+	}
+	ti, err := execution.NewTableIndex(table, def, idx)
+	if err != nil {
+		return nil, 0, err
+	}
+	counts, err := p.fillIndices(table, []execution.TableIndex{ti}, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	return idx, counts[0], nil
+}
+
+// backingIndexes returns the unique indexes that the primary key and the
+// UNIQUE columns of table still lack. The primary key's index is
+// <table>_pkey and a UNIQUE column's is <table>_<column>_key, as in
+// PostgreSQL.
+func backingIndexes(table *catalog.Table) []catalog.IndexDef {
+	taken := make(map[string]bool)
+	backed := make(map[string]bool) // by column list
+	for _, def := range table.Indexes {
+		taken[def.Name] = true
+		if def.IsUnique && def.Where == "" {
+			backed[strings.Join(def.Columns, ",")] = true
+		}
+	}
+
+	var defs []catalog.IndexDef
+	add := func(name string, cols []string) {
+		if backed[strings.Join(cols, ",")] {
+			return
+		}
+		for base, n := name, 1; taken[name]; n++ {
+			name = fmt.Sprintf("%s%d", base, n)
+		}
+		taken[name] = true
+		backed[strings.Join(cols, ",")] = true
+		defs = append(defs, catalog.IndexDef{Name: name, Columns: cols, Type: catalog.IndexTypeBTree, IsUnique: true, KeyFormat: indexing.KeyFormat})
+	}
+	if pk := table.PrimaryKeyColumns(); len(pk) > 0 {
+		add(table.Name+"_pkey", pk)
+	}
+	for _, col := range table.Columns {
+		if col.IsUnique {
+			add(fmt.Sprintf("%s_%s_key", table.Name, col.Name), []string{col.Name})
+		}
+	}
+	return defs
+}
+
+// ReloadCatalog reads the catalog back from the system tables and opens its
+// indexes again. It runs after a DDL statement rolled back or failed to
+// commit, so that Indices holds every index the catalog lists and DML keeps
+// maintaining them.
+func (p *Planner) ReloadCatalog() error {
+	if err := p.Storage.LoadCatalog(p.Catalog); err != nil {
+		return err
+	}
+	return p.OpenIndices()
+}
+
+// OpenIndices opens the indexes of every table. Index files are only opened
+// here; their pages are read through the buffer pool when a lookup needs
+// them, so startup time does not depend on the size of the tables.
+//
+// Some indexes are built here, once. Tables created before PRIMARY KEY and
+// UNIQUE columns had unique indexes get them now, hash indexes created
+// before they were stored on disk have no file yet, and index files built
+// with an older key encoding than indexing.KeyFormat are emptied and built
+// again. A read-only engine adds no indexes, keeps such a hash index in
+// memory and leaves an index with an old encoding closed, so it is not used.
+func (p *Planner) OpenIndices() error {
+	p.Indices = make(map[string]indexing.Index)
+	readOnly := p.Storage.ReadOnly()
+	for _, table := range p.Catalog.UserTables() {
+		build := make(map[string]bool)
+		var added []catalog.IndexDef
+		if !readOnly {
+			added = backingIndexes(table)
+		}
+		for _, def := range added {
+			if err := p.Catalog.AddIndex(table.Name, def); err != nil {
+				return err
+			}
+			build[def.Name] = true
+			fmt.Printf("Adding unique index %s on %s (%s)...\n", def.Name, table.Name, strings.Join(def.Columns, ", "))
+		}
+
+		var missing, stale []execution.TableIndex
+		for i, def := range table.Indexes {
+			exists, err := p.Storage.FileExists(storage.IndexFileName(table.Name, def.Name))
+			if err != nil {
+				return err
+			}
+			legacy := !exists && (def.Type == catalog.IndexTypeHash || def.Type == "")
+			old := exists && def.KeyFormat != indexing.KeyFormat
+			if old && readOnly {
+				continue
+			}
+
+			var idx indexing.Index
+			if legacy && readOnly {
+				ti, err := execution.NewTableIndex(table, def, nil)
+				if err != nil {
+					return err
+				}
+				idx = indexing.NewHashIndex(ti.KeyTypes())
+			} else if idx, err = p.openIndex(table, def); err != nil {
+				return fmt.Errorf("index %s: %w", def.Name, err)
+			}
+			p.Indices[indexKey(table.Name, def.Name)] = idx
+			if legacy || old || build[def.Name] {
+				ti, err := execution.NewTableIndex(table, def, idx)
+				if err != nil {
+					return err
+				}
+				missing = append(missing, ti)
+				if old {
+					stale = append(stale, ti)
+				}
+				if !readOnly {
+					table.Indexes[i].KeyFormat = indexing.KeyFormat
+				}
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		fmt.Printf("Building %d index(es) of %s...\n", len(missing), table.Name)
+		if readOnly {
+			if _, err := p.fillIndices(table, missing, false); err != nil {
+				return err
+			}
+			continue
+		}
+		p.Storage.Begin()
+		err := func() error {
+			for _, ti := range stale {
+				if err := ti.Index.Clear(); err != nil {
+					return fmt.Errorf("index %s: %w", ti.Name, err)
+				}
+			}
+			if _, err := p.fillIndices(table, missing, false); err != nil {
+				return err
+			}
+			return p.Storage.SaveCatalog(p.Catalog)
+		}()
+		if err != nil {
+			if abortErr := p.Storage.Abort(); abortErr != nil {
+				return fmt.Errorf("%w (rollback failed: %v)", err, abortErr)
+			}
+			return err
+		}
+		if err := p.Storage.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildIndices empties every index and fills it again from its table. It
+// is the recovery path for indexes that no longer match their table, and
+// must run inside a transaction.
+func (p *Planner) RebuildIndices() error {
+	for _, table := range p.Catalog.UserTables() {
+		var all []execution.TableIndex
+		for _, def := range table.Indexes {
+			idx, ok := p.Indices[indexKey(table.Name, def.Name)]
+			if !ok {
+				return fmt.Errorf("index %s is not open", def.Name)
+			}
+			if err := idx.Clear(); err != nil {
+				return fmt.Errorf("index %s: %w", def.Name, err)
+			}
+			ti, err := execution.NewTableIndex(table, def, idx)
+			if err != nil {
+				return err
+			}
+			all = append(all, ti)
+		}
+		if len(all) == 0 {
+			continue
+		}
+		counts, err := p.fillIndices(table, all, false)
+		if err != nil {
+			return err
+		}
+		for i, ti := range all {
+			fmt.Printf("Rebuilt index %s on %s (%d entries)\n", ti.Name, indexColumns(table, ti), counts[i])
+		}
+	}
+	return nil
+}
+
+// indexKey is the key of an open index in Planner.Indices.
+func indexKey(table, index string) string {
+	return table + "." + index
+}
+
+// tableIndexes returns the open indexes of table, for the operators that
+// read or write it.
+func (p *Planner) tableIndexes(table *catalog.Table) ([]execution.TableIndex, error) {
+	var indexes []execution.TableIndex
+	for _, def := range table.Indexes {
+		if idx, ok := p.Indices[indexKey(table.Name, def.Name)]; ok {
+			ti, err := execution.NewTableIndex(table, def, idx)
+			if err != nil {
+				return nil, err
+			}
+			indexes = append(indexes, ti)
+		}
+	}
+	return indexes, nil
+}
+
+// indexColumns names the columns of an index in messages: t.a, t.(a, b) or
+// t.LOWER(email).
+func indexColumns(table *catalog.Table, ti execution.TableIndex) string {
+	names := make([]string, len(ti.Columns))
+	for i, col := range ti.Columns {
+		if col == -1 {
+			names[i] = parser.FormatExpr(ti.Exprs[i])
+		} else {
+			names[i] = table.Columns[col].Name
+		}
+	}
+	if len(names) == 1 {
+		return table.Name + "." + names[0]
+	}
+	return fmt.Sprintf("%s.(%s)", table.Name, strings.Join(names, ", "))
+}
+
+// fillIndices adds every row of table to the given indexes in one scan and
+// returns the number of entries each got. Partial indexes only get the rows
+// that match their predicate. With checkUnique, a key repeated in a unique
+// index is an error; rebuilding an index keeps whatever the rows hold.
+func (p *Planner) fillIndices(table *catalog.Table, indexes []execution.TableIndex, checkUnique bool) ([]int, error) {
+	hf, err := p.Storage.GetHeapFile(table.Name)
+	if err != nil {
+		return nil, err
+	}
+	iter := hf.Iterator()
+	counts := make([]int, len(indexes))
+	for {
+		data, rid, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return counts, nil
+		}
+
+		tuple, err := storage.DeserializeTuple(data, table.Columns)
+		if err != nil {
+			return nil, err
+		}
+		for i, ti := range indexes {
+			ok, err := ti.Holds(tuple.Cells)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			entry, err := ti.Entry(tuple.Cells)
+			if err != nil {
+				return nil, err
+			}
+			key := entry[:len(ti.Columns)]
+			if checkUnique && ti.Unique && !key.HasNull() {
+				rids, err := ti.Index.Get(key)
+				if err != nil {
+					return nil, fmt.Errorf("index %s: %w", ti.Name, err)
+				}
+				if len(rids) > 0 {
+					return nil, errors.New(errors.ErrConstraintViolation,
+						fmt.Sprintf("could not create unique index %s: key %v is duplicated", ti.Name, key),
+						"Change or delete the rows that repeat the key first.")
+				}
+			}
+			if err := ti.Index.Insert(entry, rid); err != nil {
+				return nil, fmt.Errorf("index %s: %w", ti.Name, err)
+			}
+			counts[i]++
+		}
+	}
+}
+
+// openIndex opens the file of the index described by def.
+func (p *Planner) openIndex(table *catalog.Table, def catalog.IndexDef) (indexing.Index, error) {
+	ti, err := execution.NewTableIndex(table, def, nil)
+	if err != nil {
+		return nil, err
+	}
+	keyTypes := ti.KeyTypes()
+	includeTypes, err := columnTypes(table, def.Include)
+	if err != nil {
+		return nil, err
+	}
+	fileName := storage.IndexFileName(table.Name, def.Name)
+
+	switch def.Type {
+	case catalog.IndexTypeHash, "":
+		pager, err := p.Storage.OpenIndexFile(fileName, storage.FileKindHash)
+		if err != nil {
+			return nil, err
+		}
+		return indexing.NewLinearHash(pager, p.Storage.BufferPool(), keyTypes, includeTypes), nil
+	case catalog.IndexTypeBTree:
+		pager, err := p.Storage.OpenIndexFile(fileName, storage.FileKindBTree)
+		if err != nil {
+			return nil, err
+		}
+		return indexing.NewBTree(pager, p.Storage.BufferPool(), keyTypes, includeTypes), nil
+	case catalog.IndexTypeTrigram:
+		pager, err := p.Storage.OpenIndexFile(fileName, storage.FileKindBTree)
+		if err != nil {
+			return nil, err
+		}
+		return indexing.NewTrigramIndex(pager, p.Storage.BufferPool()), nil
+	}
+	return nil, fmt.Errorf("unknown index type %s", def.Type)
+}
+
+func columnTypes(table *catalog.Table, names []string) ([]catalog.ColumnType, error) {
+	types := make([]catalog.ColumnType, len(names))
+	for j, col := range names {
+		i := columnIndex(table, col)
+		if i == -1 {
+			return nil, fmt.Errorf("column %s not found in table %s", col, table.Name)
+		}
+		types[j] = table.Columns[i].Type
+	}
+	return types, nil
+}
+
+// accessPath is an index and the part of it that a WHERE clause reads: the
+// entries for key, or when key is nil those between lo and hi. With no key
+// and no bounds, it reads every entry of a partial index. A trigram index is
+// read for the rows that have at least min of trigrams instead. ordered is
+// set when the rows come in the order of the query's ORDER BY.
+type accessPath struct {
+	ti       execution.TableIndex
+	key      indexing.Key
+	lo, hi   *indexing.Bound
+	trigrams []string
+	min      int
+	ordered  bool
+}
+
+// ordering is the ORDER BY of a query, for an access path to follow. With a
+// LIMIT, reading a whole index in order is worth it when nothing narrower
+// applies, since the query stops reading after the rows it returns.
+type ordering struct {
+	items   []parser.OrderByItem
+	limited bool
+}
+
+// accessPath picks an index access path for a WHERE clause from the
+// comparisons ANDed together in it. An index whose columns all have an
+// equality with a literal is read by key. Otherwise an ordered index can be
+// read by range: its leading columns may have equalities, and the column
+// after them may be bounded by <, <=, >, >= (or BETWEEN, which the parser
+// expands). Failing both, a trigram index can serve a LIKE or MATCH term on
+// its column. An index on an expression such as LOWER(email) matches
+// comparisons on the same expression. A partial index is only used when
+// the WHERE clause implies its predicate, and then it may also be read
+// whole. The index that matches the most columns wins; on a tie, one that
+// covers is preferred, if covers is given, then one that reads the rows in
+// the order of order, if given, and then a partial one. When no index
+// applies to the WHERE clause (which may be nil) but order is limited, an
+// ordered index that follows it is read whole. It returns nil when no
+// index applies. The caller still filters the rows with the full WHERE
+// clause.
+func (p *Planner) accessPath(table *catalog.Table, where parser.Expression, order *ordering, covers func(execution.TableIndex) bool) *accessPath {
+	var query []parser.Expression
+	if where != nil {
+		query = conjuncts(where)
+	}
+	preds := indexablePredicates(table, query)
+	eq := make(map[string]interface{})
+	ranges := make(map[string]*columnRange)
+	for _, pr := range preds {
+		if pr.op == parser.OpEq {
+			if _, ok := eq[pr.part]; !ok {
+				eq[pr.part] = pr.value
+			}
+			continue
+		}
+		r, ok := ranges[pr.part]
+		if !ok {
+			r = &columnRange{}
+			ranges[pr.part] = r
+		}
+		r.add(pr.typ, pr)
+	}
+	better := func(ti execution.TableIndex, ordered bool, best *accessPath) bool {
+		if best == nil {
+			return true
+		}
+		if covers != nil && covers(ti) != covers(best.ti) {
+			return covers(ti)
+		}
+		if ordered != best.ordered {
+			return ordered
+		}
+		return ti.Where != nil && best.ti.Where == nil
+	}
+	follows := func(ti execution.TableIndex, parts []string) bool {
+		_, ranged := ti.Index.(indexing.RangeIndex)
+		return order != nil && followsOrder(table, parts, eq, order.items, ranged)
+	}
+
+	all, err := p.tableIndexes(table)
+	if err != nil {
+		return nil
+	}
+	type candidate struct {
+		ti    execution.TableIndex
+		parts []string
+	}
+	var indexes, trigram []candidate
+	for _, ti := range all {
+		if ti.Where != nil && !implies(table, query, preds, ti.Where) {
+			continue
+		}
+		if _, ok := ti.Index.(*indexing.TrigramIndex); ok {
+			trigram = append(trigram, candidate{ti, indexParts(table, ti)})
+		} else {
+			indexes = append(indexes, candidate{ti, indexParts(table, ti)})
+		}
+	}
+
+	var best *accessPath
+	for _, c := range indexes {
+		ordered := follows(c.ti, c.parts)
+		if key := equalityPrefix(c.parts, eq); len(key) == len(c.parts) && better(c.ti, ordered, best) {
+			best = &accessPath{ti: c.ti, key: key, ordered: ordered}
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	matched := 0
+	for _, c := range indexes {
+		var prefix indexing.Key
+		var r *columnRange
+		if _, ok := c.ti.Index.(indexing.RangeIndex); ok {
+			prefix = equalityPrefix(c.parts, eq)
+			r = ranges[c.parts[len(prefix)]]
+		}
+		n := len(prefix)
+		if r != nil {
+			n++
+		}
+		ordered := follows(c.ti, c.parts)
+		if (n == 0 && c.ti.Where == nil) || n < matched || (n == matched && !better(c.ti, ordered, best)) {
+			continue
+		}
+		if n == 0 {
+			best = &accessPath{ti: c.ti, ordered: ordered}
+			continue
+		}
+		lo, hi := r.bounds(prefix)
+		best, matched = &accessPath{ti: c.ti, lo: lo, hi: hi, ordered: ordered}, n
+	}
+	if matched > 0 {
+		return best
+	}
+
+	// The trigram term that needs the most trigrams is the most selective.
+	var search *accessPath
+	for _, c := range trigram {
+		for _, term := range query {
+			trigrams, min, ok := trigramSearch(table, term, c.parts[0])
+			if ok && (search == nil || min > search.min) {
+				search = &accessPath{ti: c.ti, trigrams: trigrams, min: min}
+			}
+		}
+	}
+	if search != nil {
+		return search
+	}
+	if best == nil && order != nil && order.limited {
+		for _, c := range indexes {
+			if c.ti.Where == nil && follows(c.ti, c.parts) && better(c.ti, true, best) {
+				// From the first NULL on, so that the rows with NULL keys
+				// come too.
+				best = &accessPath{ti: c.ti, lo: &indexing.Bound{Key: indexing.Key{nil}, Inclusive: true}, ordered: true}
+			}
+		}
+	}
+	return best
+}
+
+// followsOrder reports whether reading an index whose parts are parts, by
+// the equalities in eq, returns rows in the order of items. The parts with
+// an equality hold one value, so items may name them anywhere; the others
+// must follow the index's remaining parts, which only an ordered index
+// (ranged) reads in order. Entries of an index sort NULL first, like an
+// ascending ORDER BY; a descending one is never followed.
+func followsOrder(table *catalog.Table, parts []string, eq map[string]interface{}, items []parser.OrderByItem, ranged bool) bool {
+	if len(items) == 0 {
+		return false
+	}
+	next := len(equalityPrefix(parts, eq))
+	for _, item := range items {
+		part, _, ok := indexPart(table, item.Expr)
+		if !ok || item.Desc {
+			return false
+		}
+		if _, constant := eq[part]; constant {
+			continue
+		}
+		if !ranged || next == len(parts) || parts[next] != part {
+			return false
+		}
+		next++
+	}
+	return true
+}
+
+// trigramSearch returns the trigrams that a row must have at least min of
+// to satisfy expr, when expr is `part LIKE 'pattern'` or
+// MATCH(part, 'query') and gives some trigrams.
+func trigramSearch(table *catalog.Table, expr parser.Expression, part string) ([]string, int, bool) {
+	var arg, pattern parser.Expression
+	isLike := false
+	switch e := expr.(type) {
+	case *parser.BinaryExpr:
+		if e.Op != parser.OpLike {
+			return nil, 0, false
+		}
+		arg, pattern, isLike = e.Left, e.Right, true
+	case *parser.FuncCallExpr:
+		if e.Name != "MATCH" || len(e.Args) != 2 {
+			return nil, 0, false
+		}
+		arg, pattern = e.Args[0], e.Args[1]
+	default:
+		return nil, 0, false
+	}
+	lit, ok := pattern.(*parser.LiteralExpr)
+	if !ok {
+		return nil, 0, false
+	}
+	text, ok := lit.Value.(string)
+	if !ok {
+		return nil, 0, false
+	}
+	if p, _, ok := indexPart(table, arg); !ok || p != part {
+		return nil, 0, false
+	}
+
+	if isLike {
+		trigrams := indexing.LikeTrigrams(text)
+		return trigrams, len(trigrams), len(trigrams) > 0
+	}
+	trigrams := indexing.Trigrams(text)
+	return trigrams, indexing.MatchMinimum(len(trigrams)), len(trigrams) > 0
+}
+
+// indexScan returns the operator that reads the rows of table through the
+// index access path for a WHERE clause, or nil when no index applies. It
+// reports whether the rows come in the order of order, which may be nil.
+func (p *Planner) indexScan(table *catalog.Table, hf *storage.HeapFile, where parser.Expression, order *ordering, schema []catalog.Column) (execution.Iterator, bool) {
+	path := p.accessPath(table, where, order, nil)
+	if path == nil {
+		return nil, false
+	}
+	if path.trigrams != nil {
+		fmt.Printf("[Planner] Using TrigramScan on %s (%s)\n", indexColumns(table, path.ti), path.ti.Name)
+		return execution.NewTrigramScan(path.ti.Index.(*indexing.TrigramIndex), hf, path.trigrams, path.min, schema), false
+	}
+	if path.key != nil || path.lo == nil && path.hi == nil {
+		fmt.Printf("[Planner] Using IndexScan on %s (%s)\n", indexColumns(table, path.ti), path.ti.Name)
+		return execution.NewIndexScan(path.ti.Index, hf, path.key, schema), path.ordered
+	}
+	fmt.Printf("[Planner] Using IndexRangeScan on %s (%s)\n", indexColumns(table, path.ti), path.ti.Name)
+	return execution.NewIndexRangeScan(path.ti.Index.(indexing.RangeIndex), hf, path.lo, path.hi, schema), path.ordered
+}
+
+// indexOnlyScan returns an IndexOnlyScan for a query on table that reads no
+// columns but those in cols, or nil when no covering index has an access path
+// for the WHERE clause. An index on an expression and a trigram index never
+// cover, nor does an index with a DECIMAL key column, whose key holds the
+// normalized number rather than the text stored in the row. Its rows hold
+// the index's columns, so the caller must project the query's columns from
+// them. Like indexScan, it reports whether they come in the order of order.
+func (p *Planner) indexOnlyScan(table *catalog.Table, where parser.Expression, order *ordering, cols map[int]bool) (execution.Iterator, bool) {
+	covers := func(ti execution.TableIndex) bool {
+		if _, ok := ti.Index.(*indexing.TrigramIndex); ok {
+			return false
+		}
+		stored := make(map[int]bool)
+		for _, col := range ti.Columns {
+			if col == -1 || table.Columns[col].Type == catalog.TypeDecimal {
+				return false
+			}
+		}
+		for _, col := range append(append([]int(nil), ti.Columns...), ti.Include...) {
+			stored[col] = true
+		}
+		for col := range cols {
+			if !stored[col] {
+				return false
+			}
+		}
+		return true
+	}
+	path := p.accessPath(table, where, order, covers)
+	if path == nil || !covers(path.ti) {
+		return nil, false
+	}
+
+	var schema []catalog.Column
+	for _, col := range append(append([]int(nil), path.ti.Columns...), path.ti.Include...) {
+		c := table.Columns[col]
+		c.TableName = table.Name
+		schema = append(schema, c)
+	}
+	fmt.Printf("[Planner] Using IndexOnlyScan on %s (%s)\n", indexColumns(table, path.ti), path.ti.Name)
+	return execution.NewIndexOnlyScan(path.ti.Index, path.key, path.lo, path.hi, schema), path.ordered
+}
+
+// equalityPrefix returns the values of the leading index parts that have an
+// equality in eq.
+func equalityPrefix(parts []string, eq map[string]interface{}) indexing.Key {
+	var key indexing.Key
+	for _, part := range parts {
+		v, ok := eq[part]
+		if !ok {
+			break
+		}
+		key = append(key, v)
+	}
+	return key
+}
+
+type bound struct {
+	value     interface{}
+	inclusive bool
+}
+
+// columnRange is the tightest pair of bounds the comparisons on one column
+// give.
+type columnRange struct {
+	lo, hi *bound
+}
+
+func (r *columnRange) add(typ catalog.ColumnType, pr predicate) {
+	b := &bound{value: pr.value, inclusive: pr.op == parser.OpGte || pr.op == parser.OpLte}
+	switch pr.op {
+	case parser.OpGt, parser.OpGte:
+		if r.lo == nil || tighter(typ, b, r.lo, 1) {
+			r.lo = b
+		}
+	case parser.OpLt, parser.OpLte:
+		if r.hi == nil || tighter(typ, b, r.hi, -1) {
+			r.hi = b
+		}
+	}
+}
+
+// bounds returns the index range for the equalities in prefix followed by r
+// on the next column; r may be nil. Without a lower bound on that column,
+// the range starts after its NULLs.
+func (r *columnRange) bounds(prefix indexing.Key) (lo, hi *indexing.Bound) {
+	with := func(v interface{}) indexing.Key {
+		return append(append(indexing.Key{}, prefix...), v)
+	}
+	switch {
+	case r != nil && r.lo != nil:
+		lo = &indexing.Bound{Key: with(r.lo.value), Inclusive: r.lo.inclusive}
+	case r != nil:
+		lo = &indexing.Bound{Key: with(nil), Inclusive: false}
+	default:
+		lo = &indexing.Bound{Key: prefix, Inclusive: true}
+	}
+	switch {
+	case r != nil && r.hi != nil:
+		hi = &indexing.Bound{Key: with(r.hi.value), Inclusive: r.hi.inclusive}
+	case len(prefix) > 0:
+		hi = &indexing.Bound{Key: prefix, Inclusive: true}
+	}
+	return lo, hi
+}
+
+// tighter reports whether bound a narrows the range more than b. dir is 1
+// for lower bounds and -1 for upper bounds.
+func tighter(typ catalog.ColumnType, a, b *bound, dir int) bool {
+	c, err := indexing.CompareValues(typ, a.value, b.value)
+	if err != nil {
+		return false
+	}
+	return c*dir > 0 || (c == 0 && !a.inclusive)
+}
+
+// predicate is a `part op value` comparison, where part is a column of the
+// scanned table or a function of one as indexPart writes it, with the value
+// already cast to the part's type.
+type predicate struct {
+	part  string
+	typ   catalog.ColumnType
+	op    parser.Operator
+	value interface{}
+}
+
+// implies reports whether a predicate on the same part as pr holds whenever
+// pr does, e.g. a = 5 implies a > 1 and a >= 3 implies a > 2.
+func (pr predicate) implies(other predicate) bool {
+	c, err := indexing.CompareValues(pr.typ, pr.value, other.value)
+	if err != nil {
+		return false
+	}
+	switch other.op {
+	case parser.OpEq:
+		return pr.op == parser.OpEq && c == 0
+	case parser.OpGt:
+		return (pr.op == parser.OpGt && c >= 0) || ((pr.op == parser.OpEq || pr.op == parser.OpGte) && c > 0)
+	case parser.OpGte:
+		return (pr.op == parser.OpEq || pr.op == parser.OpGt || pr.op == parser.OpGte) && c >= 0
+	case parser.OpLt:
+		return (pr.op == parser.OpLt && c <= 0) || ((pr.op == parser.OpEq || pr.op == parser.OpLte) && c < 0)
+	case parser.OpLte:
+		return (pr.op == parser.OpEq || pr.op == parser.OpLt || pr.op == parser.OpLte) && c <= 0
+	}
+	return false
+}
+
+// flipped gives the operator to use when a comparison's operands are swapped.
+var flipped = map[parser.Operator]parser.Operator{
+	parser.OpEq:  parser.OpEq,
+	parser.OpLt:  parser.OpGt,
+	parser.OpLte: parser.OpGte,
+	parser.OpGt:  parser.OpLt,
+	parser.OpGte: parser.OpLte,
+}
+
+func indexablePredicates(table *catalog.Table, exprs []parser.Expression) []predicate {
+	var preds []predicate
+	for _, expr := range exprs {
+		bin, ok := expr.(*parser.BinaryExpr)
+		if !ok {
+			continue
+		}
+		op, ok := flipped[bin.Op]
+		if !ok {
+			continue
+		}
+		side := bin.Left
+		lit, isLit := bin.Right.(*parser.LiteralExpr)
+		if isLit {
+			op = bin.Op
+		} else {
+			side = bin.Right
+			if lit, isLit = bin.Left.(*parser.LiteralExpr); !isLit {
+				continue
+			}
+		}
+		// A comparison with NULL matches nothing, so it never uses an index.
+		if lit.Value == nil {
+			continue
+		}
+
+		part, typ, ok := indexPart(table, side)
+		if !ok {
+			continue
+		}
+		val, err := execution.CastValue(lit.Value, typ)
+		if err != nil || !indexing.ValidKey(typ, val) {
+			continue
+		}
+		preds = append(preds, predicate{part: part, typ: typ, op: op, value: val})
+	}
+	return preds
+}
+
+// indexPart returns the text and type of a column of table, or of a function
+// call on one, as index parts and predicates are matched by. Columns are
+// written without their table's name, so users.email and email match.
+func indexPart(table *catalog.Table, expr parser.Expression) (string, catalog.ColumnType, bool) {
+	switch expr.(type) {
+	case *parser.IdentifierExpr, *parser.FuncCallExpr:
+	default:
+		return "", "", false
+	}
+	resolved := resolveColumns(table, expr)
+	typ, err := execution.ExprType(resolved, table.Columns)
+	if err != nil {
+		return "", "", false
+	}
+	return parser.FormatExpr(resolved), typ, true
+}
+
+// indexParts returns the parts of an index's key, as indexPart writes them.
+func indexParts(table *catalog.Table, ti execution.TableIndex) []string {
+	parts := make([]string, len(ti.Exprs))
+	for i, expr := range ti.Exprs {
+		parts[i], _, _ = indexPart(table, expr)
+	}
+	return parts
+}
+
+// resolveColumns returns a copy of expr whose identifiers that name columns
+// of table are written without the table's name.
+func resolveColumns(table *catalog.Table, expr parser.Expression) parser.Expression {
+	switch e := expr.(type) {
+	case *parser.BinaryExpr:
+		return &parser.BinaryExpr{Left: resolveColumns(table, e.Left), Op: e.Op, Right: resolveColumns(table, e.Right)}
+	case *parser.IsNullExpr:
+		return &parser.IsNullExpr{Expr: resolveColumns(table, e.Expr), Not: e.Not}
+	case *parser.FuncCallExpr:
+		call := &parser.FuncCallExpr{Name: e.Name}
+		for _, arg := range e.Args {
+			call.Args = append(call.Args, resolveColumns(table, arg))
+		}
+		return call
+	case *parser.IdentifierExpr:
+		for _, c := range table.Columns {
+			if c.Name == e.Name || table.Name+"."+c.Name == e.Name {
+				return &parser.IdentifierExpr{Name: c.Name}
+			}
+		}
+	}
+	return expr
+}
+
+// implies reports whether every row that satisfies the conjuncts of a query
+// also satisfies the predicate of a partial index. Each conjunct of the
+// predicate must appear in the query, follow from one of the query's
+// comparisons on the same part (amount > 100 implies amount > 0), or be an
+// IS NOT NULL on a part that the query compares with a value.
+func implies(table *catalog.Table, query []parser.Expression, preds []predicate, where parser.Expression) bool {
+	written := make(map[string]bool)
+	for _, c := range query {
+		written[parser.FormatExpr(resolveColumns(table, c))] = true
+	}
+	for _, c := range conjuncts(where) {
+		if written[parser.FormatExpr(resolveColumns(table, c))] {
+			continue
+		}
+		if isNull, ok := c.(*parser.IsNullExpr); ok && isNull.Not {
+			part, _, ok := indexPart(table, isNull.Expr)
+			if !ok || !comparesPart(preds, part) {
+				return false
+			}
+			continue
+		}
+		want := indexablePredicates(table, []parser.Expression{c})
+		if len(want) == 0 || !impliedBy(preds, want[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+func comparesPart(preds []predicate, part string) bool {
+	for _, pr := range preds {
+		if pr.part == part {
+			return true
+		}
+	}
+	return false
+}
+
+func impliedBy(preds []predicate, want predicate) bool {
+	for _, pr := range preds {
+		if pr.part == want.part && pr.implies(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// conjuncts splits an expression into the terms ANDed together at its top.
+func conjuncts(expr parser.Expression) []parser.Expression {
+	if bin, ok := expr.(*parser.BinaryExpr); ok && bin.Op == parser.OpAnd {
+		return append(conjuncts(bin.Left), conjuncts(bin.Right)...)
+	}
+	return []parser.Expression{expr}
+}
+
+func columnIndex(table *catalog.Table, name string) int {
+	for i, col := range table.Columns {
+		if col.Name == name {
+			return i
+		}
+	}
+	return -1
+}
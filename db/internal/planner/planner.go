@@ -7,65 +7,20 @@ import (
 	"minibank/internal/indexing"
 	"minibank/internal/parser"
 	"minibank/internal/storage"
-	"strconv"
+	"sort"
 )
 
 type Planner struct {
 	Catalog *catalog.Catalog
 	Storage *storage.Engine
-	Indices map[string]*indexing.HashIndex
+	Indices map[string]indexing.Index
+	// SortMemory is the memory in bytes an ORDER BY may use before it
+	// spills to temporary files; 0 for execution.DefaultSortMemory.
+	SortMemory int
 }
 
 func NewPlanner(cat *catalog.Catalog, store *storage.Engine) *Planner {
-	return &Planner{Catalog: cat, Storage: store, Indices: make(map[string]*indexing.HashIndex)}
-}
-
-func (p *Planner) RebuildIndices() error {
-	fmt.Println("Rebuilding indices...")
-	p.Indices = make(map[string]*indexing.HashIndex)
-	for _, table := range p.Catalog.Tables {
-		if len(table.Indexes) == 0 {
-			continue
-		}
-
-		hf, err := p.Storage.GetHeapFile(table.Name)
-		if err != nil {
-			return err
-		}
-
-		// Create/Init indices in memory
-		for _, idxDef := range table.Indexes {
-			key := fmt.Sprintf("%s.%s", table.Name, idxDef.Column)
-			p.Indices[key] = indexing.NewHashIndex()
-			fmt.Printf("Initialized index: %s\n", key)
-		}
-
-		// Scan table and populate
-		iter := hf.Iterator()
-		for {
-			data, rid, err := iter.Next()
-			if err != nil {
-				return err
-			}
-			if data == nil {
-				break
-			}
-
-			tuple, err := storage.DeserializeTuple(data, table.Columns)
-			if err != nil {
-				return err
-			}
-
-			for i, col := range table.Columns {
-				// efficient lookup? loop is fine for rebuild
-				key := fmt.Sprintf("%s.%s", table.Name, col.Name)
-				if idx, ok := p.Indices[key]; ok {
-					idx.Insert(tuple.Cells[i].Value, rid)
-				}
-			}
-		}
-	}
-	return nil
+	return &Planner{Catalog: cat, Storage: store, Indices: make(map[string]indexing.Index)}
 }
 
 func (p *Planner) CreatePlan(stmt parser.ASTNode) (execution.Iterator, error) {
@@ -78,6 +33,12 @@ func (p *Planner) CreatePlan(stmt parser.ASTNode) (execution.Iterator, error) {
 		return p.planUpdate(n)
 	case *parser.DeleteStmt:
 		return p.planDelete(n)
+	case *parser.VacuumStmt:
+		return p.planVacuum(n)
+	case *parser.ReindexStmt:
+		return p.planReindex(n)
+	case *parser.ShowIndexesStmt:
+		return p.planShowIndexes(n)
 	}
 	return nil, fmt.Errorf("unsupported statement type")
 }
@@ -93,39 +54,45 @@ func (p *Planner) planSelect(stmt *parser.SelectStmt) (execution.Iterator, error
 		return nil, err
 	}
 
-	var root execution.Iterator
-
-	usedIndex := false
+	schema := enrichSchema(table.Columns, stmt.TableName)
+	var where parser.Expression
 	if stmt.Where != nil {
-		if binExpr, ok := stmt.Where.Expr.(*parser.BinaryExpr); ok {
-			if binExpr.Op == parser.OpEq {
-				if ident, ok := binExpr.Left.(*parser.IdentifierExpr); ok {
-					if lit, ok := binExpr.Right.(*parser.LiteralExpr); ok {
-						var colType catalog.ColumnType
-						for _, c := range table.Columns {
-							if c.Name == ident.Name || (stmt.TableName+"."+c.Name) == ident.Name {
-								colType = c.Type
-								break
-							}
-						}
-
-						val, err := castLiteral(lit.Value, colType)
-						if err == nil {
-							key := fmt.Sprintf("%s.%s", stmt.TableName, ident.Name)
-							if idx, ok := p.Indices[key]; ok {
-								fmt.Printf("[Planner] Using IndexScan on %s\n", key)
-								root = execution.NewIndexScan(idx, hf, val, enrichSchema(table.Columns, stmt.TableName))
-								usedIndex = true
-							}
-						}
-					}
+		where = stmt.Where.Expr
+		if len(execution.Aggregates(where)) > 0 {
+			return nil, fmt.Errorf("aggregate functions are not allowed in WHERE; use HAVING")
+		}
+	}
+	grouping := isGrouped(stmt)
+	// Only a single-table query can take its order from an index, and
+	// only rows that are not grouped keep it.
+	var order *ordering
+	if len(stmt.OrderBy) > 0 && stmt.Join == nil && !grouping {
+		order = &ordering{items: stmt.OrderBy, limited: stmt.Limit != nil}
+	}
+	var root execution.Iterator
+	ordered := false
+	if where != nil || order != nil && order.limited {
+		if stmt.Join == nil {
+			if cols, ok := referencedColumns(table, stmt); ok {
+				root, ordered = p.indexOnlyScan(table, where, order, cols)
+			}
+			if root != nil && stmt.Fields == nil {
+				// Put the index's columns back in table order.
+				fields := make([]parser.Expression, len(schema))
+				for i, c := range schema {
+					fields[i] = &parser.IdentifierExpr{Name: c.TableName + "." + c.Name}
+				}
+				if root, err = project(root, fields); err != nil {
+					return nil, err
 				}
 			}
 		}
+		if root == nil {
+			root, ordered = p.indexScan(table, hf, where, order, schema)
+		}
 	}
-
-	if !usedIndex {
-		root = execution.NewSeqScan(hf, enrichSchema(table.Columns, stmt.TableName))
+	if root == nil {
+		root = execution.NewSeqScan(hf, schema)
 	}
 
 	if stmt.Join != nil {
@@ -147,35 +114,179 @@ func (p *Planner) planSelect(stmt *parser.SelectStmt) (execution.Iterator, error
 		root = execution.NewFilter(root, stmt.Where.Expr)
 	}
 
-	// Project
-	if len(stmt.Fields) > 0 && stmt.Fields[0] != "*" {
-		outSchema := []catalog.Column{}
-		inSchema := root.Schema()
-		for _, f := range stmt.Fields {
-			found := false
-			for _, col := range inSchema {
-				if f == col.Name {
-					outSchema = append(outSchema, col)
-					found = true
-					break
-				}
-				fqn := col.TableName + "." + col.Name
-				if f == fqn {
-					outSchema = append(outSchema, col)
-					found = true
-					break
+	if grouping {
+		if root, err = p.planAggregate(root, stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	// Sort before projecting, so that ORDER BY can use any column.
+	if ordered {
+		fmt.Println("[Planner] Rows come in ORDER BY order from the index; no Sort")
+	} else if len(stmt.OrderBy) > 0 {
+		keys := make([]execution.SortKey, len(stmt.OrderBy))
+		for i, item := range stmt.OrderBy {
+			keys[i] = execution.SortKey{Expr: item.Expr, Desc: item.Desc}
+		}
+		sorted, err := execution.NewSort(root, keys, p.SortMemory)
+		if err != nil {
+			return nil, err
+		}
+		if stmt.Limit != nil {
+			sorted.Limit = *stmt.Limit + stmt.Offset
+			fmt.Printf("[Planner] Using top-N Sort (N = %d)\n", sorted.Limit)
+		}
+		root = sorted
+	}
+	if stmt.Limit != nil || stmt.Offset > 0 {
+		count := -1
+		if stmt.Limit != nil {
+			count = *stmt.Limit
+		}
+		root = execution.NewLimit(root, count, stmt.Offset)
+	}
+
+	if stmt.Fields != nil {
+		if root, err = project(root, stmt.Fields); err != nil {
+			return nil, err
+		}
+	}
+
+	return root, nil
+}
+
+// planAggregate groups the rows of root for a SELECT with GROUP BY, HAVING
+// or aggregates, computing every aggregate its fields, HAVING and ORDER BY
+// use, and checks that these only read grouped columns.
+func (p *Planner) planAggregate(root execution.Iterator, stmt *parser.SelectStmt) (execution.Iterator, error) {
+	if stmt.Fields == nil {
+		return nil, fmt.Errorf("SELECT * cannot be used with GROUP BY or aggregates; list the columns")
+	}
+	exprs := append([]parser.Expression(nil), stmt.Fields...)
+	if stmt.Having != nil {
+		exprs = append(exprs, stmt.Having)
+	}
+	for _, item := range stmt.OrderBy {
+		exprs = append(exprs, item.Expr)
+	}
+	var aggs []*parser.AggregateExpr
+	seen := make(map[string]bool)
+	for _, expr := range exprs {
+		for _, agg := range execution.Aggregates(expr) {
+			if text := parser.FormatExpr(agg); !seen[text] {
+				seen[text] = true
+				aggs = append(aggs, agg)
+			}
+		}
+	}
+
+	agg, err := execution.NewAggregate(root, stmt.GroupBy, aggs)
+	if err != nil {
+		return nil, err
+	}
+	for _, expr := range exprs {
+		if err := agg.Check(expr); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("[Planner] Using hash Aggregate (%d GROUP BY expressions, %d aggregates)\n", len(stmt.GroupBy), len(aggs))
+	if stmt.Having != nil {
+		return agg.Having(stmt.Having)
+	}
+	return agg, nil
+}
+
+// project returns the fields of root's rows, each the column ColumnOf finds
+// for it.
+func project(root execution.Iterator, fields []parser.Expression) (execution.Iterator, error) {
+	in := root.Schema()
+	cols := make([]int, len(fields))
+	schema := make([]catalog.Column, len(fields))
+	for i, f := range fields {
+		j := execution.ColumnOf(in, f)
+		if j == -1 {
+			return nil, fmt.Errorf("column %s not found", parser.FormatExpr(f))
+		}
+		cols[i], schema[i] = j, in[j]
+	}
+	return execution.NewProject(root, cols, schema), nil
+}
+
+// isGrouped reports whether a SELECT groups its rows: it has GROUP BY or
+// HAVING, or uses an aggregate in its fields or ORDER BY.
+func isGrouped(stmt *parser.SelectStmt) bool {
+	if len(stmt.GroupBy) > 0 || stmt.Having != nil {
+		return true
+	}
+	for _, f := range stmt.Fields {
+		if len(execution.Aggregates(f)) > 0 {
+			return true
+		}
+	}
+	for _, item := range stmt.OrderBy {
+		if len(execution.Aggregates(item.Expr)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// referencedColumns returns the positions of the columns of table that a
+// single-table SELECT reads, in its fields, its WHERE clause, its GROUP BY
+// and HAVING, and its ORDER BY. It reports false when a reference does not
+// resolve to a column, leaving the error to the usual plan.
+func referencedColumns(table *catalog.Table, stmt *parser.SelectStmt) (map[int]bool, bool) {
+	cols := make(map[int]bool)
+	if stmt.Fields == nil {
+		for i := range table.Columns {
+			cols[i] = true
+		}
+	}
+	schema := enrichSchema(table.Columns, table.Name)
+
+	var walk func(expr parser.Expression) bool
+	walk = func(expr parser.Expression) bool {
+		switch e := expr.(type) {
+		case *parser.BinaryExpr:
+			return walk(e.Left) && walk(e.Right)
+		case *parser.IsNullExpr:
+			return walk(e.Expr)
+		case *parser.FuncCallExpr:
+			for _, arg := range e.Args {
+				if !walk(arg) {
+					return false
 				}
 			}
-			if !found {
-				return nil, fmt.Errorf("column %s not found", f)
+			return true
+		case *parser.AggregateExpr:
+			return e.Arg == nil || walk(e.Arg)
+		case *parser.LiteralExpr:
+			return true
+		case *parser.IdentifierExpr:
+			if j := execution.ColumnOf(schema, e); j != -1 {
+				cols[j] = true
+				return true
 			}
 		}
-		root = execution.NewProject(root, stmt.Fields, outSchema)
-	} else {
-		// implicit *
+		return false
 	}
-
-	return root, nil
+	exprs := append([]parser.Expression(nil), stmt.Fields...)
+	exprs = append(exprs, stmt.GroupBy...)
+	if stmt.Where != nil {
+		exprs = append(exprs, stmt.Where.Expr)
+	}
+	if stmt.Having != nil {
+		exprs = append(exprs, stmt.Having)
+	}
+	for _, item := range stmt.OrderBy {
+		exprs = append(exprs, item.Expr)
+	}
+	for _, expr := range exprs {
+		if !walk(expr) {
+			return nil, false
+		}
+	}
+	return cols, true
 }
 
 func (p *Planner) planInsert(stmt *parser.InsertStmt) (execution.Iterator, error) {
@@ -183,15 +294,19 @@ func (p *Planner) planInsert(stmt *parser.InsertStmt) (execution.Iterator, error
 	if !exists {
 		return nil, fmt.Errorf("table %s not found", stmt.TableName)
 	}
+	if catalog.IsSystemTable(stmt.TableName) {
+		return nil, fmt.Errorf("system table %s is read-only", stmt.TableName)
+	}
 
-	hf, err := p.Storage.GetHeapFile(stmt.TableName)
+	values, err := insertValues(stmt, table.Columns)
 	if err != nil {
 		return nil, err
 	}
-
-	rows := [][]interface{}{stmt.Values}
-	enrichedCols := enrichSchema(table.Columns, stmt.TableName)
-	return execution.NewInsert(hf, rows, enrichedCols, p.Indices), nil
+	target, err := p.target(table)
+	if err != nil {
+		return nil, err
+	}
+	return execution.NewInsert(target, [][]interface{}{values}), nil
 }
 
 func (p *Planner) planUpdate(stmt *parser.UpdateStmt) (execution.Iterator, error) {
@@ -199,17 +314,15 @@ func (p *Planner) planUpdate(stmt *parser.UpdateStmt) (execution.Iterator, error
 	if !exists {
 		return nil, fmt.Errorf("table %s not found", stmt.TableName)
 	}
-	hf, err := p.Storage.GetHeapFile(stmt.TableName)
+	if catalog.IsSystemTable(stmt.TableName) {
+		return nil, fmt.Errorf("system table %s is read-only", stmt.TableName)
+	}
+	target, err := p.target(table)
 	if err != nil {
 		return nil, err
 	}
-
-	var root execution.Iterator = execution.NewSeqScan(hf, table.Columns)
-	if stmt.Where != nil {
-		root = execution.NewFilter(root, stmt.Where.Expr)
-	}
-
-	return execution.NewUpdate(hf, root, stmt.SetPairs), nil
+	root := p.scan(table, target.HeapFile, stmt.Where)
+	return execution.NewUpdate(target, root, stmt.SetPairs), nil
 }
 
 func (p *Planner) planDelete(stmt *parser.DeleteStmt) (execution.Iterator, error) {
@@ -217,17 +330,259 @@ func (p *Planner) planDelete(stmt *parser.DeleteStmt) (execution.Iterator, error
 	if !exists {
 		return nil, fmt.Errorf("table %s not found", stmt.TableName)
 	}
-	hf, err := p.Storage.GetHeapFile(stmt.TableName)
+	if catalog.IsSystemTable(stmt.TableName) {
+		return nil, fmt.Errorf("system table %s is read-only", stmt.TableName)
+	}
+	target, err := p.target(table)
 	if err != nil {
 		return nil, err
 	}
+	root := p.scan(table, target.HeapFile, stmt.Where)
+	return execution.NewDelete(target, root), nil
+}
 
-	var root execution.Iterator = execution.NewSeqScan(hf, table.Columns)
-	if stmt.Where != nil {
-		root = execution.NewFilter(root, stmt.Where.Expr)
+// target returns table as the target of a DML statement, linked through
+// foreign keys to every table they connect it to, since the actions of one
+// may write a table that the next references.
+func (p *Planner) target(table *catalog.Table) (*execution.Target, error) {
+	return p.linkTarget(table, make(map[string]*execution.Target))
+}
+
+func (p *Planner) linkTarget(table *catalog.Table, targets map[string]*execution.Target) (*execution.Target, error) {
+	if t, ok := targets[table.Name]; ok {
+		return t, nil
+	}
+	hf, err := p.Storage.GetHeapFile(table.Name)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := p.tableIndexes(table)
+	if err != nil {
+		return nil, err
+	}
+	checks, err := execution.NewChecks(table)
+	if err != nil {
+		return nil, err
+	}
+	t := &execution.Target{
+		Name:     table.Name,
+		HeapFile: hf,
+		Schema:   enrichSchema(table.Columns, table.Name),
+		Indexes:  indexes,
+		Checks:   checks,
+	}
+	targets[table.Name] = t
+
+	// Each foreign key is linked when the table that has it is reached.
+	for _, def := range table.ForeignKeys {
+		ref, ok := p.Catalog.GetTable(def.RefTable)
+		if !ok {
+			return nil, fmt.Errorf("foreign key %s references missing table %s", def.Name, def.RefTable)
+		}
+		refTarget, err := p.linkTarget(ref, targets)
+		if err != nil {
+			return nil, err
+		}
+		fk := &execution.ForeignKey{
+			Name:       def.Name,
+			Table:      t,
+			RefTable:   refTarget,
+			OnDelete:   def.OnDelete,
+			OnUpdate:   def.OnUpdate,
+			Columns:    make([]int, len(def.Columns)),
+			RefColumns: make([]int, len(def.RefColumns)),
+		}
+		for i := range def.Columns {
+			fk.Columns[i] = columnIndex(table, def.Columns[i])
+			fk.RefColumns[i] = columnIndex(ref, def.RefColumns[i])
+		}
+		t.References = append(t.References, fk)
+		refTarget.Referenced = append(refTarget.Referenced, fk)
+	}
+	for _, other := range p.Catalog.UserTables() {
+		for _, def := range other.ForeignKeys {
+			if def.RefTable != table.Name {
+				continue
+			}
+			if _, err := p.linkTarget(other, targets); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return t, nil
+}
+
+// scan reads the rows of table that match where, through an index when one
+// applies.
+func (p *Planner) scan(table *catalog.Table, hf *storage.HeapFile, where *parser.WhereClause) execution.Iterator {
+	schema := enrichSchema(table.Columns, table.Name)
+	if where == nil {
+		return execution.NewSeqScan(hf, schema)
+	}
+	root, _ := p.indexScan(table, hf, where.Expr, nil, schema)
+	if root == nil {
+		root = execution.NewSeqScan(hf, schema)
+	}
+	return execution.NewFilter(root, where.Expr)
+}
+
+func (p *Planner) planVacuum(stmt *parser.VacuumStmt) (execution.Iterator, error) {
+	var names []string
+	if stmt.TableName != "" {
+		if _, exists := p.Catalog.GetTable(stmt.TableName); !exists {
+			return nil, fmt.Errorf("table %s not found", stmt.TableName)
+		}
+		names = []string{stmt.TableName}
+	} else {
+		// The system tables are only compacted when named: a bare VACUUM
+		// leaves the catalog where it is.
+		for _, t := range p.Catalog.UserTables() {
+			names = append(names, t.Name)
+		}
+		sort.Strings(names)
+	}
+
+	var targets []execution.VacuumTarget
+	for _, name := range names {
+		table, _ := p.Catalog.GetTable(name)
+		hf, err := p.Storage.GetHeapFile(name)
+		if err != nil {
+			return nil, err
+		}
+		indexes, err := p.tableIndexes(table)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, execution.VacuumTarget{
+			TableName: name,
+			HeapFile:  hf,
+			Schema:    table.Columns,
+			Indexes:   indexes,
+		})
+	}
+	return execution.NewVacuum(targets), nil
+}
+
+func (p *Planner) planReindex(stmt *parser.ReindexStmt) (execution.Iterator, error) {
+	var tables []*catalog.Table
+	only := ""
+	if stmt.Index != nil {
+		table, def, err := p.findIndex(*stmt.Index)
+		if err != nil {
+			return nil, err
+		}
+		tables, only = []*catalog.Table{table}, def.Name
+	} else {
+		table, exists := p.Catalog.GetTable(stmt.TableName)
+		if !exists {
+			return nil, fmt.Errorf("table %s not found", stmt.TableName)
+		}
+		if catalog.IsSystemTable(stmt.TableName) {
+			return nil, fmt.Errorf("system table %s has no indexes", stmt.TableName)
+		}
+		tables = []*catalog.Table{table}
+	}
+
+	var targets []execution.ReindexTarget
+	for _, table := range tables {
+		hf, err := p.Storage.GetHeapFile(table.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, def := range table.Indexes {
+			if only != "" && def.Name != only {
+				continue
+			}
+			idx, ok := p.Indices[indexKey(table.Name, def.Name)]
+			if !ok {
+				return nil, fmt.Errorf("index %s is not open", def.Name)
+			}
+			ti, err := execution.NewTableIndex(table, def, idx)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, execution.ReindexTarget{
+				TableName: table.Name,
+				HeapFile:  hf,
+				Schema:    table.Columns,
+				Index:     ti,
+			})
+		}
+	}
+	return execution.NewReindex(targets), nil
+}
+
+func (p *Planner) planShowIndexes(stmt *parser.ShowIndexesStmt) (execution.Iterator, error) {
+	tables := p.Catalog.UserTables()
+	if stmt.TableName != "" {
+		table, exists := p.Catalog.GetTable(stmt.TableName)
+		if !exists {
+			return nil, fmt.Errorf("table %s not found", stmt.TableName)
+		}
+		tables = []*catalog.Table{table}
 	}
 
-	return execution.NewDelete(hf, root), nil
+	var infos []execution.IndexInfo
+	for _, table := range tables {
+		for _, def := range table.Indexes {
+			idx, ok := p.Indices[indexKey(table.Name, def.Name)]
+			if !ok {
+				return nil, fmt.Errorf("index %s is not open", def.Name)
+			}
+			infos = append(infos, execution.IndexInfo{TableName: table.Name, Def: def, Index: idx})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].TableName != infos[j].TableName {
+			return infos[i].TableName < infos[j].TableName
+		}
+		return infos[i].Def.Name < infos[j].Def.Name
+	})
+	return execution.NewShowIndexes(infos), nil
+}
+
+// insertValues lines the statement's values up with the table's columns.
+// With a column list, columns that are not listed get their DEFAULT, or
+// NULL.
+func insertValues(stmt *parser.InsertStmt, cols []catalog.Column) ([]interface{}, error) {
+	if len(stmt.Columns) == 0 {
+		if len(stmt.Values) != len(cols) {
+			return nil, fmt.Errorf("INSERT has %d values but table %s has %d columns", len(stmt.Values), stmt.TableName, len(cols))
+		}
+		return stmt.Values, nil
+	}
+	if len(stmt.Values) != len(stmt.Columns) {
+		return nil, fmt.Errorf("INSERT has %d columns but %d values", len(stmt.Columns), len(stmt.Values))
+	}
+
+	values := make([]interface{}, len(cols))
+	for i, col := range cols {
+		v, err := execution.DefaultValue(col)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	seen := make(map[int]bool)
+	for i, name := range stmt.Columns {
+		pos := -1
+		for j, c := range cols {
+			if c.Name == name {
+				pos = j
+				break
+			}
+		}
+		if pos == -1 {
+			return nil, fmt.Errorf("column %s not found in table %s", name, stmt.TableName)
+		}
+		if seen[pos] {
+			return nil, fmt.Errorf("column %s specified more than once", name)
+		}
+		seen[pos] = true
+		values[pos] = stmt.Values[i]
+	}
+	return values, nil
 }
 
 func enrichSchema(cols []catalog.Column, tableName string) []catalog.Column {
@@ -238,18 +593,3 @@ func enrichSchema(cols []catalog.Column, tableName string) []catalog.Column {
 	}
 	return newCols
 }
-
-func castLiteral(val interface{}, targetType catalog.ColumnType) (interface{}, error) {
-	if raw, ok := val.(parser.RawNumber); ok {
-		sRaw := string(raw)
-		switch targetType {
-		case catalog.TypeInt:
-			return strconv.ParseInt(sRaw, 10, 64)
-		case catalog.TypeDecimal:
-			return sRaw, nil
-		default:
-			return sRaw, nil
-		}
-	}
-	return val, nil
-}
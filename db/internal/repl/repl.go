@@ -6,12 +6,10 @@ import (
 	"minibank/internal/catalog"
 	"minibank/internal/errors"
 	"minibank/internal/execution"
-	"minibank/internal/indexing"
 	"minibank/internal/parser"
 	"minibank/internal/planner"
 	"minibank/internal/storage"
 	"os"
-	"path/filepath"
 	"strings"
 )
 
@@ -50,6 +48,10 @@ func (r *REPL) Run() {
 		if input == "" {
 			continue
 		}
+		if input == ".stats" {
+			r.printStats()
+			continue
+		}
 
 		if err := r.Execute(input); err != nil {
 			if dbErr, ok := err.(*errors.DBError); ok {
@@ -64,6 +66,17 @@ func (r *REPL) Run() {
 	}
 }
 
+func (r *REPL) printStats() {
+	st := r.Storage.BufferPoolStats()
+	total := st.Hits + st.Misses
+	ratio := 0.0
+	if total > 0 {
+		ratio = float64(st.Hits) / float64(total) * 100
+	}
+	fmt.Printf("Buffer pool: %d/%d pages resident\n", st.Resident, st.Capacity)
+	fmt.Printf("Hits: %d  Misses: %d  Hit ratio: %.1f%%  Evictions: %d\n", st.Hits, st.Misses, ratio, st.Evictions)
+}
+
 func (r *REPL) Execute(sql string) error {
 	lexer := parser.NewLexer(sql)
 
@@ -73,12 +86,48 @@ func (r *REPL) Execute(sql string) error {
 		return fmt.Errorf("parse error: %w", err)
 	}
 
+	r.Storage.Begin()
+	if err := r.execute(ast); err != nil {
+		if abortErr := r.Storage.Abort(); abortErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, abortErr)
+		}
+		if parser.IsDDL(ast) {
+			// Drop the in-memory catalog change along with the rolled back rows.
+			if loadErr := r.Planner.ReloadCatalog(); loadErr != nil {
+				return fmt.Errorf("%w (reloading catalog failed: %v)", err, loadErr)
+			}
+		}
+		return err
+	}
+	if err := r.Storage.Commit(); err != nil {
+		if parser.IsDDL(ast) {
+			// A failed commit may have rolled the statement back.
+			if loadErr := r.Planner.ReloadCatalog(); loadErr != nil {
+				return fmt.Errorf("%w (reloading catalog failed: %v)", err, loadErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *REPL) execute(ast parser.ASTNode) error {
+	if r.Storage.ReadOnly() && !parser.ReadOnly(ast) {
+		return storage.ErrReadOnly
+	}
 	if createStmt, ok := ast.(*parser.CreateTableStmt); ok {
 		return r.handleCreateTable(createStmt)
 	}
 	if createIdx, ok := ast.(*parser.CreateIndexStmt); ok {
 		return r.handleCreateIndex(createIdx)
 	}
+	if dropIdx, ok := ast.(*parser.DropIndexStmt); ok {
+		if err := r.Planner.DropIndex(dropIdx); err != nil {
+			return err
+		}
+		fmt.Println("DROP INDEX")
+		return nil
+	}
 
 	iter, err := r.Planner.CreatePlan(ast)
 	if err != nil {
@@ -89,84 +138,19 @@ func (r *REPL) Execute(sql string) error {
 }
 
 func (r *REPL) handleCreateTable(stmt *parser.CreateTableStmt) error {
-	err := r.Catalog.CreateTable(stmt.TableName, stmt.Columns)
-	if err != nil {
-		return err
-	}
-	_, err = r.Storage.GetHeapFile(stmt.TableName)
-	if err != nil {
+	if err := r.Planner.CreateTable(stmt); err != nil {
 		return err
 	}
 	fmt.Println("CREATE TABLE")
-	if err := r.Catalog.SaveToFile(filepath.Join(r.DataDir, "catalog.json")); err != nil {
-		fmt.Printf("Warning: failed to persist catalog: %v\n", err)
-	}
 	return nil
 }
 
 func (r *REPL) handleCreateIndex(stmt *parser.CreateIndexStmt) error {
-	table, exists := r.Catalog.GetTable(stmt.TableName)
-	if !exists {
-		return fmt.Errorf("table %s not found", stmt.TableName)
-	}
-
-	colIdx := -1
-	for i, col := range table.Columns {
-		if col.Name == stmt.Column {
-			colIdx = i
-			break
-		}
-	}
-	if colIdx == -1 {
-		return fmt.Errorf("column %s not found in table %s", stmt.Column, stmt.TableName)
-	}
-
-	idx := indexing.NewHashIndex()
-	hf, err := r.Storage.GetHeapFile(stmt.TableName)
-	if err != nil {
-		return err
-	}
-
-	fmt.Printf("Building index on %s.%s...\n", stmt.TableName, stmt.Column)
-	iter := hf.Iterator()
-	count := 0
-	for {
-		data, rid, err := iter.Next()
-		if err != nil {
-			return err
-		}
-		if data == nil {
-			break
-		}
-
-		tuple, err := storage.DeserializeTuple(data, table.Columns)
-		if err != nil {
-			return err
-		}
-
-		val := tuple.Cells[colIdx].Value
-		idx.Insert(val, rid)
-		count++
-	}
-
-	key := fmt.Sprintf("%s.%s", stmt.TableName, stmt.Column)
-	r.Planner.Indices[key] = idx
-
-	// Persist
-	err = r.Catalog.AddIndex(stmt.TableName, catalog.IndexDef{
-		Name:     stmt.IndexName,
-		Column:   stmt.Column,
-		Type:     "HASH",
-		IsUnique: false,
-	})
+	fmt.Printf("Building index on %s (%s)...\n", stmt.TableName, strings.Join(stmt.Columns, ", "))
+	count, err := r.Planner.CreateIndex(stmt)
 	if err != nil {
 		return err
 	}
-
-	if err := r.Catalog.SaveToFile(filepath.Join(r.DataDir, "catalog.json")); err != nil {
-		fmt.Printf("Warning: failed to persist catalog: %v\n", err)
-	}
-
 	fmt.Printf("CREATE INDEX (%d entries)\n", count)
 	return nil
 }
@@ -195,6 +179,10 @@ func (r *REPL) executeIter(it execution.Iterator) error {
 		}
 
 		for _, cell := range tuple.Cells {
+			if cell.Value == nil {
+				fmt.Print("| NULL\t")
+				continue
+			}
 			fmt.Printf("| %v\t", cell.Value)
 		}
 		fmt.Println("|")
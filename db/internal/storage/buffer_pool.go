@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+)
+
+const DefaultBufferPoolPages = 256
+
+var ErrBufferPoolFull = errors.New("buffer pool exhausted: all frames are pinned")
+
+type frameKey struct {
+	pager *Pager
+	id    PageID
+}
+
+type frame struct {
+	key      frameKey
+	page     *Page
+	pinCount int
+	ref      bool
+}
+
+type BufferPoolStats struct {
+	Capacity  int
+	Resident  int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// BufferPool caches pages from every pager of an Engine in a fixed number of
+// frames. Pages are pinned while in use and evicted with the clock algorithm;
+// dirty pages are written back through their pager (and so through the WAL)
+// on eviction or flush.
+type BufferPool struct {
+	frames    []*frame
+	table     map[frameKey]*frame
+	capacity  int
+	hand      int
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	mu        sync.Mutex
+}
+
+func NewBufferPool(capacity int) *BufferPool {
+	if capacity <= 0 {
+		capacity = DefaultBufferPoolPages
+	}
+	return &BufferPool{
+		table:    make(map[frameKey]*frame),
+		capacity: capacity,
+	}
+}
+
+// FetchPage returns the page pinned. Every FetchPage must be paired with an
+// UnpinPage.
+func (bp *BufferPool) FetchPage(p *Pager, id PageID) (*Page, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	key := frameKey{pager: p, id: id}
+	if f, ok := bp.table[key]; ok {
+		bp.hits++
+		f.pinCount++
+		f.ref = true
+		return f.page, nil
+	}
+	bp.misses++
+
+	page, err := p.ReadPage(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := bp.allocFrame()
+	if err != nil {
+		return nil, err
+	}
+	f.key = key
+	f.page = page
+	f.pinCount = 1
+	f.ref = true
+	bp.table[key] = f
+	return page, nil
+}
+
+// allocFrame returns an empty frame, evicting an unpinned page if the pool is
+// full.
+func (bp *BufferPool) allocFrame() (*frame, error) {
+	if len(bp.frames) < bp.capacity {
+		f := &frame{}
+		bp.frames = append(bp.frames, f)
+		return f, nil
+	}
+
+	for sweep := 0; sweep < 2*len(bp.frames); sweep++ {
+		f := bp.frames[bp.hand]
+		bp.hand = (bp.hand + 1) % len(bp.frames)
+		if f.pinCount > 0 {
+			continue
+		}
+		if f.ref {
+			f.ref = false
+			continue
+		}
+		if f.page.Dirty {
+			if err := f.key.pager.WritePage(f.page); err != nil {
+				return nil, err
+			}
+		}
+		delete(bp.table, f.key)
+		bp.evictions++
+		f.page = nil
+		return f, nil
+	}
+	return nil, ErrBufferPoolFull
+}
+
+func (bp *BufferPool) UnpinPage(p *Pager, id PageID, dirty bool) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	f, ok := bp.table[frameKey{pager: p, id: id}]
+	if !ok {
+		return
+	}
+	if dirty {
+		f.page.Dirty = true
+	}
+	if f.pinCount > 0 {
+		f.pinCount--
+	}
+}
+
+// FlushAll writes every dirty page back to disk.
+func (bp *BufferPool) FlushAll() error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	for _, f := range bp.frames {
+		if f.page != nil && f.page.Dirty {
+			if err := f.key.pager.WritePage(f.page); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Discard drops every cached page without writing it back. It is used after a
+// rollback, when the cached images may no longer match the data files.
+func (bp *BufferPool) Discard() {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	for _, f := range bp.frames {
+		if f.page != nil && f.pinCount == 0 {
+			delete(bp.table, f.key)
+			f.page = nil
+			f.ref = false
+		}
+	}
+	bp.compact()
+}
+
+// DiscardFrom drops the cached pages of one pager from page ID from onwards,
+// e.g. before the file is truncated.
+func (bp *BufferPool) DiscardFrom(p *Pager, from PageID) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	for _, f := range bp.frames {
+		if f.page != nil && f.key.pager == p && f.key.id >= from {
+			delete(bp.table, f.key)
+			f.page = nil
+			f.ref = false
+		}
+	}
+	bp.compact()
+}
+
+func (bp *BufferPool) compact() {
+	live := bp.frames[:0]
+	for _, f := range bp.frames {
+		if f.page != nil {
+			live = append(live, f)
+		}
+	}
+	bp.frames = live
+	bp.hand = 0
+}
+
+func (bp *BufferPool) Stats() BufferPoolStats {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return BufferPoolStats{
+		Capacity:  bp.capacity,
+		Resident:  len(bp.table),
+		Hits:      bp.hits,
+		Misses:    bp.misses,
+		Evictions: bp.evictions,
+	}
+}
@@ -1,24 +1,110 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"sync"
 )
 
+// LockFileName is the file in the data directory that a read-write Engine
+// holds an exclusive lock on.
+const LockFileName = "LOCK"
+
+var (
+	ErrDataDirLocked = errors.New("data directory is locked")
+	ErrReadOnly      = errors.New("database is open read-only")
+)
+
 type Engine struct {
-	DataDir string
-	pagers  map[string]*Pager
-	heaps   map[string]*HeapFile
-	mu      sync.Mutex
+	DataDir  string
+	pagers   map[string]*Pager
+	heaps    map[string]*HeapFile
+	pool     *BufferPool
+	wal      *WAL
+	lock     *os.File
+	readOnly bool
+	dropped  []string // files to delete when the transaction commits
+	created  []string // files to delete when the transaction aborts
+	mu       sync.Mutex
+	txnMu    sync.Mutex
 }
 
-func NewEngine(dataDir string) *Engine {
+// NewEngine opens the data directory and runs crash recovery from the
+// write-ahead log before any table file is used. Directories with files in
+// an older format are refused until they are upgraded. The directory is
+// locked for as long as the engine is open; a second NewEngine on it, in
+// this or another process, fails with ErrDataDirLocked.
+func NewEngine(dataDir string) (*Engine, error) {
+	lock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDataDir(dataDir); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	wal, err := OpenWAL(filepath.Join(dataDir, WALFileName))
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	applied, err := wal.Recover(dataDir)
+	if err != nil {
+		wal.Close()
+		lock.Close()
+		return nil, fmt.Errorf("recovery failed: %w", err)
+	}
+	if applied > 0 {
+		fmt.Printf("Recovered %d page images from write-ahead log\n", applied)
+	}
+
 	return &Engine{
 		DataDir: dataDir,
 		pagers:  make(map[string]*Pager),
 		heaps:   make(map[string]*HeapFile),
+		pool:    NewBufferPool(DefaultBufferPoolPages),
+		wal:     wal,
+		lock:    lock,
+	}, nil
+}
+
+// NewReadOnlyEngine opens the data directory without locking it, so it can
+// attach to a directory another process is writing. It never writes: there
+// is no WAL, files are opened read-only and any attempt to write a page fails
+// with ErrReadOnly. Each statement drops the pages cached by the last one and
+// reads the files afresh, so it sees every statement committed before it
+// began. It is not a snapshot, though: it may also see part of a statement
+// that is in progress or commits while it runs. The catalog is read once, so
+// tables created after the engine was opened are not seen. A directory that
+// needs crash recovery must first be opened read-write.
+func NewReadOnlyEngine(dataDir string) (*Engine, error) {
+	if err := checkDataDir(dataDir); err != nil {
+		return nil, err
+	}
+	locked, err := dataDirLocked(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		info, err := os.Stat(filepath.Join(dataDir, WALFileName))
+		if err == nil && info.Size() > 0 {
+			return nil, fmt.Errorf("%s has not been recovered; open the data directory read-write once first", WALFileName)
+		}
 	}
+
+	return &Engine{
+		DataDir:  dataDir,
+		pagers:   make(map[string]*Pager),
+		heaps:    make(map[string]*HeapFile),
+		pool:     NewBufferPool(DefaultBufferPoolPages),
+		readOnly: true,
+	}, nil
+}
+
+func (e *Engine) ReadOnly() bool {
+	return e.readOnly
 }
 
 func (e *Engine) GetHeapFile(tableName string) (*HeapFile, error) {
@@ -29,25 +115,233 @@ func (e *Engine) GetHeapFile(tableName string) (*HeapFile, error) {
 		return hf, nil
 	}
 
-	path := filepath.Join(e.DataDir, tableName+".data")
-	pager, err := NewPager(path)
+	pager, err := e.openPager(tableName+".data", FileKindHeap)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open table file %s: %w", path, err)
+		return nil, err
+	}
+	fsmPager, err := e.openPager(tableName+".fsm", FileKindFreeSpaceMap)
+	if err != nil {
+		return nil, err
+	}
+	ovfPager, err := e.openPager(tableName+".ovf", FileKindOverflow)
+	if err != nil {
+		return nil, err
 	}
 
-	hf := NewHeapFile(pager)
-	e.pagers[tableName] = pager
+	hf := NewHeapFile(pager, NewFreeSpaceMap(fsmPager, e.pool), NewOverflowFile(ovfPager, e.pool), e.pool)
+	fsmPages, _ := fsmPager.PageCount()
+	heapPages, _ := pager.PageCount()
+	if fsmPages == 0 && heapPages > 0 && !e.readOnly {
+		if err := hf.rebuildFreeSpaceMap(); err != nil {
+			return nil, fmt.Errorf("failed to rebuild free space map for %s: %w", tableName, err)
+		}
+	}
 	e.heaps[tableName] = hf
 
 	return hf, nil
 }
 
+// IndexFileExt is the extension of on-disk index files.
+const IndexFileExt = ".idx"
+
+// IndexFileName is the file holding index of table.
+func IndexFileName(table, index string) string {
+	return table + "." + index + IndexFileExt
+}
+
+// OpenIndexFile opens the file of an on-disk index. Like table files, it is
+// written through the buffer pool and the WAL.
+func (e *Engine) OpenIndexFile(fileName string, kind FileKind) (*Pager, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.openPager(fileName, kind)
+}
+
+// FileExists reports whether fileName exists in the data directory.
+func (e *Engine) FileExists(fileName string) (bool, error) {
+	_, err := os.Stat(filepath.Join(e.DataDir, fileName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DropFile deletes a file of the data directory, such as the file of a
+// dropped index, when the active transaction commits; an abort keeps it. The
+// transaction must already have emptied the file, so that rolling back
+// restores it. The commit checkpoints the log before deleting the file, so
+// recovery never replays records for a file that is gone.
+func (e *Engine) DropFile(fileName string) {
+	e.dropped = append(e.dropped, fileName)
+}
+
+// CreatedFile records that the active transaction creates fileName, such as
+// the file of a new index, so that an abort deletes it again; a commit keeps
+// it. Like DropFile, the abort checkpoints the log before deleting the file.
+func (e *Engine) CreatedFile(fileName string) {
+	e.created = append(e.created, fileName)
+}
+
+// BufferPool returns the pool shared by every file of the engine.
+func (e *Engine) BufferPool() *BufferPool {
+	return e.pool
+}
+
+// openPager opens a file in the data directory, attached to the WAL. Pagers
+// are keyed by file name.
+func (e *Engine) openPager(fileName string, kind FileKind) (*Pager, error) {
+	if p, ok := e.pagers[fileName]; ok {
+		return p, nil
+	}
+	path := filepath.Join(e.DataDir, fileName)
+	pager, err := newPager(path, kind, e.readOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open table file %s: %w", path, err)
+	}
+	pager.wal = e.wal
+	e.pagers[fileName] = pager
+	return pager, nil
+}
+
+// Begin starts a transaction. Transactions are serialized: Begin blocks until
+// the previous one has committed or aborted. On a read-only engine it drops
+// every cached page, which another process may have rewritten since.
+func (e *Engine) Begin() {
+	e.txnMu.Lock()
+	if e.wal != nil {
+		e.wal.Begin()
+	}
+	if e.readOnly {
+		e.pool.Discard()
+	}
+}
+
+// Commit forces the transaction's dirty pages out of the buffer pool, makes it
+// durable and checkpoints the log once it has grown past checkpointThreshold.
+func (e *Engine) Commit() error {
+	defer e.txnMu.Unlock()
+
+	if err := e.pool.FlushAll(); err != nil {
+		if abortErr := e.rollback(); abortErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, abortErr)
+		}
+		return err
+	}
+	if e.wal == nil {
+		return nil
+	}
+	// Once the commit record may be in the log, the new files must stay.
+	e.created = nil
+	if err := e.wal.Commit(); err != nil {
+		return err
+	}
+	if len(e.dropped) > 0 {
+		files := e.dropped
+		e.dropped = nil
+		return e.removeFiles(files)
+	}
+	if e.wal.Size() > checkpointThreshold {
+		return e.checkpoint()
+	}
+	return nil
+}
+
+// Abort rolls back every page written by the active transaction and drops
+// its unwritten pages from the buffer pool.
+func (e *Engine) Abort() error {
+	defer e.txnMu.Unlock()
+	return e.rollback()
+}
+
+func (e *Engine) rollback() error {
+	e.dropped = nil
+	created := e.created
+	e.created = nil
+	var err error
+	if e.wal != nil {
+		err = e.wal.Abort()
+	}
+	e.pool.Discard()
+	if len(created) > 0 && err == nil {
+		err = e.removeFiles(created)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, p := range e.pagers {
+		if resetErr := p.resetPageCount(); resetErr != nil && err == nil {
+			err = resetErr
+		}
+	}
+	return err
+}
+
+// removeFiles checkpoints the log and deletes files, those passed to
+// DropFile on commit or to CreatedFile on abort.
+func (e *Engine) removeFiles(files []string) error {
+	if err := e.checkpoint(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, name := range files {
+		if p, ok := e.pagers[name]; ok {
+			e.pool.DiscardFrom(p, 0)
+			p.Close()
+			delete(e.pagers, name)
+		}
+		if err := os.Remove(filepath.Join(e.DataDir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) BufferPoolStats() BufferPoolStats {
+	return e.pool.Stats()
+}
+
+// checkpoint syncs every data file and truncates the log. It must only run
+// while no transaction is active.
+func (e *Engine) checkpoint() error {
+	if e.readOnly {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, p := range e.pagers {
+		if err := p.Sync(); err != nil {
+			return err
+		}
+	}
+	return e.wal.Truncate()
+}
+
 func (e *Engine) Close() error {
+	e.txnMu.Lock()
+	defer e.txnMu.Unlock()
+
+	if !e.readOnly {
+		if err := e.pool.FlushAll(); err != nil {
+			return err
+		}
+		if err := e.checkpoint(); err != nil {
+			return err
+		}
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	for _, p := range e.pagers {
 		p.Close()
 	}
-	return nil
+	if e.readOnly {
+		return nil
+	}
+	err := e.wal.Close()
+	e.lock.Close()
+	return err
 }
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Every file opened by a Pager starts with a header page:
+//
+//	[magic "MBDBFILE"][version uint32][page size uint32][kind uint8][crc32 uint32]
+//
+// Data pages follow it, so page n lives at file offset (n+1)*PageSize. Files
+// written before the header existed (format version 0) must be converted with
+// `minibank -mode upgrade` before they can be opened.
+const (
+	FormatVersion = 1
+
+	fileMagic            = "MBDBFILE"
+	fileHeaderChecksumAt = 17
+)
+
+type FileKind uint8
+
+const (
+	FileKindHeap FileKind = iota + 1
+	FileKindFreeSpaceMap
+	FileKindOverflow
+	FileKindBTree
+	FileKindHash
+)
+
+func (k FileKind) String() string {
+	switch k {
+	case FileKindHeap:
+		return "heap"
+	case FileKindFreeSpaceMap:
+		return "free space map"
+	case FileKindOverflow:
+		return "overflow"
+	case FileKindBTree:
+		return "b+tree index"
+	case FileKindHash:
+		return "hash index"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(k))
+}
+
+type FileHeader struct {
+	Version  uint32
+	PageSize uint32
+	Kind     FileKind
+}
+
+// FormatError is returned when a data file cannot be opened by this version.
+type FormatError struct {
+	Path   string
+	Reason string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Reason)
+}
+
+// pageOffset is the file offset of a data page, after the header page.
+func pageOffset(id PageID) int64 {
+	return int64(id+1) * PageSize
+}
+
+func encodeFileHeader(h FileHeader) []byte {
+	buf := make([]byte, PageSize)
+	copy(buf[0:8], fileMagic)
+	binary.BigEndian.PutUint32(buf[8:12], h.Version)
+	binary.BigEndian.PutUint32(buf[12:16], h.PageSize)
+	buf[16] = byte(h.Kind)
+	binary.BigEndian.PutUint32(buf[fileHeaderChecksumAt:], crc32.ChecksumIEEE(buf[:fileHeaderChecksumAt]))
+	return buf
+}
+
+// decodeFileHeader parses a header page. ok is false when the page does not
+// start with the file magic, i.e. the file predates the header.
+func decodeFileHeader(buf []byte) (h FileHeader, ok bool, err error) {
+	if !bytes.Equal(buf[0:8], []byte(fileMagic)) {
+		return h, false, nil
+	}
+	stored := binary.BigEndian.Uint32(buf[fileHeaderChecksumAt:])
+	if actual := crc32.ChecksumIEEE(buf[:fileHeaderChecksumAt]); stored != actual {
+		return h, true, fmt.Errorf("file header checksum mismatch (stored 0x%08x, computed 0x%08x)", stored, actual)
+	}
+	h.Version = binary.BigEndian.Uint32(buf[8:12])
+	h.PageSize = binary.BigEndian.Uint32(buf[12:16])
+	h.Kind = FileKind(buf[16])
+	return h, true, nil
+}
+
+// ReadFileHeader reads the header of the file at path. It returns ok=false
+// for an empty file or one without a header.
+func ReadFileHeader(path string) (h FileHeader, ok bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return h, false, err
+	}
+	defer f.Close()
+	return readFileHeader(f)
+}
+
+func readFileHeader(f *os.File) (FileHeader, bool, error) {
+	buf := make([]byte, PageSize)
+	if _, err := f.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return FileHeader{}, false, err
+	}
+	return decodeFileHeader(buf)
+}
+
+// checkFileHeader validates the header of an open, non-empty file.
+func checkFileHeader(f *os.File, path string, kind FileKind) error {
+	h, ok, err := readFileHeader(f)
+	if err != nil {
+		return &FormatError{Path: path, Reason: err.Error()}
+	}
+	if !ok {
+		return &FormatError{Path: path, Reason: "no file header (format version 0); run `minibank -mode upgrade` on the data directory"}
+	}
+	if h.Version != FormatVersion {
+		return &FormatError{Path: path, Reason: fmt.Sprintf("unsupported format version %d (this build reads version %d)", h.Version, FormatVersion)}
+	}
+	if h.PageSize != PageSize {
+		return &FormatError{Path: path, Reason: fmt.Sprintf("page size %d does not match %d", h.PageSize, PageSize)}
+	}
+	if h.Kind != kind {
+		return &FormatError{Path: path, Reason: fmt.Sprintf("is a %s file, expected %s", h.Kind, kind)}
+	}
+	return nil
+}
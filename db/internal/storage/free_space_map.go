@@ -0,0 +1,84 @@
+package storage
+
+import "encoding/binary"
+
+// fsmEntriesPerPage is the number of heap pages one FSM page describes. Each
+// entry is the heap page's reclaimable free space as a uint16.
+const fsmEntriesPerPage = (PageSize - PageMetaSize) / 2
+
+// FreeSpaceMap records how many bytes each page of a heap file could still
+// hold. It is stored in its own file (<table>.fsm) and goes through the buffer
+// pool and WAL like the heap, so it stays consistent with it after a crash.
+type FreeSpaceMap struct {
+	pager *Pager
+	pool  *BufferPool
+}
+
+func NewFreeSpaceMap(pager *Pager, pool *BufferPool) *FreeSpaceMap {
+	return &FreeSpaceMap{pager: pager, pool: pool}
+}
+
+func fsmLocation(pid PageID) (PageID, int) {
+	return PageID(int(pid) / fsmEntriesPerPage), PageMetaSize + (int(pid)%fsmEntriesPerPage)*2
+}
+
+func (fsm *FreeSpaceMap) Get(pid PageID) (int, error) {
+	fsmPage, offset := fsmLocation(pid)
+	page, err := fsm.pool.FetchPage(fsm.pager, fsmPage)
+	if err != nil {
+		return 0, err
+	}
+	defer fsm.pool.UnpinPage(fsm.pager, fsmPage, false)
+	return int(binary.BigEndian.Uint16(page.Data[offset : offset+2])), nil
+}
+
+func (fsm *FreeSpaceMap) Set(pid PageID, free int) error {
+	fsmPage, offset := fsmLocation(pid)
+	count, err := fsm.pager.PageCount()
+	if err != nil {
+		return err
+	}
+	for int(fsmPage) >= count {
+		fsm.pager.AllocatePage()
+		count++
+	}
+
+	page, err := fsm.pool.FetchPage(fsm.pager, fsmPage)
+	if err != nil {
+		return err
+	}
+	page.SetType(PageTypeFreeSpaceMap)
+	binary.BigEndian.PutUint16(page.Data[offset:offset+2], uint16(free))
+	fsm.pool.UnpinPage(fsm.pager, fsmPage, true)
+	return nil
+}
+
+// FindBestFit returns the heap page (below heapPages) with the least free
+// space that still fits required bytes.
+func (fsm *FreeSpaceMap) FindBestFit(required, heapPages int) (PageID, bool, error) {
+	fsmPages, err := fsm.pager.PageCount()
+	if err != nil {
+		return 0, false, err
+	}
+
+	best, bestFree := PageID(-1), PageSize+1
+	for fp := 0; fp < fsmPages; fp++ {
+		page, err := fsm.pool.FetchPage(fsm.pager, PageID(fp))
+		if err != nil {
+			return 0, false, err
+		}
+		for i := 0; i < fsmEntriesPerPage; i++ {
+			pid := fp*fsmEntriesPerPage + i
+			if pid >= heapPages {
+				break
+			}
+			pos := PageMetaSize + i*2
+			free := int(binary.BigEndian.Uint16(page.Data[pos : pos+2]))
+			if free >= required && free < bestFree {
+				best, bestFree = PageID(pid), free
+			}
+		}
+		fsm.pool.UnpinPage(fsm.pager, PageID(fp), false)
+	}
+	return best, best >= 0, nil
+}
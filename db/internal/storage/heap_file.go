@@ -7,73 +7,164 @@ import (
 
 type HeapFile struct {
 	pager *Pager
+	fsm   *FreeSpaceMap
+	ovf   *OverflowFile
+	pool  *BufferPool
 	mu    sync.Mutex
 }
 
-func NewHeapFile(pager *Pager) *HeapFile {
-	return &HeapFile{pager: pager}
+func NewHeapFile(pager *Pager, fsm *FreeSpaceMap, ovf *OverflowFile, pool *BufferPool) *HeapFile {
+	return &HeapFile{pager: pager, fsm: fsm, ovf: ovf, pool: pool}
 }
 
+// Insert places the tuple on the page whose free space fits it most tightly,
+// according to the free space map, and only extends the file when no page
+// has room. Tuples over MaxInlineTupleSize are written to the overflow file
+// and only a pointer to them is stored in the page.
 func (hf *HeapFile) Insert(data []byte) (PageID, int, error) {
 	hf.mu.Lock()
 	defer hf.mu.Unlock()
 
+	var flags uint16
+	if len(data) > MaxInlineTupleSize {
+		if int64(len(data)) > 1<<32-1 {
+			return 0, 0, fmt.Errorf("tuple too large: %d bytes", len(data))
+		}
+		first, err := hf.ovf.Write(data)
+		if err != nil {
+			return 0, 0, err
+		}
+		data = encodeOverflowStub(len(data), first)
+		flags = slotOverflowFlag
+	}
+	return hf.insertSlot(data, flags)
+}
+
+func (hf *HeapFile) insertSlot(data []byte, flags uint16) (PageID, int, error) {
 	count, err := hf.pager.PageCount()
 	if err != nil {
 		return 0, 0, err
 	}
 
-	var page *Page
-	var pid PageID
+	pid, slotID, found, err := hf.insertFitting(data, flags, count)
+	if err != nil || found {
+		return pid, slotID, err
+	}
 
-	if count > 0 {
-		pid = PageID(count - 1)
-		page, err = hf.pager.ReadPage(pid)
-		if err != nil {
-			return 0, 0, err
+	pid = hf.pager.AllocatePage()
+	slotID, err = hf.insertInto(pid, data, flags)
+	if err != nil {
+		return 0, 0, err
+	}
+	return pid, slotID, nil
+}
+
+// insertFitting inserts the tuple into one of the first limit pages that the
+// free space map says has room for it. A page that turns out to be full has
+// its entry corrected and the next best fit is tried. It reports false if no
+// page took the tuple.
+func (hf *HeapFile) insertFitting(data []byte, flags uint16, limit int) (PageID, int, bool, error) {
+	tried := make(map[PageID]bool)
+	for {
+		pid, found, err := hf.fsm.FindBestFit(len(data)+SlotSize, limit)
+		if err != nil || !found || tried[pid] {
+			return 0, 0, false, err
+		}
+		tried[pid] = true
+		slotID, err := hf.insertInto(pid, data, flags)
+		if err == nil {
+			return pid, slotID, true, nil
+		}
+		if err != ErrPageFull {
+			return 0, 0, false, err
 		}
-	} else {
-		pid = 0
-		page = &Page{ID: 0, Dirty: true}
 	}
+}
 
+// insertInto inserts the tuple into page pid and records the page's new free
+// space. If the page has no room it returns ErrPageFull, after correcting the
+// map so the page is not picked again.
+func (hf *HeapFile) insertInto(pid PageID, data []byte, flags uint16) (int, error) {
+	page, err := hf.pool.FetchPage(hf.pager, pid)
+	if err != nil {
+		return 0, err
+	}
 	sp := CastPage(page)
-	slotID, err := sp.InsertTuple(data)
+	slotID, err := sp.insertTuple(data, flags)
+	free := sp.ReclaimableSpace()
+	hf.pool.UnpinPage(hf.pager, pid, err == nil)
 	if err == ErrPageFull {
-		pid = PageID(count)
-		if pid == 0 && count == 0 {
-			if len(data) > PageSize-PageHeaderSize-SlotSize {
-				return 0, 0, fmt.Errorf("tuple too large for page")
-			}
+		if setErr := hf.fsm.Set(pid, free); setErr != nil {
+			return 0, setErr
 		}
+		return 0, ErrPageFull
+	}
+	if err != nil {
+		return 0, err
+	}
+	return slotID, hf.fsm.Set(pid, free)
+}
+
+// rebuildFreeSpaceMap recomputes every entry from the heap pages. It runs
+// when a heap file is opened without a map, e.g. one created before the map
+// existed.
+func (hf *HeapFile) rebuildFreeSpaceMap() error {
+	hf.mu.Lock()
+	defer hf.mu.Unlock()
 
-		pid = PageID(count)
-		page = &Page{ID: pid, Dirty: true}
-		sp = CastPage(page)
-		slotID, err = sp.InsertTuple(data)
+	count, err := hf.pager.PageCount()
+	if err != nil {
+		return err
+	}
+	for pid := PageID(0); int(pid) < count; pid++ {
+		page, err := hf.pool.FetchPage(hf.pager, pid)
 		if err != nil {
-			return 0, 0, err
+			return err
+		}
+		free := CastPage(page).ReclaimableSpace()
+		hf.pool.UnpinPage(hf.pager, pid, false)
+		if err := hf.fsm.Set(pid, free); err != nil {
+			return err
 		}
 	}
-
-	page.Dirty = true
-	if err := hf.pager.WritePage(page); err != nil {
-		return 0, 0, err
-	}
-
-	return pid, slotID, nil
+	return nil
 }
 
+// ReadTuple returns a copy of the tuple bytes, or nil if the slot is empty.
 func (hf *HeapFile) ReadTuple(pid PageID, slotID int) ([]byte, error) {
 	hf.mu.Lock()
 	defer hf.mu.Unlock()
 
-	page, err := hf.pager.ReadPage(pid)
+	page, err := hf.pool.FetchPage(hf.pager, pid)
 	if err != nil {
 		return nil, err
 	}
-	sp := CastPage(page)
-	return sp.GetTuple(slotID), nil
+	defer hf.pool.UnpinPage(hf.pager, pid, false)
+
+	return hf.readSlot(CastPage(page), slotID)
+}
+
+// readSlot returns a copy of the tuple in a slot, following its overflow
+// pointer if it has one. The page must be pinned.
+func (hf *HeapFile) readSlot(sp *SlottedPage, slotID int) ([]byte, error) {
+	data := sp.GetTuple(slotID)
+	if data == nil || !sp.IsOverflow(slotID) {
+		return copyTuple(data), nil
+	}
+	total, first, err := decodeOverflowStub(data)
+	if err != nil {
+		return nil, err
+	}
+	return hf.ovf.Read(first, total)
+}
+
+func copyTuple(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	out := make([]byte, len(data))
+	copy(out, data)
+	return out
 }
 
 type HeapIterator struct {
@@ -96,7 +187,7 @@ func (it *HeapIterator) Next() ([]byte, RID, error) {
 	}
 
 	for int(it.curPage) < pageCount {
-		page, err := it.hf.pager.ReadPage(it.curPage)
+		page, err := it.hf.pool.FetchPage(it.hf.pager, it.curPage)
 		if err != nil {
 			return nil, RID{}, err
 		}
@@ -105,14 +196,19 @@ func (it *HeapIterator) Next() ([]byte, RID, error) {
 		slots := int(sp.Header.SlotCount)
 
 		for it.curSlot < slots {
-			tupleBytes := sp.GetTuple(it.curSlot)
 			slot := it.curSlot
 			it.curSlot++
-			if tupleBytes != nil {
-				return tupleBytes, RID{PageID: it.curPage, SlotID: slot}, nil
+			if sp.GetTuple(slot) != nil {
+				out, err := it.hf.readSlot(sp, slot)
+				it.hf.pool.UnpinPage(it.hf.pager, it.curPage, false)
+				if err != nil {
+					return nil, RID{}, err
+				}
+				return out, RID{PageID: it.curPage, SlotID: slot}, nil
 			}
 		}
 
+		it.hf.pool.UnpinPage(it.hf.pager, it.curPage, false)
 		it.curPage++
 		it.curSlot = 0
 	}
@@ -128,16 +224,189 @@ func (hf *HeapFile) DeleteTuple(rid RID) error {
 	hf.mu.Lock()
 	defer hf.mu.Unlock()
 
-	page, err := hf.pager.ReadPage(rid.PageID)
+	page, err := hf.pool.FetchPage(hf.pager, rid.PageID)
 	if err != nil {
 		return err
 	}
 
 	sp := CastPage(page)
-	if err := sp.DeleteTuple(rid.SlotID); err != nil {
+	overflow := PageID(noOverflowPage)
+	if sp.IsOverflow(rid.SlotID) {
+		if _, overflow, err = decodeOverflowStub(sp.GetTuple(rid.SlotID)); err != nil {
+			hf.pool.UnpinPage(hf.pager, rid.PageID, false)
+			return err
+		}
+	}
+	err = sp.DeleteTuple(rid.SlotID)
+	free := sp.ReclaimableSpace()
+	hf.pool.UnpinPage(hf.pager, rid.PageID, err == nil)
+	if err != nil {
+		return err
+	}
+	if err := hf.fsm.Set(rid.PageID, free); err != nil {
 		return err
 	}
+	if overflow != PageID(noOverflowPage) {
+		return hf.ovf.Free(overflow)
+	}
+	return nil
+}
+
+type VacuumStats struct {
+	PagesBefore    int
+	PagesAfter     int
+	SlotsReclaimed int
+	TuplesMoved    int
+}
+
+// Vacuum compacts every page, moves tuples off the tail of the file into free
+// space on earlier pages and truncates the trailing pages that end up empty.
+// Destinations are chosen below the final truncation point up front, so each
+// tuple moves at most once and a page is only touched if it can be emptied.
+// onMove is called for every relocated tuple so callers can fix up indexes.
+func (hf *HeapFile) Vacuum(onMove func(data []byte, from, to RID) error) (VacuumStats, error) {
+	hf.mu.Lock()
+	defer hf.mu.Unlock()
+
+	count, err := hf.pager.PageCount()
+	if err != nil {
+		return VacuumStats{}, err
+	}
+	stats := VacuumStats{PagesBefore: count}
+
+	free := make([]int, count)
+	sizes := make([][]int, count)
+	for pid := PageID(0); int(pid) < count; pid++ {
+		page, err := hf.pool.FetchPage(hf.pager, pid)
+		if err != nil {
+			return stats, err
+		}
+		sp := CastPage(page)
+		stats.SlotsReclaimed += sp.Vacuum()
+		free[pid] = sp.ReclaimableSpace()
+		for slot := 0; slot < int(sp.Header.SlotCount); slot++ {
+			if t := sp.GetTuple(slot); t != nil {
+				sizes[pid] = append(sizes[pid], len(t))
+			}
+		}
+		hf.pool.UnpinPage(hf.pager, pid, true)
+		if err := hf.fsm.Set(pid, free[pid]); err != nil {
+			return stats, err
+		}
+	}
+
+	newCount, dests := planVacuum(free, sizes)
+	for pid := PageID(count - 1); int(pid) >= newCount; pid-- {
+		if err := hf.evacuate(pid, dests[pid], onMove, &stats); err != nil {
+			return stats, err
+		}
+	}
+
+	if newCount < count {
+		// Write the emptied pages out first so their before-images are logged
+		// and a rollback can restore them.
+		if err := hf.pool.FlushAll(); err != nil {
+			return stats, err
+		}
+		hf.pool.DiscardFrom(hf.pager, PageID(newCount))
+		if err := hf.pager.Truncate(newCount); err != nil {
+			return stats, err
+		}
+	}
+	stats.PagesAfter = newCount
+	return stats, nil
+}
+
+// planVacuum returns the fewest leading pages that can take in every tuple
+// behind them, and for each page past that point the destination of each of
+// its live tuples, in slot order. free holds each page's reclaimable space and
+// sizes the lengths of its live tuples.
+func planVacuum(free []int, sizes [][]int) (int, [][]PageID) {
+	count := len(free)
+	// need[k] is the room the tuples on pages k and later take up.
+	need := make([]int, count+1)
+	for pid := count - 1; pid >= 0; pid-- {
+		need[pid] = need[pid+1]
+		for _, size := range sizes[pid] {
+			need[pid] += size + SlotSize
+		}
+	}
+	have := 0
+	for keep := 0; keep < count; keep++ {
+		if need[keep] <= have {
+			if dests, ok := placeTail(free, sizes, keep); ok {
+				return keep, dests
+			}
+		}
+		have += free[keep]
+	}
+	return count, nil
+}
+
+// placeTail assigns every tuple on pages keep and later a best-fit page below
+// keep, as FindBestFit would. Each tuple is charged a new slot, which is never
+// less than the insert needs, so the real moves cannot run out of room.
+func placeTail(free []int, sizes [][]int, keep int) ([][]PageID, bool) {
+	room := append([]int(nil), free[:keep]...)
+	dests := make([][]PageID, len(free))
+	for pid := len(free) - 1; pid >= keep; pid-- {
+		for _, size := range sizes[pid] {
+			required := size + SlotSize
+			best := -1
+			for dest, left := range room {
+				if left >= required && (best < 0 || left < room[best]) {
+					best = dest
+				}
+			}
+			if best < 0 {
+				return nil, false
+			}
+			room[best] -= required
+			dests[pid] = append(dests[pid], PageID(best))
+		}
+	}
+	return dests, true
+}
+
+// evacuate moves the tuples of page pid to the pages planVacuum chose for
+// them, leaving the page empty.
+func (hf *HeapFile) evacuate(pid PageID, dests []PageID, onMove func(data []byte, from, to RID) error, stats *VacuumStats) error {
+	page, err := hf.pool.FetchPage(hf.pager, pid)
+	if err != nil {
+		return err
+	}
+	sp := CastPage(page)
+	defer hf.pool.UnpinPage(hf.pager, pid, true)
+
+	next := 0
+	for slot := 0; slot < int(sp.Header.SlotCount); slot++ {
+		raw := copyTuple(sp.GetTuple(slot))
+		if raw == nil {
+			continue
+		}
+		var flags uint16
+		if sp.IsOverflow(slot) {
+			flags = slotOverflowFlag
+		}
+		data, err := hf.readSlot(sp, slot)
+		if err != nil {
+			return err
+		}
+		dest := dests[next]
+		next++
+		destSlot, err := hf.insertInto(dest, raw, flags)
+		if err != nil {
+			return err
+		}
+		if err := sp.DeleteTuple(slot); err != nil {
+			return err
+		}
+		stats.TuplesMoved++
+		if err := onMove(data, RID{PageID: pid, SlotID: slot}, RID{PageID: dest, SlotID: destSlot}); err != nil {
+			return err
+		}
+	}
 
-	page.Dirty = true
-	return hf.pager.WritePage(page)
+	stats.SlotsReclaimed += sp.Vacuum()
+	return hf.fsm.Set(pid, sp.ReclaimableSpace())
 }
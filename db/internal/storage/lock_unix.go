@@ -0,0 +1,66 @@
+//go:build unix
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockDataDir takes an exclusive flock on the LOCK file of dataDir and
+// records our PID in it. The lock is released when the returned file is
+// closed or the process exits, so a crash never leaves a stale lock.
+func lockDataDir(dataDir string) (*os.File, error) {
+	path := filepath.Join(dataDir, LockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, lockedError(dataDir, path)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return f, nil
+}
+
+// dataDirLocked reports whether another process holds the lock of dataDir.
+func dataDirLocked(dataDir string) (bool, error) {
+	f, err := os.Open(filepath.Join(dataDir, LockFileName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return false, nil
+}
+
+func lockedError(dataDir, path string) error {
+	msg := fmt.Sprintf("data directory %s is in use by another process", dataDir)
+	if data, err := os.ReadFile(path); err == nil {
+		if pid := strings.TrimSpace(string(data)); pid != "" {
+			msg += " (pid " + pid + ")"
+		}
+	}
+	return fmt.Errorf("%s: %w", msg, ErrDataDirLocked)
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// MaxInlineTupleSize is the largest tuple stored directly in a heap page.
+	// Larger tuples are moved to an overflow chain and the slot holds a stub.
+	MaxInlineTupleSize = PageSize / 4
+
+	// Overflow pages hold [next page uint32][used bytes uint16][data] after
+	// the common page header.
+	ovfNextOffset    = PageMetaSize
+	ovfUsedOffset    = PageMetaSize + 4
+	ovfDataOffset    = PageMetaSize + 6
+	overflowCapacity = PageSize - ovfDataOffset
+	overflowStubSize = 8 // total length (uint32) + first page (uint32)
+
+	// overflowMetaPage holds the head of the free page list.
+	overflowMetaPage PageID = 0
+	noOverflowPage   uint32 = 0
+)
+
+// OverflowFile stores tuples that do not fit inline as chains of pages in a
+// table's .ovf file. Pages of deleted chains go on a free list kept in page 0.
+type OverflowFile struct {
+	pager *Pager
+	pool  *BufferPool
+}
+
+func NewOverflowFile(pager *Pager, pool *BufferPool) *OverflowFile {
+	return &OverflowFile{pager: pager, pool: pool}
+}
+
+func encodeOverflowStub(total int, first PageID) []byte {
+	stub := make([]byte, overflowStubSize)
+	binary.BigEndian.PutUint32(stub[0:4], uint32(total))
+	binary.BigEndian.PutUint32(stub[4:8], uint32(first))
+	return stub
+}
+
+func decodeOverflowStub(stub []byte) (int, PageID, error) {
+	if len(stub) != overflowStubSize {
+		return 0, 0, fmt.Errorf("corrupt overflow pointer: %d bytes", len(stub))
+	}
+	return int(binary.BigEndian.Uint32(stub[0:4])), PageID(binary.BigEndian.Uint32(stub[4:8])), nil
+}
+
+// Write stores data in a new chain and returns its first page.
+func (of *OverflowFile) Write(data []byte) (PageID, error) {
+	chunks := (len(data) + overflowCapacity - 1) / overflowCapacity
+	pages := make([]PageID, chunks)
+	for i := range pages {
+		pid, err := of.allocPage()
+		if err != nil {
+			return 0, err
+		}
+		pages[i] = pid
+	}
+
+	for i, pid := range pages {
+		chunk := data[i*overflowCapacity:]
+		if len(chunk) > overflowCapacity {
+			chunk = chunk[:overflowCapacity]
+		}
+		next := noOverflowPage
+		if i+1 < len(pages) {
+			next = uint32(pages[i+1])
+		}
+
+		page, err := of.pool.FetchPage(of.pager, pid)
+		if err != nil {
+			return 0, err
+		}
+		page.Data = [PageSize]byte{}
+		page.SetType(PageTypeOverflow)
+		binary.BigEndian.PutUint32(page.Data[ovfNextOffset:], next)
+		binary.BigEndian.PutUint16(page.Data[ovfUsedOffset:], uint16(len(chunk)))
+		copy(page.Data[ovfDataOffset:], chunk)
+		of.pool.UnpinPage(of.pager, pid, true)
+	}
+	return pages[0], nil
+}
+
+// Read reassembles a chain of total bytes starting at first.
+func (of *OverflowFile) Read(first PageID, total int) ([]byte, error) {
+	out := make([]byte, 0, total)
+	pid := first
+	for len(out) < total {
+		if pid == PageID(noOverflowPage) {
+			return nil, fmt.Errorf("overflow chain ends after %d of %d bytes", len(out), total)
+		}
+		page, err := of.pool.FetchPage(of.pager, pid)
+		if err != nil {
+			return nil, err
+		}
+		next := binary.BigEndian.Uint32(page.Data[ovfNextOffset:])
+		used := int(binary.BigEndian.Uint16(page.Data[ovfUsedOffset:]))
+		if used > overflowCapacity {
+			of.pool.UnpinPage(of.pager, pid, false)
+			return nil, fmt.Errorf("corrupt overflow page %d: %d bytes used", pid, used)
+		}
+		out = append(out, page.Data[ovfDataOffset:ovfDataOffset+used]...)
+		of.pool.UnpinPage(of.pager, pid, false)
+		pid = PageID(next)
+	}
+	return out, nil
+}
+
+// Free puts every page of the chain starting at first on the free list.
+func (of *OverflowFile) Free(first PageID) error {
+	pid := first
+	for pid != PageID(noOverflowPage) {
+		page, err := of.pool.FetchPage(of.pager, pid)
+		if err != nil {
+			return err
+		}
+		next := binary.BigEndian.Uint32(page.Data[ovfNextOffset:])
+		head, err := of.freeListHead()
+		if err != nil {
+			of.pool.UnpinPage(of.pager, pid, false)
+			return err
+		}
+		page.Data = [PageSize]byte{}
+		page.SetType(PageTypeOverflow)
+		binary.BigEndian.PutUint32(page.Data[ovfNextOffset:], head)
+		of.pool.UnpinPage(of.pager, pid, true)
+		if err := of.setFreeListHead(uint32(pid)); err != nil {
+			return err
+		}
+		pid = PageID(next)
+	}
+	return nil
+}
+
+func (of *OverflowFile) allocPage() (PageID, error) {
+	count, err := of.pager.PageCount()
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		of.pager.AllocatePage() // meta page
+	}
+
+	head, err := of.freeListHead()
+	if err != nil {
+		return 0, err
+	}
+	if head == noOverflowPage {
+		return of.pager.AllocatePage(), nil
+	}
+
+	page, err := of.pool.FetchPage(of.pager, PageID(head))
+	if err != nil {
+		return 0, err
+	}
+	next := binary.BigEndian.Uint32(page.Data[ovfNextOffset:])
+	of.pool.UnpinPage(of.pager, PageID(head), false)
+	if err := of.setFreeListHead(next); err != nil {
+		return 0, err
+	}
+	return PageID(head), nil
+}
+
+func (of *OverflowFile) freeListHead() (uint32, error) {
+	page, err := of.pool.FetchPage(of.pager, overflowMetaPage)
+	if err != nil {
+		return 0, err
+	}
+	defer of.pool.UnpinPage(of.pager, overflowMetaPage, false)
+	return binary.BigEndian.Uint32(page.Data[ovfNextOffset:]), nil
+}
+
+func (of *OverflowFile) setFreeListHead(head uint32) error {
+	count, err := of.pager.PageCount()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		of.pager.AllocatePage()
+	}
+	page, err := of.pool.FetchPage(of.pager, overflowMetaPage)
+	if err != nil {
+		return err
+	}
+	page.SetType(PageTypeOverflowMeta)
+	binary.BigEndian.PutUint32(page.Data[ovfNextOffset:], head)
+	of.pool.UnpinPage(of.pager, overflowMetaPage, true)
+	return nil
+}
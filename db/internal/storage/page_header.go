@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Every page starts with a common header written by the pager:
+//
+//	[magic uint16][type uint8][reserved uint8][page id uint32][crc32 uint32]
+//
+// The checksum covers the whole page with the checksum field zeroed. Page
+// formats (slotted, free space map, overflow) lay out their own data after
+// PageMetaSize.
+const (
+	PageMetaSize = 12
+	pageMagic    = 0x4D42 // "MB"
+
+	checksumOffset = 8
+)
+
+type PageType uint8
+
+const (
+	PageTypeUnknown PageType = iota
+	PageTypeHeap
+	PageTypeFreeSpaceMap
+	PageTypeOverflowMeta
+	PageTypeOverflow
+	PageTypeBTreeMeta
+	PageTypeBTreeLeaf
+	PageTypeBTreeInternal
+	PageTypeHashMeta
+	PageTypeHashDirectory
+	PageTypeHashBucket
+)
+
+func (t PageType) String() string {
+	switch t {
+	case PageTypeHeap:
+		return "heap"
+	case PageTypeFreeSpaceMap:
+		return "free space map"
+	case PageTypeOverflowMeta:
+		return "overflow meta"
+	case PageTypeOverflow:
+		return "overflow"
+	case PageTypeBTreeMeta:
+		return "b+tree meta"
+	case PageTypeBTreeLeaf:
+		return "b+tree leaf"
+	case PageTypeBTreeInternal:
+		return "b+tree internal"
+	case PageTypeHashMeta:
+		return "hash meta"
+	case PageTypeHashDirectory:
+		return "hash directory"
+	case PageTypeHashBucket:
+		return "hash bucket"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(t))
+}
+
+// CorruptPageError is returned by Pager.ReadPage when a page fails
+// verification.
+type CorruptPageError struct {
+	Path   string
+	PageID PageID
+	Reason string
+}
+
+func (e *CorruptPageError) Error() string {
+	return fmt.Sprintf("corrupt page %d in %s: %s", e.PageID, e.Path, e.Reason)
+}
+
+func (p *Page) Type() PageType {
+	return PageType(p.Data[2])
+}
+
+func (p *Page) SetType(t PageType) {
+	p.Data[2] = byte(t)
+}
+
+func pageChecksum(data []byte) uint32 {
+	h := crc32.NewIEEE()
+	h.Write(data[:checksumOffset])
+	h.Write([]byte{0, 0, 0, 0})
+	h.Write(data[checksumOffset+4:])
+	return h.Sum32()
+}
+
+// sealPage stamps the magic, page ID and checksum before a page is written.
+func sealPage(id PageID, data []byte) {
+	binary.BigEndian.PutUint16(data[0:2], pageMagic)
+	binary.BigEndian.PutUint32(data[4:8], uint32(id))
+	binary.BigEndian.PutUint32(data[checksumOffset:checksumOffset+4], pageChecksum(data))
+}
+
+// verifyPage checks a page image read from disk. An all-zero page has never
+// been written (e.g. it was allocated past EOF) and is accepted.
+func verifyPage(id PageID, data []byte) string {
+	if isZeroPage(data) {
+		return ""
+	}
+	if magic := binary.BigEndian.Uint16(data[0:2]); magic != pageMagic {
+		return fmt.Sprintf("bad magic 0x%04x", magic)
+	}
+	if stored := PageID(binary.BigEndian.Uint32(data[4:8])); stored != id {
+		return fmt.Sprintf("page header says page %d", stored)
+	}
+	stored := binary.BigEndian.Uint32(data[checksumOffset : checksumOffset+4])
+	if actual := pageChecksum(data); stored != actual {
+		return fmt.Sprintf("checksum mismatch (stored 0x%08x, computed 0x%08x)", stored, actual)
+	}
+	return ""
+}
+
+func isZeroPage(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
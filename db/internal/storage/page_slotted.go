@@ -8,6 +8,10 @@ import (
 const (
 	PageHeaderSize = 4
 	SlotSize       = 4
+
+	// slotOverflowFlag is set in a slot's length when the slot holds an
+	// overflow pointer rather than the tuple itself.
+	slotOverflowFlag uint16 = 0x8000
 )
 
 var ErrPageFull = errors.New("page full")
@@ -23,15 +27,20 @@ type PageHeader struct {
 	FreeSpacePointer uint16
 }
 
+// slottedBodySize is the part of a page after the common page header. Slot
+// offsets and the free space pointer are relative to it.
+const slottedBodySize = PageSize - PageMetaSize
+
 func CastPage(p *Page) *SlottedPage {
 	sp := &SlottedPage{
-		Body: p.Data[:],
+		Body: p.Data[PageMetaSize:],
 	}
 	sp.Header = &PageHeader{}
 	sp.readHeader()
 
 	if sp.Header.SlotCount == 0 && sp.Header.FreeSpacePointer == 0 {
-		sp.Header.FreeSpacePointer = PageSize
+		p.SetType(PageTypeHeap)
+		sp.Header.FreeSpacePointer = slottedBodySize
 		sp.writeHeader()
 	}
 	return sp
@@ -52,21 +61,57 @@ func (sp *SlottedPage) FreeSpace() int {
 	return int(sp.Header.FreeSpacePointer) - slotsEnd
 }
 
+// ReclaimableSpace is the free space the page would have after compaction:
+// the contiguous gap plus the bytes of deleted tuples.
+func (sp *SlottedPage) ReclaimableSpace() int {
+	live := 0
+	for i := 0; i < int(sp.Header.SlotCount); i++ {
+		_, length := sp.GetSlot(i)
+		live += int(length)
+	}
+	return slottedBodySize - PageHeaderSize - int(sp.Header.SlotCount)*SlotSize - live
+}
+
+// freeSlot returns the first tombstoned slot, or -1 if every slot is in use.
+func (sp *SlottedPage) freeSlot() int {
+	for i := 0; i < int(sp.Header.SlotCount); i++ {
+		if _, length := sp.GetSlot(i); length == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// InsertTuple stores data in a tombstoned slot if there is one, otherwise in
+// a new slot. A fragmented page is compacted first when that makes room.
 func (sp *SlottedPage) InsertTuple(data []byte) (int, error) {
-	required := len(data) + SlotSize
+	return sp.insertTuple(data, 0)
+}
+
+func (sp *SlottedPage) insertTuple(data []byte, flags uint16) (int, error) {
+	slotID := sp.freeSlot()
+	required := len(data)
+	if slotID < 0 {
+		required += SlotSize
+	}
 	if sp.FreeSpace() < required {
-		return -1, ErrPageFull
+		if sp.ReclaimableSpace() < required {
+			return -1, ErrPageFull
+		}
+		sp.Compact()
 	}
 
-	slotID := int(sp.Header.SlotCount)
-	sp.Header.SlotCount++
+	if slotID < 0 {
+		slotID = int(sp.Header.SlotCount)
+		sp.Header.SlotCount++
+	}
 
 	newOffset := int(sp.Header.FreeSpacePointer) - len(data)
 	sp.Header.FreeSpacePointer = uint16(newOffset)
 
 	copy(sp.Body[newOffset:], data)
 
-	sp.writeSlot(slotID, uint16(newOffset), uint16(len(data)))
+	sp.writeSlot(slotID, uint16(newOffset), uint16(len(data))|flags)
 	sp.writeHeader()
 
 	return slotID, nil
@@ -79,12 +124,26 @@ func (sp *SlottedPage) writeSlot(id int, offset, length uint16) {
 }
 
 func (sp *SlottedPage) GetSlot(id int) (uint16, uint16) {
+	offset, length := sp.rawSlot(id)
+	return offset, length &^ slotOverflowFlag
+}
+
+func (sp *SlottedPage) rawSlot(id int) (uint16, uint16) {
 	pos := PageHeaderSize + id*SlotSize
 	offset := binary.BigEndian.Uint16(sp.Body[pos : pos+2])
 	length := binary.BigEndian.Uint16(sp.Body[pos+2 : pos+4])
 	return offset, length
 }
 
+// IsOverflow reports whether the slot holds an overflow pointer.
+func (sp *SlottedPage) IsOverflow(id int) bool {
+	if id >= int(sp.Header.SlotCount) {
+		return false
+	}
+	_, length := sp.rawSlot(id)
+	return length&slotOverflowFlag != 0
+}
+
 func (sp *SlottedPage) GetTuple(id int) []byte {
 	if id >= int(sp.Header.SlotCount) {
 		return nil
@@ -105,3 +164,47 @@ func (sp *SlottedPage) DeleteTuple(id int) error {
 	sp.writeHeader()
 	return nil
 }
+
+// Compact moves the live tuples to the end of the page, leaving all free space
+// in one gap. Slot IDs, and so RIDs, do not change.
+func (sp *SlottedPage) Compact() {
+	type live struct {
+		slot  int
+		data  []byte
+		flags uint16
+	}
+	var tuples []live
+	for i := 0; i < int(sp.Header.SlotCount); i++ {
+		if t := sp.GetTuple(i); t != nil {
+			buf := make([]byte, len(t))
+			copy(buf, t)
+			_, raw := sp.rawSlot(i)
+			tuples = append(tuples, live{slot: i, data: buf, flags: raw & slotOverflowFlag})
+		}
+	}
+
+	offset := slottedBodySize
+	for _, t := range tuples {
+		offset -= len(t.data)
+		copy(sp.Body[offset:], t.data)
+		sp.writeSlot(t.slot, uint16(offset), uint16(len(t.data))|t.flags)
+	}
+	sp.Header.FreeSpacePointer = uint16(offset)
+	sp.writeHeader()
+}
+
+// Vacuum compacts the page and drops tombstoned slots from the end of the
+// slot array. It returns the number of slots dropped.
+func (sp *SlottedPage) Vacuum() int {
+	sp.Compact()
+	trimmed := 0
+	for sp.Header.SlotCount > 0 {
+		if _, length := sp.GetSlot(int(sp.Header.SlotCount) - 1); length != 0 {
+			break
+		}
+		sp.Header.SlotCount--
+		trimmed++
+	}
+	sp.writeHeader()
+	return trimmed
+}
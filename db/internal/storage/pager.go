@@ -17,20 +17,59 @@ type Page struct {
 }
 
 type Pager struct {
-	file *os.File
-	path string
-	mu   sync.Mutex
+	file     *os.File
+	path     string
+	wal      *WAL
+	numPages int
+	readOnly bool
+	mu       sync.Mutex
 }
 
-func NewPager(path string) (*Pager, error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+// NewPager opens the file at path, writing a file header if it is new. An
+// existing file must have a header of the current format version and the
+// given kind.
+func NewPager(path string, kind FileKind) (*Pager, error) {
+	return newPager(path, kind, false)
+}
+
+// newPager opens a pager. A read-only pager opens the file read-only and
+// rejects every write with ErrReadOnly.
+func newPager(path string, kind FileKind, readOnly bool) (*Pager, error) {
+	var file *os.File
+	var err error
+	if readOnly {
+		file, err = os.Open(path)
+	} else {
+		file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &Pager{
-		file: file,
-		path: path,
-	}, nil
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 && !readOnly {
+		err = writeFileHeader(file, kind)
+	} else if info.Size() > 0 {
+		err = checkFileHeader(file, path, kind)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	p := &Pager{
+		file:     file,
+		path:     path,
+		readOnly: readOnly,
+	}
+	if err := p.resetPageCount(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
 }
 
 func (p *Pager) Close() error {
@@ -43,51 +82,146 @@ func (p *Pager) ReadPage(id PageID) (*Page, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	offset := int64(id) * PageSize
-	_, err := p.file.Seek(offset, 0)
-	if err != nil {
+	page := &Page{ID: id}
+	if err := p.readAt(id, page.Data[:]); err != nil {
 		return nil, err
 	}
+	if reason := verifyPage(id, page.Data[:]); reason != "" {
+		return nil, &CorruptPageError{Path: p.path, PageID: id, Reason: reason}
+	}
+	return page, nil
+}
 
-	page := &Page{ID: id}
-	_, err = p.file.Read(page.Data[:])
+// readAt reads a page image. Pages past EOF read as zeroes, which is how a
+// freshly allocated page looks.
+func (p *Pager) readAt(id PageID, buf []byte) error {
+	_, err := p.file.ReadAt(buf, pageOffset(id))
 	if err != nil && err != io.EOF {
-		return nil, err
+		return err
 	}
-	// If EOF, just return empty page? Or should we extend?
-	// Usually ReadPage expects existing page.
-	// For simplicity, if we read partial/EOF, it's just zeroed data if we allow implicit extension,
-	// but better to error if strictly reading.
-	// However, specifically for "Access past EOF", DBs usually handle it.
-	// Let's assume valid ID.
-	return page, nil
+	return nil
 }
 
+// WritePage seals the page header (magic, ID, checksum) and writes the page
+// in place. When the pager is attached to a WAL, the before- and after-images
+// are logged first.
 func (p *Pager) WritePage(page *Page) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	offset := int64(page.ID) * PageSize
-	_, err := p.file.Seek(offset, 0)
-	if err != nil {
-		return err
+	if p.readOnly {
+		return ErrReadOnly
 	}
+	sealPage(page.ID, page.Data[:])
 
-	_, err = p.file.Write(page.Data[:])
-	if err != nil {
+	if p.wal != nil {
+		before := make([]byte, PageSize)
+		if err := p.readAt(page.ID, before); err != nil {
+			return err
+		}
+		after := make([]byte, PageSize)
+		copy(after, page.Data[:])
+		if err := p.wal.LogPageWrite(p, page.ID, before, after); err != nil {
+			return err
+		}
+	}
+
+	if _, err := p.file.WriteAt(page.Data[:], pageOffset(page.ID)); err != nil {
 		return err
 	}
+	if int(page.ID) >= p.numPages {
+		p.numPages = int(page.ID) + 1
+	}
 
 	page.Dirty = false
 	return nil
 }
 
+// writeRaw writes a page image without logging it. It is used to roll back a
+// transaction from its logged before-images.
+func (p *Pager) writeRaw(id PageID, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	_, err := p.file.WriteAt(data, pageOffset(id))
+	return err
+}
+
+// Truncate cuts the file down to pages pages, logging the truncation first.
+// Cached copies of the dropped pages must be discarded by the caller.
+func (p *Pager) Truncate(pages int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.readOnly {
+		return ErrReadOnly
+	}
+
+	if p.wal != nil {
+		if err := p.wal.LogTruncate(p, pages); err != nil {
+			return err
+		}
+	}
+	if err := p.file.Truncate(pageOffset(PageID(pages))); err != nil {
+		return err
+	}
+	p.numPages = pages
+	return nil
+}
+
+func (p *Pager) Sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.file.Sync()
+}
+
+// PageCount includes pages that have been allocated but so far only exist in
+// the buffer pool. A read-only pager takes it from the file size every time,
+// since another process may be appending to the file.
 func (p *Pager) PageCount() (int, error) {
+	if p.readOnly {
+		if err := p.resetPageCount(); err != nil {
+			return 0, err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.numPages, nil
+}
+
+// AllocatePage reserves the next page ID at the end of the file. The page
+// reads as zeroes until it is first written.
+func (p *Pager) AllocatePage() PageID {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := PageID(p.numPages)
+	p.numPages++
+	return id
+}
+
+// resetPageCount re-reads the page count from the file size, dropping
+// allocations that were never written (e.g. after a rollback).
+func (p *Pager) resetPageCount() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	info, err := p.file.Stat()
 	if err != nil {
-		return 0, err
+		return err
+	}
+	p.numPages = int(info.Size()/PageSize) - 1
+	if p.numPages < 0 {
+		p.numPages = 0
+	}
+	return nil
+}
+
+// writeFileHeader initializes a new file. The header is synced at once so
+// that logged page writes never refer to a file without one.
+func writeFileHeader(f *os.File, kind FileKind) error {
+	h := FileHeader{Version: FormatVersion, PageSize: PageSize, Kind: kind}
+	if _, err := f.WriteAt(encodeFileHeader(h), 0); err != nil {
+		return err
 	}
-	return int(info.Size() / PageSize), nil
+	return f.Sync()
 }
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"minibank/internal/catalog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LegacyCatalogFile is where older versions kept the catalog. OpenCatalog
+// imports it into the system tables once.
+const LegacyCatalogFile = "catalog.json"
+
+// OpenCatalog reads the catalog from the system tables. If none are stored
+// yet and the data directory still has a catalog.json, the file is imported
+// in one transaction and renamed to catalog.json.migrated. A read-only
+// engine only reads the file.
+func (e *Engine) OpenCatalog() (*catalog.Catalog, error) {
+	cat := catalog.NewCatalog()
+	if err := e.LoadCatalog(cat); err != nil {
+		return nil, err
+	}
+	if len(cat.UserTables()) > 0 {
+		return cat, nil
+	}
+
+	path := filepath.Join(e.DataDir, LegacyCatalogFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return cat, nil
+	}
+	if err := cat.LoadFromFile(path); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if e.readOnly {
+		return cat, nil
+	}
+	e.Begin()
+	if err := e.SaveCatalog(cat); err != nil {
+		if abortErr := e.Abort(); abortErr != nil {
+			return nil, fmt.Errorf("%w (rollback failed: %v)", err, abortErr)
+		}
+		return nil, fmt.Errorf("failed to migrate %s: %w", path, err)
+	}
+	if err := e.Commit(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(path, path+".migrated"); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Migrated %d tables from %s into system tables\n", len(cat.UserTables()), LegacyCatalogFile)
+	return cat, nil
+}
+
+// LoadCatalog replaces the user tables of cat with the ones stored in the
+// system tables. It is also used to drop in-memory changes of a DDL statement
+// that was rolled back.
+func (e *Engine) LoadCatalog(cat *catalog.Catalog) error {
+	tables := make(map[string]*catalog.Table)
+	err := e.scanSystemTable(cat, catalog.SysTables, func(cells []Cell) error {
+		t := &catalog.Table{}
+		if err := json.Unmarshal([]byte(cells[1].Value.(string)), t); err != nil {
+			return fmt.Errorf("bad definition of table %v: %w", cells[0].Value, err)
+		}
+		tables[t.Name] = t
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	type positioned struct {
+		pos int64
+		col catalog.Column
+	}
+	columns := make(map[string][]positioned)
+	err = e.scanSystemTable(cat, catalog.SysColumns, func(cells []Cell) error {
+		table := cells[0].Value.(string)
+		var col catalog.Column
+		if err := json.Unmarshal([]byte(cells[4].Value.(string)), &col); err != nil {
+			return fmt.Errorf("bad definition of column %s.%v: %w", table, cells[2].Value, err)
+		}
+		columns[table] = append(columns[table], positioned{pos: cells[1].Value.(int64), col: col})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	indexes := make(map[string][]catalog.IndexDef)
+	err = e.scanSystemTable(cat, catalog.SysIndexes, func(cells []Cell) error {
+		table := cells[0].Value.(string)
+		var idx catalog.IndexDef
+		if err := json.Unmarshal([]byte(cells[2].Value.(string)), &idx); err != nil {
+			return fmt.Errorf("bad definition of index %v: %w", cells[1].Value, err)
+		}
+		indexes[table] = append(indexes[table], idx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var loaded []*catalog.Table
+	for name, t := range tables {
+		cols := columns[name]
+		sort.Slice(cols, func(i, j int) bool { return cols[i].pos < cols[j].pos })
+		t.Columns = make([]catalog.Column, len(cols))
+		for i, c := range cols {
+			t.Columns[i] = c.col
+		}
+		t.Indexes = indexes[name]
+		sort.Slice(t.Indexes, func(i, j int) bool { return t.Indexes[i].Name < t.Indexes[j].Name })
+		if t.Indexes == nil {
+			t.Indexes = []catalog.IndexDef{}
+		}
+		loaded = append(loaded, t)
+	}
+	cat.Load(loaded)
+	return nil
+}
+
+// SaveCatalog rewrites the system tables from cat. It must run inside a
+// transaction, normally the one of the DDL statement that changed cat, so
+// the new definitions commit or roll back together with the table's files.
+func (e *Engine) SaveCatalog(cat *catalog.Catalog) error {
+	var tableRows, columnRows, indexRows [][]Cell
+	for _, t := range cat.UserTables() {
+		def, err := json.Marshal(catalog.Table{Name: t.Name, PrimaryKey: t.PrimaryKey, Checks: t.Checks, ForeignKeys: t.ForeignKeys})
+		if err != nil {
+			return err
+		}
+		tableRows = append(tableRows, []Cell{
+			{Type: catalog.TypeString, Value: t.Name},
+			{Type: catalog.TypeString, Value: string(def)},
+		})
+		for i, col := range t.Columns {
+			def, err := json.Marshal(col)
+			if err != nil {
+				return err
+			}
+			columnRows = append(columnRows, []Cell{
+				{Type: catalog.TypeString, Value: t.Name},
+				{Type: catalog.TypeInt, Value: int64(i)},
+				{Type: catalog.TypeString, Value: col.Name},
+				{Type: catalog.TypeString, Value: string(col.Type)},
+				{Type: catalog.TypeString, Value: string(def)},
+			})
+		}
+		for _, idx := range t.Indexes {
+			def, err := json.Marshal(idx)
+			if err != nil {
+				return err
+			}
+			indexRows = append(indexRows, []Cell{
+				{Type: catalog.TypeString, Value: t.Name},
+				{Type: catalog.TypeString, Value: idx.Name},
+				{Type: catalog.TypeString, Value: string(def)},
+			})
+		}
+	}
+
+	if err := e.rewriteSystemTable(catalog.SysTables, tableRows); err != nil {
+		return err
+	}
+	if err := e.rewriteSystemTable(catalog.SysColumns, columnRows); err != nil {
+		return err
+	}
+	return e.rewriteSystemTable(catalog.SysIndexes, indexRows)
+}
+
+func (e *Engine) scanSystemTable(cat *catalog.Catalog, name string, visit func(cells []Cell) error) error {
+	table, _ := cat.GetTable(name)
+	hf, err := e.GetHeapFile(name)
+	if e.readOnly && errors.Is(err, os.ErrNotExist) {
+		return nil // never written by a read-write engine
+	}
+	if err != nil {
+		return err
+	}
+	iter := hf.Iterator()
+	for {
+		data, _, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return nil
+		}
+		t, err := DeserializeTuple(data, table.Columns)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := visit(t.Cells); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
+
+// rewriteSystemTable replaces every row of a system table. The catalog is
+// small, so rewriting it whole keeps the rows trivially in sync.
+func (e *Engine) rewriteSystemTable(name string, rows [][]Cell) error {
+	hf, err := e.GetHeapFile(name)
+	if err != nil {
+		return err
+	}
+
+	var rids []RID
+	iter := hf.Iterator()
+	for {
+		data, rid, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			break
+		}
+		rids = append(rids, rid)
+	}
+	for _, rid := range rids {
+		if err := hf.DeleteTuple(rid); err != nil {
+			return err
+		}
+	}
+
+	for _, cells := range rows {
+		data, err := SerializeTuple(&Tuple{Cells: cells})
+		if err != nil {
+			return err
+		}
+		if _, _, err := hf.Insert(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,9 +4,20 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"minibank/internal/catalog"
 )
 
+// longLength marks a STRING or DECIMAL whose length does not fit the original
+// uint16 length field; the real length follows as a uint32. Shorter values
+// keep the original encoding.
+const longLength = 0xFFFF
+
+// nullBitmapFlag is set in the cell count of tuples that carry a null bitmap:
+// one bit per column, set for NULL, and no value bytes for those columns.
+// Tuples without the flag were written before NULL existed and have none.
+const nullBitmapFlag = 0x8000
+
 type Cell struct {
 	Type  catalog.ColumnType
 	Value interface{}
@@ -25,11 +36,25 @@ type Tuple struct {
 func SerializeTuple(t *Tuple) ([]byte, error) {
 	var buf bytes.Buffer
 
-	if err := binary.Write(&buf, binary.BigEndian, uint16(len(t.Cells))); err != nil {
+	if len(t.Cells) >= nullBitmapFlag {
+		return nil, fmt.Errorf("too many columns: %d", len(t.Cells))
+	}
+	if err := binary.Write(&buf, binary.BigEndian, uint16(len(t.Cells))|nullBitmapFlag); err != nil {
 		return nil, err
 	}
 
+	bitmap := make([]byte, (len(t.Cells)+7)/8)
+	for i, cell := range t.Cells {
+		if cell.Value == nil {
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+	}
+	buf.Write(bitmap)
+
 	for _, cell := range t.Cells {
+		if cell.Value == nil {
+			continue
+		}
 		switch cell.Type {
 		case catalog.TypeInt:
 			val, ok := cell.Value.(int64)
@@ -48,8 +73,7 @@ func SerializeTuple(t *Tuple) ([]byte, error) {
 			if !ok {
 				return nil, fmt.Errorf("expected string for STRING column")
 			}
-			length := uint16(len(val))
-			if err := binary.Write(&buf, binary.BigEndian, length); err != nil {
+			if err := writeLength(&buf, len(val)); err != nil {
 				return nil, err
 			}
 			buf.WriteString(val)
@@ -68,8 +92,7 @@ func SerializeTuple(t *Tuple) ([]byte, error) {
 			if !ok {
 				return nil, fmt.Errorf("expected string (decimal) for DECIMAL column")
 			}
-			length := uint16(len(val))
-			if err := binary.Write(&buf, binary.BigEndian, length); err != nil {
+			if err := writeLength(&buf, len(val)); err != nil {
 				return nil, err
 			}
 			buf.WriteString(val)
@@ -95,13 +118,27 @@ func DeserializeTuple(data []byte, columns []catalog.Column) (*Tuple, error) {
 		return nil, err
 	}
 
+	hasBitmap := numCells&nullBitmapFlag != 0
+	numCells &^= nullBitmapFlag
+
 	if int(numCells) != len(columns) {
 		return nil, fmt.Errorf("tuple cell count %d does not match schema column count %d", numCells, len(columns))
 	}
 
+	var bitmap []byte
+	if hasBitmap {
+		bitmap = make([]byte, (len(columns)+7)/8)
+		if _, err := io.ReadFull(buf, bitmap); err != nil {
+			return nil, err
+		}
+	}
+
 	cells := make([]Cell, len(columns))
 	for i, col := range columns {
 		cells[i].Type = col.Type
+		if bitmap != nil && bitmap[i/8]&(1<<(i%8)) != 0 {
+			continue
+		}
 		switch col.Type {
 		case catalog.TypeInt:
 			var val int64
@@ -110,12 +147,12 @@ func DeserializeTuple(data []byte, columns []catalog.Column) (*Tuple, error) {
 			}
 			cells[i].Value = val
 		case catalog.TypeString:
-			var length uint16
-			if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
+			length, err := readLength(buf)
+			if err != nil {
 				return nil, err
 			}
 			strBytes := make([]byte, length)
-			if _, err := buf.Read(strBytes); err != nil {
+			if _, err := io.ReadFull(buf, strBytes); err != nil {
 				return nil, err
 			}
 			cells[i].Value = string(strBytes)
@@ -126,12 +163,12 @@ func DeserializeTuple(data []byte, columns []catalog.Column) (*Tuple, error) {
 			}
 			cells[i].Value = (b == 1)
 		case catalog.TypeDecimal:
-			var length uint16
-			if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
+			length, err := readLength(buf)
+			if err != nil {
 				return nil, err
 			}
 			strBytes := make([]byte, length)
-			if _, err := buf.Read(strBytes); err != nil {
+			if _, err := io.ReadFull(buf, strBytes); err != nil {
 				return nil, err
 			}
 			cells[i].Value = string(strBytes)
@@ -145,3 +182,31 @@ func DeserializeTuple(data []byte, columns []catalog.Column) (*Tuple, error) {
 	}
 	return &Tuple{Cells: cells}, nil
 }
+
+func writeLength(buf *bytes.Buffer, n int) error {
+	if n < longLength {
+		return binary.Write(buf, binary.BigEndian, uint16(n))
+	}
+	if int64(n) > 1<<32-1 {
+		return fmt.Errorf("value too large: %d bytes", n)
+	}
+	if err := binary.Write(buf, binary.BigEndian, uint16(longLength)); err != nil {
+		return err
+	}
+	return binary.Write(buf, binary.BigEndian, uint32(n))
+}
+
+func readLength(r io.Reader) (int, error) {
+	var short uint16
+	if err := binary.Read(r, binary.BigEndian, &short); err != nil {
+		return 0, err
+	}
+	if short != longLength {
+		return int(short), nil
+	}
+	var long uint32
+	if err := binary.Read(r, binary.BigEndian, &long); err != nil {
+		return 0, err
+	}
+	return int(long), nil
+}
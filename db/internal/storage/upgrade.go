@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TableUpgrade describes what UpgradeDataDir did, or would do, to one table.
+type TableUpgrade struct {
+	Table    string
+	Version  uint32 // format version found on disk
+	Pages    int    // pages in the old .data file
+	Tuples   int    // live tuples copied
+	NewPages int    // pages in the new .data file; 0 in a dry run
+}
+
+// NeedsUpgrade reports whether the table was written in an older format.
+func (u TableUpgrade) NeedsUpgrade() bool {
+	return u.Version != FormatVersion
+}
+
+// UpgradeDataDir converts every table in dataDir to the current format.
+// Format version 0 heap files (no file header, no page headers) are read
+// page by page and their tuples are inserted into a new heap file, which
+// also creates the free space map and overflow files. The new files are
+// written next to the old ones and renamed over them when complete, the
+// .data file last, so an interrupted upgrade can simply be run again. The
+// directory is locked while files are rewritten. With dryRun set, files are
+// only inspected: nothing is created or written, not even the LOCK file.
+func UpgradeDataDir(dataDir string, dryRun bool) ([]TableUpgrade, error) {
+	if !dryRun {
+		lock, err := lockDataDir(dataDir)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Close()
+	}
+
+	names, err := filepath.Glob(filepath.Join(dataDir, "*.data"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var results []TableUpgrade
+	for _, path := range names {
+		u, tuples, err := inspectTable(path)
+		if err != nil {
+			return results, err
+		}
+		if u.NeedsUpgrade() && !dryRun {
+			if err := checkWALEmpty(dataDir); err != nil {
+				return results, err
+			}
+			if u.NewPages, err = rewriteTable(path, tuples); err != nil {
+				return results, fmt.Errorf("upgrade %s: %w", u.Table, err)
+			}
+		}
+		results = append(results, u)
+	}
+	return results, nil
+}
+
+// inspectTable reads a table's .data file. For a current-format file it only
+// checks the companion files; for a version 0 file it returns its tuples.
+func inspectTable(path string) (TableUpgrade, [][]byte, error) {
+	u := TableUpgrade{Table: strings.TrimSuffix(filepath.Base(path), ".data")}
+	info, err := os.Stat(path)
+	if err != nil {
+		return u, nil, err
+	}
+
+	if info.Size() > 0 {
+		h, ok, err := ReadFileHeader(path)
+		if err != nil {
+			return u, nil, &FormatError{Path: path, Reason: err.Error()}
+		}
+		if ok {
+			if h.Version != FormatVersion {
+				return u, nil, &FormatError{Path: path, Reason: fmt.Sprintf("unsupported format version %d", h.Version)}
+			}
+			u.Version = h.Version
+			u.Pages = int(info.Size()/PageSize) - 1
+			return u, nil, checkCompanions(path)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return u, nil, err
+	}
+	var tuples [][]byte
+	for off := 0; off+PageSize <= len(data); off += PageSize {
+		pageTuples, err := legacyTuples(data[off : off+PageSize])
+		if err != nil {
+			return u, nil, &FormatError{Path: path, Reason: fmt.Sprintf("page %d: %v", off/PageSize, err)}
+		}
+		tuples = append(tuples, pageTuples...)
+		u.Pages++
+	}
+	u.Tuples = len(tuples)
+	return u, tuples, nil
+}
+
+// checkCompanions makes sure the free space map and overflow files of a
+// current-format table have headers too, if they exist.
+func checkCompanions(dataPath string) error {
+	base := strings.TrimSuffix(dataPath, ".data")
+	for _, path := range []string{base + ".fsm", base + ".ovf"} {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		h, ok, err := ReadFileHeader(path)
+		if err != nil {
+			return &FormatError{Path: path, Reason: err.Error()}
+		}
+		if !ok || h.Version != FormatVersion {
+			return &FormatError{Path: path, Reason: "does not match the format of its .data file"}
+		}
+	}
+	return nil
+}
+
+// legacyTuples returns the live tuples of a format version 0 heap page:
+//
+//	[slot count uint16][free space pointer uint16][slot: offset, length]...
+//
+// with tuples growing down from the end of the page and deleted tuples marked
+// by a zero length.
+func legacyTuples(page []byte) ([][]byte, error) {
+	slotCount := int(binary.BigEndian.Uint16(page[0:2]))
+	fsp := int(binary.BigEndian.Uint16(page[2:4]))
+	if slotCount == 0 && fsp == 0 {
+		return nil, nil
+	}
+	slotsEnd := PageHeaderSize + slotCount*SlotSize
+	if fsp > PageSize || slotsEnd > fsp {
+		return nil, fmt.Errorf("not a heap page (%d slots, free space pointer %d)", slotCount, fsp)
+	}
+
+	var tuples [][]byte
+	for i := 0; i < slotCount; i++ {
+		pos := PageHeaderSize + i*SlotSize
+		offset := int(binary.BigEndian.Uint16(page[pos : pos+2]))
+		length := int(binary.BigEndian.Uint16(page[pos+2 : pos+4]))
+		if length == 0 {
+			continue
+		}
+		if offset < fsp || offset+length > PageSize {
+			return nil, fmt.Errorf("slot %d points outside the tuple area", i)
+		}
+		tuple := make([]byte, length)
+		copy(tuple, page[offset:offset+length])
+		tuples = append(tuples, tuple)
+	}
+	return tuples, nil
+}
+
+// rewriteTable writes tuples into new .data, .fsm and .ovf files and renames
+// them over the old ones. It returns the new number of heap pages.
+func rewriteTable(dataPath string, tuples [][]byte) (int, error) {
+	base := strings.TrimSuffix(dataPath, ".data")
+	files := []struct {
+		path string
+		kind FileKind
+	}{
+		{base + ".data", FileKindHeap},
+		{base + ".fsm", FileKindFreeSpaceMap},
+		{base + ".ovf", FileKindOverflow},
+	}
+
+	pagers := make([]*Pager, len(files))
+	defer func() {
+		for _, p := range pagers {
+			if p != nil {
+				p.Close()
+			}
+		}
+	}()
+	for i, f := range files {
+		tmp := f.path + ".upgrade"
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		p, err := NewPager(tmp, f.kind)
+		if err != nil {
+			return 0, err
+		}
+		pagers[i] = p
+	}
+
+	pool := NewBufferPool(DefaultBufferPoolPages)
+	hf := NewHeapFile(pagers[0], NewFreeSpaceMap(pagers[1], pool), NewOverflowFile(pagers[2], pool), pool)
+	for _, t := range tuples {
+		if _, _, err := hf.Insert(t); err != nil {
+			return 0, err
+		}
+	}
+	if err := pool.FlushAll(); err != nil {
+		return 0, err
+	}
+	for _, p := range pagers {
+		if err := p.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	pages, _ := pagers[0].PageCount()
+
+	// The .data file goes last: until it is replaced, the table is still in
+	// the old format and a rerun redoes the whole conversion.
+	for i := len(files) - 1; i >= 0; i-- {
+		if err := os.Rename(files[i].path+".upgrade", files[i].path); err != nil {
+			return 0, err
+		}
+	}
+	return pages, syncDir(filepath.Dir(dataPath))
+}
+
+func checkWALEmpty(dataDir string) error {
+	info, err := os.Stat(filepath.Join(dataDir, WALFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() > 0 {
+		return fmt.Errorf("%s is not empty; open the directory with the version that wrote it first so it can recover", WALFileName)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// checkDataDir refuses to open a directory that still holds files in an
+// older format, before recovery could write to them.
+func checkDataDir(dataDir string) error {
+	for _, ext := range []string{".data", ".fsm", ".ovf", IndexFileExt} {
+		paths, err := filepath.Glob(filepath.Join(dataDir, "*"+ext))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if info.Size() == 0 {
+				continue
+			}
+			h, ok, err := ReadFileHeader(path)
+			if err != nil {
+				return &FormatError{Path: path, Reason: err.Error()}
+			}
+			if !ok {
+				return &FormatError{Path: path, Reason: "written by an older version (format version 0); run `minibank -mode upgrade` first"}
+			}
+			if h.Version != FormatVersion {
+				return &FormatError{Path: path, Reason: fmt.Sprintf("unsupported format version %d (this build reads version %d)", h.Version, FormatVersion)}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"fmt"
+	"minibank/internal/catalog"
+	"path/filepath"
+	"sort"
+)
+
+// Problem is one integrity violation found by HeapFile.Verify.
+type Problem struct {
+	File   string
+	PageID PageID
+	SlotID int // -1 for page-level problems
+	Msg    string
+	Repair string
+	// Unread is set when the page, or the slot, could not be read, so its
+	// tuples were not visited.
+	Unread bool
+}
+
+func (p Problem) String() string {
+	loc := fmt.Sprintf("%s page %d", p.File, p.PageID)
+	if p.SlotID >= 0 {
+		loc += fmt.Sprintf(" slot %d", p.SlotID)
+	}
+	return fmt.Sprintf("%s: %s", loc, p.Msg)
+}
+
+const repairRestore = "restore the table from a backup; the page was damaged outside the database and the WAL cannot rebuild it"
+
+// Verify checks every page of the heap file: the page header and checksum,
+// the slot array against the free space pointer, overflow chains, the free
+// space map entry, and that each tuple deserializes against columns. visit is
+// called for every tuple that passed. Pages are read straight from disk after
+// flushing the buffer pool, so a damaged page is reported instead of ending
+// the walk.
+func (hf *HeapFile) Verify(columns []catalog.Column, visit func(rid RID, t *Tuple)) (pages int, problems []Problem, err error) {
+	if err := hf.pool.FlushAll(); err != nil {
+		return 0, nil, err
+	}
+
+	hf.mu.Lock()
+	defer hf.mu.Unlock()
+
+	file := filepath.Base(hf.pager.path)
+	count, err := hf.pager.PageCount()
+	if err != nil {
+		return 0, nil, err
+	}
+	report := func(pid PageID, slot int, repair, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: file, PageID: pid, SlotID: slot, Msg: fmt.Sprintf(format, args...), Repair: repair})
+	}
+	unread := func(pid PageID, slot int, repair, format string, args ...interface{}) {
+		report(pid, slot, repair, format, args...)
+		problems[len(problems)-1].Unread = true
+	}
+
+	for pid := PageID(0); int(pid) < count; pid++ {
+		page, err := hf.pager.ReadPage(pid)
+		if err != nil {
+			if corrupt, ok := err.(*CorruptPageError); ok {
+				unread(pid, -1, repairRestore, "%s", corrupt.Reason)
+				continue
+			}
+			return pages, problems, err
+		}
+		pages++
+		if t := page.Type(); t != PageTypeHeap && !isZeroPage(page.Data[:]) {
+			unread(pid, -1, repairRestore, "unexpected %s page in heap file", t)
+			continue
+		}
+
+		sp := CastPage(page)
+		fsp := int(sp.Header.FreeSpacePointer)
+		slotsEnd := PageHeaderSize + int(sp.Header.SlotCount)*SlotSize
+		if fsp > slottedBodySize || slotsEnd > fsp {
+			unread(pid, -1, repairRestore, "slot array (%d slots, ends at %d) overlaps free space pointer %d", sp.Header.SlotCount, slotsEnd, fsp)
+			continue
+		}
+
+		type extent struct{ slot, start, end int }
+		var extents []extent
+		for slot := 0; slot < int(sp.Header.SlotCount); slot++ {
+			offset, length := sp.GetSlot(slot)
+			if length == 0 {
+				continue
+			}
+			start, end := int(offset), int(offset)+int(length)
+			if start < fsp || end > slottedBodySize {
+				unread(pid, slot, repairRestore, "tuple [%d,%d) lies outside the tuple area [%d,%d)", start, end, fsp, slottedBodySize)
+				continue
+			}
+			extents = append(extents, extent{slot, start, end})
+
+			data, err := hf.readSlot(sp, slot)
+			if err != nil {
+				unread(pid, slot, "DELETE the row, then VACUUM the table; the overflow chain is damaged", "overflow chain: %v", err)
+				continue
+			}
+			tuple, err := DeserializeTuple(data, columns)
+			if err != nil {
+				unread(pid, slot, "DELETE the row or restore it from a backup", "tuple does not match schema: %v", err)
+				continue
+			}
+			rid := RID{PageID: pid, SlotID: slot}
+			tuple.RID = rid
+			if visit != nil {
+				visit(rid, tuple)
+			}
+		}
+
+		sort.Slice(extents, func(i, j int) bool { return extents[i].start < extents[j].start })
+		for i := 1; i < len(extents); i++ {
+			if extents[i].start < extents[i-1].end {
+				report(pid, extents[i].slot, repairRestore, "tuple overlaps slot %d", extents[i-1].slot)
+			}
+		}
+
+		free, err := hf.fsm.Get(pid)
+		if err != nil {
+			report(pid, -1, "VACUUM the table to rewrite its free space map", "free space map unreadable: %v", err)
+		} else if actual := sp.ReclaimableSpace(); free != actual {
+			report(pid, -1, "VACUUM the table to rewrite its free space map", "free space map says %d bytes free, page has %d", free, actual)
+		}
+	}
+	return pages, problems, nil
+}
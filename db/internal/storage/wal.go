@@ -0,0 +1,436 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	WALFileName = "minibank.wal"
+
+	// checkpointThreshold is the log size after which a commit triggers a
+	// checkpoint (sync data files, truncate the log).
+	checkpointThreshold = 4 << 20
+
+	// maxWALRecordSize bounds the body of a record: LSN, txn and type, then
+	// for a page write the file name with its length, the page ID and two
+	// page images.
+	maxWALRecordSize = 8 + 8 + 1 + 2 + 1<<16 - 1 + 4 + 2*PageSize
+)
+
+type walRecordType uint8
+
+const (
+	walPageWrite walRecordType = iota + 1
+	walCommit
+	walAbort
+	walTruncate
+)
+
+// autoCommitTxn tags page writes that happen outside Begin/Commit. They are
+// treated as committed on recovery.
+const autoCommitTxn uint64 = 0
+
+type walRecord struct {
+	LSN    uint64
+	Txn    uint64
+	Type   walRecordType
+	File   string
+	PageID PageID
+	Before []byte
+	After  []byte
+}
+
+type walUndo struct {
+	pager  *Pager
+	id     PageID
+	before []byte
+}
+
+// WAL is an undo/redo log of full page images. Every page write is appended
+// (and fsynced) before the page itself reaches the data file, so recovery can
+// redo committed transactions and roll back uncommitted ones, including pages
+// torn by a crash mid-write.
+type WAL struct {
+	file      *os.File
+	path      string
+	mu        sync.Mutex
+	nextLSN   uint64
+	nextTxn   uint64
+	activeTxn uint64
+	undo      []walUndo
+	size      int64
+}
+
+func OpenWAL(path string) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &WAL{file: file, path: path, nextLSN: 1, nextTxn: 1, size: info.Size()}, nil
+}
+
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+func (w *WAL) Begin() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.activeTxn = w.nextTxn
+	w.nextTxn++
+	w.undo = nil
+	return w.activeTxn
+}
+
+// LogPageWrite durably records a page change before it is written in place.
+func (w *WAL) LogPageWrite(p *Pager, id PageID, before, after []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec := &walRecord{
+		Txn:    w.activeTxn,
+		Type:   walPageWrite,
+		File:   filepath.Base(p.path),
+		PageID: id,
+		Before: before,
+		After:  after,
+	}
+	if err := w.append(rec); err != nil {
+		return err
+	}
+	if w.activeTxn != autoCommitTxn {
+		w.undo = append(w.undo, walUndo{pager: p, id: id, before: before})
+	}
+	return nil
+}
+
+// LogTruncate durably records that a file is about to be cut to pages pages.
+// Only trailing empty pages are ever truncated, so there is nothing to undo:
+// the before-images of pages the same transaction emptied restore them.
+func (w *WAL) LogTruncate(p *Pager, pages int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.append(&walRecord{
+		Txn:    w.activeTxn,
+		Type:   walTruncate,
+		File:   filepath.Base(p.path),
+		PageID: PageID(pages),
+	})
+}
+
+func (w *WAL) Commit() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.activeTxn == autoCommitTxn {
+		return nil
+	}
+	err := w.append(&walRecord{Txn: w.activeTxn, Type: walCommit})
+	w.activeTxn = autoCommitTxn
+	w.undo = nil
+	return err
+}
+
+// Abort restores the before-images written by the active transaction, newest
+// first, syncs them and records the abort. Once the abort record is in the
+// log, recovery undoes the transaction at that point, before replaying the
+// transactions that follow it.
+func (w *WAL) Abort() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.activeTxn == autoCommitTxn {
+		return nil
+	}
+	pagers := make(map[*Pager]bool)
+	for i := len(w.undo) - 1; i >= 0; i-- {
+		u := w.undo[i]
+		if err := u.pager.writeRaw(u.id, u.before); err != nil {
+			return fmt.Errorf("abort: failed to restore page %d of %s: %w", u.id, u.pager.path, err)
+		}
+		pagers[u.pager] = true
+	}
+	for p := range pagers {
+		if err := p.Sync(); err != nil {
+			return fmt.Errorf("abort: failed to sync %s: %w", p.path, err)
+		}
+	}
+	err := w.append(&walRecord{Txn: w.activeTxn, Type: walAbort})
+	w.activeTxn = autoCommitTxn
+	w.undo = nil
+	return err
+}
+
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+// Truncate empties the log. Callers must have synced every data file first.
+func (w *WAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.activeTxn != autoCommitTxn {
+		return fmt.Errorf("cannot truncate log while transaction %d is active", w.activeTxn)
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.size = 0
+	return nil
+}
+
+func (w *WAL) append(rec *walRecord) error {
+	rec.LSN = w.nextLSN
+	w.nextLSN++
+
+	body := encodeWALRecord(rec)
+	var frame bytes.Buffer
+	binary.Write(&frame, binary.BigEndian, uint32(len(body)))
+	binary.Write(&frame, binary.BigEndian, crc32.ChecksumIEEE(body))
+	frame.Write(body)
+
+	if _, err := w.file.WriteAt(frame.Bytes(), w.size); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.size += int64(frame.Len())
+	return nil
+}
+
+func encodeWALRecord(rec *walRecord) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, rec.LSN)
+	binary.Write(&buf, binary.BigEndian, rec.Txn)
+	buf.WriteByte(byte(rec.Type))
+	if rec.Type == walPageWrite || rec.Type == walTruncate {
+		binary.Write(&buf, binary.BigEndian, uint16(len(rec.File)))
+		buf.WriteString(rec.File)
+		binary.Write(&buf, binary.BigEndian, uint32(rec.PageID))
+	}
+	if rec.Type == walPageWrite {
+		buf.Write(rec.Before)
+		buf.Write(rec.After)
+	}
+	return buf.Bytes()
+}
+
+func decodeWALRecord(body []byte) (*walRecord, error) {
+	r := bytes.NewReader(body)
+	rec := &walRecord{}
+	if err := binary.Read(r, binary.BigEndian, &rec.LSN); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &rec.Txn); err != nil {
+		return nil, err
+	}
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	rec.Type = walRecordType(t)
+	if rec.Type != walPageWrite && rec.Type != walTruncate {
+		return rec, nil
+	}
+
+	var nameLen uint16
+	if err := binary.Read(r, binary.BigEndian, &nameLen); err != nil {
+		return nil, err
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return nil, err
+	}
+	rec.File = string(name)
+	var pid uint32
+	if err := binary.Read(r, binary.BigEndian, &pid); err != nil {
+		return nil, err
+	}
+	rec.PageID = PageID(pid)
+	if rec.Type == walTruncate {
+		return rec, nil
+	}
+	rec.Before = make([]byte, PageSize)
+	rec.After = make([]byte, PageSize)
+	if _, err := io.ReadFull(r, rec.Before); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, rec.After); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// readAll returns every intact record. A short or corrupt frame, or one whose
+// length is over maxWALRecordSize, marks the end of the log: it can only be
+// the tail of an append interrupted by a crash.
+func (w *WAL) readAll() ([]*walRecord, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var records []*walRecord
+	var offset int64
+	header := make([]byte, 8)
+	for {
+		if _, err := w.file.ReadAt(header, offset); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		length := binary.BigEndian.Uint32(header[0:4])
+		sum := binary.BigEndian.Uint32(header[4:8])
+		// A length no record can have is garbage, like a bad checksum;
+		// check it before allocating the body.
+		if length > maxWALRecordSize {
+			break
+		}
+		body := make([]byte, length)
+		if _, err := w.file.ReadAt(body, offset+8); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(body) != sum {
+			break
+		}
+		rec, err := decodeWALRecord(body)
+		if err != nil {
+			break
+		}
+		records = append(records, rec)
+		offset += 8 + int64(length)
+		if rec.LSN >= w.nextLSN {
+			w.nextLSN = rec.LSN + 1
+		}
+		if rec.Txn >= w.nextTxn {
+			w.nextTxn = rec.Txn + 1
+		}
+	}
+	return records, nil
+}
+
+// Recover replays the log against the data files in dataDir in log order,
+// repeating history: every page image is redone, and at the abort record of
+// a transaction its before-images are restored, newest first, just as Abort
+// did. Transactions that neither committed nor aborted are then undone in
+// reverse order. Undoing an aborted transaction where it ended, rather than
+// after the replay, keeps its before-images from overwriting pages that later
+// transactions committed. The data files are synced and the log is truncated
+// afterwards.
+func (w *WAL) Recover(dataDir string) (int, error) {
+	records, err := w.readAll()
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return 0, w.Truncate()
+	}
+
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	openFile := func(name string) (*os.File, error) {
+		if f, ok := files[name]; ok {
+			return f, nil
+		}
+		f, err := os.OpenFile(filepath.Join(dataDir, name), os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = f
+		return f, nil
+	}
+	writeImage := func(rec *walRecord, image []byte) error {
+		f, err := openFile(rec.File)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteAt(image, pageOffset(rec.PageID))
+		return err
+	}
+	undo := func(writes []*walRecord) (int, error) {
+		for i := len(writes) - 1; i >= 0; i-- {
+			rec := writes[i]
+			if err := writeImage(rec, rec.Before); err != nil {
+				return len(writes) - 1 - i, fmt.Errorf("recovery undo of %s page %d: %w", rec.File, rec.PageID, err)
+			}
+		}
+		return len(writes), nil
+	}
+
+	applied := 0
+	// pending holds the page writes of each transaction that has not yet
+	// committed or aborted.
+	pending := make(map[uint64][]*walRecord)
+	var order []uint64
+	for _, rec := range records {
+		switch rec.Type {
+		case walPageWrite:
+			if err := writeImage(rec, rec.After); err != nil {
+				return applied, fmt.Errorf("recovery redo of %s page %d: %w", rec.File, rec.PageID, err)
+			}
+			applied++
+			if rec.Txn != autoCommitTxn {
+				if _, ok := pending[rec.Txn]; !ok {
+					order = append(order, rec.Txn)
+				}
+				pending[rec.Txn] = append(pending[rec.Txn], rec)
+			}
+		case walTruncate:
+			f, err := openFile(rec.File)
+			if err != nil {
+				return applied, err
+			}
+			if err := f.Truncate(pageOffset(rec.PageID)); err != nil {
+				return applied, fmt.Errorf("recovery truncate of %s: %w", rec.File, err)
+			}
+		case walCommit:
+			delete(pending, rec.Txn)
+		case walAbort:
+			n, err := undo(pending[rec.Txn])
+			applied += n
+			if err != nil {
+				return applied, err
+			}
+			delete(pending, rec.Txn)
+		}
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		n, err := undo(pending[order[i]])
+		applied += n
+		if err != nil {
+			return applied, err
+		}
+	}
+
+	for _, f := range files {
+		if err := f.Sync(); err != nil {
+			return applied, err
+		}
+	}
+	return applied, w.Truncate()
+}
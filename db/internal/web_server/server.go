@@ -4,13 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"minibank/internal/catalog"
-	"minibank/internal/indexing"
 	"minibank/internal/parser"
 	"minibank/internal/planner"
 	"minibank/internal/storage"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -30,7 +28,9 @@ func (s *Server) Start(port string) error {
 	mux.HandleFunc("/api/users", s.handleUsers)
 	mux.HandleFunc("/api/wallets", s.handleWallets)
 	mux.HandleFunc("/api/transactions", s.handleTransactions)
-	mux.HandleFunc("/api/reports/user-wallets", s.handleReport)
+	mux.HandleFunc("/api/reports/", s.handleReport)
+	mux.HandleFunc("/api/stats", s.handleStats)
+	mux.HandleFunc("/api/indexes", s.handleIndexes)
 
 	handler := s.enableCORS(mux)
 
@@ -54,7 +54,7 @@ func (s *Server) ensureDemoSchema() error {
 	// Create wallets table
 	if _, exists := s.Catalog.GetTable("wallets"); !exists {
 		fmt.Println("Initializing 'wallets' table...")
-		resp := s.executeQuery("CREATE TABLE wallets (id INT, user_id INT, balance DECIMAL, PRIMARY KEY (id))")
+		resp := s.executeQuery("CREATE TABLE wallets (id INT, user_id INT REFERENCES users ON DELETE CASCADE, balance DECIMAL, PRIMARY KEY (id))")
 		if resp.Error != "" {
 			return fmt.Errorf("failed to create wallets table: %s", resp.Error)
 		}
@@ -62,7 +62,7 @@ func (s *Server) ensureDemoSchema() error {
 	// Create transactions table
 	if _, exists := s.Catalog.GetTable("transactions"); !exists {
 		fmt.Println("Initializing 'transactions' table...")
-		resp := s.executeQuery("CREATE TABLE transactions (id INT, wallet_id INT, amount DECIMAL, type STRING, PRIMARY KEY (id))")
+		resp := s.executeQuery("CREATE TABLE transactions (id INT, wallet_id INT REFERENCES wallets, amount DECIMAL, type STRING, PRIMARY KEY (id))")
 		if resp.Error != "" {
 			return fmt.Errorf("failed to create transactions table: %s", resp.Error)
 		}
@@ -82,24 +82,115 @@ func (s *Server) handleTransactions(w http.ResponseWriter, r *http.Request) {
 	s.handleGenericCRUD(w, r, "transactions", "id", []string{"id", "wallet_id", "amount", "type"})
 }
 
+// reports are the queries behind /api/reports/<name>.
+var reports = map[string]string{
+	// JOIN users + wallets
+	"user-wallets": "SELECT users.id, users.name, wallets.balance FROM users JOIN wallets ON users.id = wallets.user_id",
+	// Total balance per user
+	"user-balances": "SELECT users.id, users.name, COUNT(wallets.id), SUM(wallets.balance) FROM users JOIN wallets ON users.id = wallets.user_id GROUP BY users.id, users.name ORDER BY users.id",
+	// Transaction volume per type
+	"transaction-volume": "SELECT type, COUNT(*), SUM(amount), AVG(amount) FROM transactions GROUP BY type ORDER BY type",
+}
+
 func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	// JOIN users + wallets
-	sql := "SELECT users.id, users.name, wallets.balance FROM users JOIN wallets ON users.id = wallets.user_id"
+	sql, ok := reports[strings.TrimPrefix(r.URL.Path, "/api/reports/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
 	resp := s.executeQuery(sql)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	st := s.Planner.Storage.BufferPoolStats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"buffer_pool": map[string]interface{}{
+			"capacity":  st.Capacity,
+			"resident":  st.Resident,
+			"hits":      st.Hits,
+			"misses":    st.Misses,
+			"evictions": st.Evictions,
+		},
+	})
+}
+
+// handleIndexes lists the indexes (GET, optionally ?table=), rebuilds the
+// indexes of a table or one index (POST with ?table= or ?index=) and drops
+// an index (DELETE with ?index=). An index may be named as table.index.
+func (s *Server) handleIndexes(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var stmt parser.ASTNode
+	switch r.Method {
+	case "GET":
+		stmt = &parser.ShowIndexesStmt{TableName: q.Get("table")}
+	case "POST":
+		switch {
+		case q.Get("index") != "":
+			stmt = &parser.ReindexStmt{Index: indexRef(q.Get("index"))}
+		case q.Get("table") != "":
+			stmt = &parser.ReindexStmt{TableName: q.Get("table")}
+		default:
+			http.Error(w, "table or index is required", http.StatusBadRequest)
+			return
+		}
+	case "DELETE":
+		if q.Get("index") == "" {
+			http.Error(w, "index is required", http.StatusBadRequest)
+			return
+		}
+		stmt = &parser.DropIndexStmt{Index: *indexRef(q.Get("index"))}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.executeStmt(stmt))
+}
+
+func indexRef(name string) *parser.IndexRef {
+	if i := strings.Index(name, "."); i >= 0 {
+		return &parser.IndexRef{TableName: name[:i], IndexName: name[i+1:]}
+	}
+	return &parser.IndexRef{IndexName: name}
+}
+
 func (s *Server) handleGenericCRUD(w http.ResponseWriter, r *http.Request, table string, pkCol string, columns []string) {
 	w.Header().Set("Content-Type", "application/json")
 
 	switch r.Method {
 	case "GET":
+		// ?limit=n returns a page of n rows; ?after=k starts it after the
+		// primary key k, the last one of the previous page.
 		sql := fmt.Sprintf("SELECT * FROM %s", table)
+		query := r.URL.Query()
+		if after := query.Get("after"); after != "" {
+			key, err := strconv.Atoi(after)
+			if err != nil {
+				http.Error(w, "Invalid after key", http.StatusBadRequest)
+				return
+			}
+			sql += fmt.Sprintf(" WHERE %s > %d", pkCol, key)
+		}
+		sql += " ORDER BY " + pkCol
+		if limit := query.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			sql += fmt.Sprintf(" LIMIT %d", n)
+		}
 		resp := s.executeQuery(sql)
 		json.NewEncoder(w).Encode(resp)
 
@@ -209,6 +300,8 @@ type QueryRequest struct {
 	Query string `json:"query"`
 }
 
+// QueryResponse is the JSON body of every query endpoint. NULL values are
+// encoded as JSON null.
 type QueryResponse struct {
 	Columns []string        `json:"columns"`
 	Rows    [][]interface{} `json:"rows"`
@@ -239,6 +332,8 @@ func (s *Server) executePrepared(sql string, args []interface{}) QueryResponse {
 			argIdx++
 
 			switch v := val.(type) {
+			case nil:
+				sb.WriteString("NULL")
 			case string:
 				safe := strings.ReplaceAll(v, "'", "''")
 				sb.WriteString(fmt.Sprintf("'%s'", safe))
@@ -266,80 +361,62 @@ func (s *Server) executeQuery(sql string) QueryResponse {
 	if err != nil {
 		return QueryResponse{Error: err.Error()}
 	}
+	return s.executeStmt(ast)
+}
 
-	if createStmt, ok := ast.(*parser.CreateTableStmt); ok {
-		err := s.Catalog.CreateTable(createStmt.TableName, createStmt.Columns)
-		if err != nil {
-			return QueryResponse{Error: err.Error()}
-		}
-		if err := s.Catalog.SaveToFile(filepath.Join(s.DataDir, "catalog.json")); err != nil {
-			return QueryResponse{Error: fmt.Sprintf("failed to save catalog: %v", err)}
+// executeStmt runs one statement as a transaction.
+func (s *Server) executeStmt(ast parser.ASTNode) QueryResponse {
+	store := s.Planner.Storage
+	store.Begin()
+	resp := s.execute(ast)
+	if resp.Error != "" {
+		if err := store.Abort(); err != nil {
+			resp.Error = fmt.Sprintf("%s (rollback failed: %v)", resp.Error, err)
+		} else if parser.IsDDL(ast) {
+			if err := s.Planner.ReloadCatalog(); err != nil {
+				resp.Error = fmt.Sprintf("%s (reloading catalog failed: %v)", resp.Error, err)
+			}
 		}
-		return QueryResponse{Columns: []string{"Result"}, Rows: [][]interface{}{{"Table Created"}}}
+		return resp
 	}
-
-	if createIdx, ok := ast.(*parser.CreateIndexStmt); ok {
-		table, exists := s.Catalog.GetTable(createIdx.TableName)
-		if !exists {
-			return QueryResponse{Error: fmt.Sprintf("table %s not found", createIdx.TableName)}
-		}
-
-		colIdx := -1
-		for i, col := range table.Columns {
-			if col.Name == createIdx.Column {
-				colIdx = i
-				break
+	if err := store.Commit(); err != nil {
+		resp = QueryResponse{Error: fmt.Sprintf("commit failed: %v", err)}
+		if parser.IsDDL(ast) {
+			// A failed commit may have rolled the statement back.
+			if err := s.Planner.ReloadCatalog(); err != nil {
+				resp.Error = fmt.Sprintf("%s (reloading catalog failed: %v)", resp.Error, err)
 			}
 		}
-		if colIdx == -1 {
-			return QueryResponse{Error: fmt.Sprintf("column %s not found", createIdx.Column)}
-		}
-
-		idx := indexing.NewHashIndex()
-		hf, err := s.Planner.Storage.GetHeapFile(createIdx.TableName)
-		if err != nil {
-			return QueryResponse{Error: err.Error()}
-		}
+		return resp
+	}
+	return resp
+}
 
-		iter := hf.Iterator()
-		defer iter.Close()
-		count := 0
-		for {
-			data, rid, err := iter.Next()
-			if err != nil {
-				return QueryResponse{Error: err.Error()}
-			}
-			if data == nil {
-				break
-			}
+func (s *Server) execute(ast parser.ASTNode) QueryResponse {
+	if s.Planner.Storage.ReadOnly() && !parser.ReadOnly(ast) {
+		return QueryResponse{Error: storage.ErrReadOnly.Error()}
+	}
 
-			tuple, err := storage.DeserializeTuple(data, table.Columns)
-			if err != nil {
-				return QueryResponse{Error: err.Error()}
-			}
-			idx.Insert(tuple.Cells[colIdx].Value, rid)
-			count++
+	if createStmt, ok := ast.(*parser.CreateTableStmt); ok {
+		if err := s.Planner.CreateTable(createStmt); err != nil {
+			return QueryResponse{Error: err.Error()}
 		}
+		return QueryResponse{Columns: []string{"Result"}, Rows: [][]interface{}{{"Table Created"}}}
+	}
 
-		key := fmt.Sprintf("%s.%s", createIdx.TableName, createIdx.Column)
-		s.Planner.Indices[key] = idx
-
-		// Persist
-		err = s.Catalog.AddIndex(createIdx.TableName, catalog.IndexDef{
-			Name:     createIdx.IndexName,
-			Column:   createIdx.Column,
-			Type:     "HASH",
-			IsUnique: false,
-		})
+	if createIdx, ok := ast.(*parser.CreateIndexStmt); ok {
+		count, err := s.Planner.CreateIndex(createIdx)
 		if err != nil {
 			return QueryResponse{Error: err.Error()}
 		}
+		return QueryResponse{Columns: []string{"Result"}, Rows: [][]interface{}{{fmt.Sprintf("Index Created (%d entries)", count)}}}
+	}
 
-		if err := s.Catalog.SaveToFile(filepath.Join(s.DataDir, "catalog.json")); err != nil {
-			return QueryResponse{Error: fmt.Sprintf("failed to save catalog: %v", err)}
+	if dropIdx, ok := ast.(*parser.DropIndexStmt); ok {
+		if err := s.Planner.DropIndex(dropIdx); err != nil {
+			return QueryResponse{Error: err.Error()}
 		}
-
-		return QueryResponse{Columns: []string{"Result"}, Rows: [][]interface{}{{fmt.Sprintf("Index Created (%d entries)", count)}}}
+		return QueryResponse{Columns: []string{"Result"}, Rows: [][]interface{}{{"Index Dropped"}}}
 	}
 
 	iter, err := s.Planner.CreatePlan(ast)
@@ -8,9 +8,8 @@ trap cleanup EXIT
 
 PROJECT_ROOT=$(cd "$(dirname "$0")/.." && pwd)
 DATA_DIR="$PROJECT_ROOT/data-test"
+rm -rf "$DATA_DIR"
 mkdir -p "$DATA_DIR"
-rm -f "$DATA_DIR/catalog.json"
-rm -f "$DATA_DIR/users.data"
 
 cd "$PROJECT_ROOT/db"
 
@@ -29,10 +28,10 @@ SELECT * FROM users WHERE id = 1;
 exit
 " | go run cmd/minibank/main.go -data "$DATA_DIR" 2>&1)
 
-if echo "$OUTPUT" | grep -q "Rebuilding indices"; then
-    echo "PASS: Index rebuild triggered."
+if echo "$OUTPUT" | grep -q "Using IndexScan on users.id" && echo "$OUTPUT" | grep -q "Alice"; then
+    echo "PASS: Index opened from disk and used."
 else
-    echo "FAIL: Index rebuild not triggered."
+    echo "FAIL: Index not used after restart."
     echo "Output was:"
     echo "$OUTPUT"
     exit 1
@@ -0,0 +1,119 @@
+'use client'
+
+import { useState, useEffect } from 'react'
+import { Card, CardContent, CardHeader, CardTitle } from '@/components/ui/card'
+import { RefreshCw } from 'lucide-react'
+import { Button } from '@/components/ui/button'
+
+type Report = { columns: string[]; rows: (string | number | null)[][] }
+
+export default function TotalsPage() {
+    const [balances, setBalances] = useState<Report | null>(null)
+    const [volume, setVolume] = useState<Report | null>(null)
+    const [loading, setLoading] = useState(true)
+    const [error, setError] = useState<string | null>(null)
+
+    const API_URL = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080';
+
+    const fetchReport = async (name: string): Promise<Report> => {
+        const res = await fetch(`${API_URL}/api/reports/${name}`)
+        if (!res.ok) throw new Error(`Request failed: ${res.status}`)
+
+        const data = await res.json()
+        if (data.error) throw new Error(data.error)
+        return { columns: data.columns || [], rows: data.rows || [] }
+    }
+
+    const fetchReports = async () => {
+        setLoading(true)
+        setError(null)
+        try {
+            const [b, v] = await Promise.all([fetchReport('user-balances'), fetchReport('transaction-volume')])
+            setBalances(b)
+            setVolume(v)
+        } catch (err: any) {
+            console.error(err)
+            setError(err?.message || 'Failed to fetch reports')
+        } finally {
+            setLoading(false)
+        }
+    }
+
+    useEffect(() => {
+        fetchReports()
+    }, [])
+
+    return (
+        <div className="space-y-6">
+            <header className="flex justify-between items-center">
+                <div>
+                    <h1 className="text-3xl font-bold text-slate-900">Totals</h1>
+                    <p className="text-slate-500 mt-1">Balances per user and transaction volume per type (GROUP BY)</p>
+                </div>
+                <Button onClick={fetchReports} variant="outline">
+                    <RefreshCw size={16} className="mr-2" />
+                    Refresh
+                </Button>
+            </header>
+
+            {error && <p className="text-red-600">{error}</p>}
+
+            <ReportTable
+                title="Total balance per user"
+                headers={['User ID', 'Name', 'Wallets', 'Total balance']}
+                report={balances}
+                loading={loading}
+            />
+            <ReportTable
+                title="Transaction volume per type"
+                headers={['Type', 'Transactions', 'Volume', 'Average']}
+                report={volume}
+                loading={loading}
+            />
+        </div>
+    )
+}
+
+function ReportTable({ title, headers, report, loading }: {
+    title: string
+    headers: string[]
+    report: Report | null
+    loading: boolean
+}) {
+    const rows = report?.rows || []
+    return (
+        <Card>
+            <CardHeader>
+                <CardTitle>{title}</CardTitle>
+            </CardHeader>
+            <CardContent className="p-0">
+                <table className="w-full text-sm text-left">
+                    <thead className="bg-slate-50 text-slate-500 font-medium">
+                        <tr>
+                            {headers.map((h) => (
+                                <th key={h} className="px-4 py-3 border-b">{h}</th>
+                            ))}
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {loading ? (
+                            <tr><td colSpan={headers.length} className="p-4 text-center">Loading...</td></tr>
+                        ) : rows.length === 0 ? (
+                            <tr><td colSpan={headers.length} className="p-4 text-center text-slate-400">No data found</td></tr>
+                        ) : (
+                            rows.map((row, i) => (
+                                <tr key={i} className="border-b hover:bg-slate-50">
+                                    {row.map((cell, j) => (
+                                        <td key={j} className={j === 1 ? 'px-4 py-3 font-medium' : 'px-4 py-3 font-mono'}>
+                                            {cell ?? 'NULL'}
+                                        </td>
+                                    ))}
+                                </tr>
+                            ))
+                        )}
+                    </tbody>
+                </table>
+            </CardContent>
+        </Card>
+    )
+}
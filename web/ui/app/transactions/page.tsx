@@ -12,18 +12,24 @@ type Transaction = {
     type: string
 }
 
+const PAGE_SIZE = 50
+
 export default function TransactionsPage() {
     const [txs, setTxs] = useState<Transaction[]>([])
     const [loading, setLoading] = useState(true)
+    const [hasMore, setHasMore] = useState(false)
     const [isFormOpen, setIsFormOpen] = useState(false)
     const [formData, setFormData] = useState<Transaction>({ id: 0, wallet_id: 0, amount: '0.00', type: 'DEPOSIT' })
 
     const API_URL = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080';
 
-    const fetchTxs = async () => {
-        setLoading(true)
+    // Pages are read by key: each one starts after the last ID shown.
+    const fetchTxs = async (after?: number) => {
+        if (after === undefined) setLoading(true)
         try {
-            const res = await fetch(`${API_URL}/api/transactions`)
+            const params = new URLSearchParams({ limit: String(PAGE_SIZE) })
+            if (after !== undefined) params.set('after', String(after))
+            const res = await fetch(`${API_URL}/api/transactions?${params}`)
             const data = await res.json()
             if (data.error) throw new Error(data.error)
             
@@ -33,7 +39,8 @@ export default function TransactionsPage() {
                 amount: row[2],
                 type: row[3]
             })) : []
-            setTxs(list)
+            setTxs(prev => after === undefined ? list : [...prev, ...list])
+            setHasMore(list.length === PAGE_SIZE)
         } catch (err: any) {
             console.error(err)
         } finally {
@@ -168,6 +175,13 @@ export default function TransactionsPage() {
                             )}
                         </tbody>
                     </table>
+                    {hasMore && !loading && (
+                        <div className="p-4 text-center border-t">
+                            <Button variant="outline" onClick={() => fetchTxs(txs[txs.length - 1].id)}>
+                                Load more
+                            </Button>
+                        </div>
+                    )}
                 </CardContent>
             </Card>
         </div>
@@ -2,7 +2,7 @@
 
 import Link from 'next/link'
 import { usePathname } from 'next/navigation'
-import { Database, Terminal, Users, Wallet, ArrowRightLeft, BarChart3, Server, Settings } from 'lucide-react'
+import { Database, Terminal, Users, Wallet, ArrowRightLeft, BarChart3, Sigma, Server, Settings } from 'lucide-react'
 import { cn } from '@/lib/utils'
 
 export function Sidebar() {
@@ -54,7 +54,13 @@ export function Sidebar() {
                         href="/reports/user-wallets"
                         icon={<BarChart3 size={20} />}
                         label="Reports"
-                        active={pathname.startsWith('/reports')}
+                        active={pathname === '/reports/user-wallets'}
+                    />
+                    <NavItem
+                        href="/reports/totals"
+                        icon={<Sigma size={20} />}
+                        label="Totals"
+                        active={pathname === '/reports/totals'}
                     />
 
                     <div className="pt-4 pb-2">